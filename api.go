@@ -13,20 +13,68 @@ package protoc
 import (
 	"context"
 	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // Compiler provides a high-level API for compiling Protocol Buffer files.
 type Compiler struct {
-	protoDir     string // Directory containing .proto files to compile
-	workspaceDir string // Workspace directory for -I parameter
-	outputDir    string // Output directory for generated files
-	plugins      []string
-	goOpts       []string
-	goGrpcOpts   []string
-	verbose      bool
-	ctx          context.Context
+	protoDir       string // Directory containing .proto files to compile
+	workspaceDir   string // Workspace directory for -I parameter
+	protoFS        fs.FS  // File system replacing the workspace, if set
+	protoFSRoot    string // Directory of protoFS to compile
+	outputDir      string // Output directory for generated files
+	importPaths    []string
+	roots          []string
+	excludes       []string
+	plugins        []string
+	goOpts         []string
+	goGrpcOpts     []string
+	goPackageMap   map[string]string
+	goPkgPrefix    string
+	goPkgCheck     bool
+	goFormat       bool
+	fixImports     bool
+	headers        []headerRule
+	buildTags      []buildTagRule
+	postProcessors []PostProcessor
+	descPkgDir     string
+	descPkgName    string
+	generators     map[string]GeneratorFunc
+	backend        Backend
+	pluginOpts     map[string][]string
+	pluginOut      map[string]string
+	pluginPaths    map[string]string
+	strategies     map[string]Strategy
+	lintRules      []string
+	lintExcept     []string
+	breakingLevel  BreakingLevel
+	protocVer      string
+	protocSource   string
+	protocCache    string
+	wellKnownTypes bool
+	verbose        bool
+	ctx            context.Context
+
+	pollInterval time.Duration // Watch polling interval
+	debounce     time.Duration // Watch quiet period before recompiling
 }
 
+// Strategy controls how files are batched into protoc invocations for a
+// plugin.
+type Strategy string
+
+const (
+	// StrategyAll runs the plugin once with all files. This is the default.
+	StrategyAll Strategy = "all"
+	// StrategyDirectory runs the plugin once per directory, with the files
+	// of that directory only. Some plugins require this, and it matches
+	// the default of buf.
+	StrategyDirectory Strategy = "directory"
+)
+
 // NewCompiler creates a new Compiler with default options.
 func NewCompiler() *Compiler {
 	return &Compiler{
@@ -51,12 +99,49 @@ func (c *Compiler) WithProtoWorkSpace(dir string) *Compiler {
 	return c
 }
 
+// WithImportPaths adds include directories searched after the workspace
+// directory, for imports of files that live outside the workspace.
+// Each directory is passed to protoc as an additional -I parameter.
+func (c *Compiler) WithImportPaths(dirs ...string) *Compiler {
+	c.importPaths = append(c.importPaths, dirs...)
+	return c
+}
+
+// WithRoots restricts compilation to the given entry point files and
+// everything they import, directly or transitively, from within the
+// workspace directory. Each root is either a path relative to the workspace
+// directory (as used in import statements) or a filesystem path. When roots
+// are set, the proto directory is optional and is not searched.
+func (c *Compiler) WithRoots(files ...string) *Compiler {
+	c.roots = append(c.roots, files...)
+	return c
+}
+
+// WithExcludes skips files in the proto directory whose path relative to
+// the workspace directory matches one of the patterns. Patterns use
+// path.Match syntax with forward slashes; a pattern that matches a
+// directory excludes everything below it.
+func (c *Compiler) WithExcludes(patterns ...string) *Compiler {
+	c.excludes = append(c.excludes, patterns...)
+	return c
+}
+
 // WithOutputDir sets the output directory for generated files.
 func (c *Compiler) WithOutputDir(dir string) *Compiler {
 	c.outputDir = dir
 	return c
 }
 
+// WithPluginOutputDir sets the output directory for a single plugin,
+// overriding the output directory set with WithOutputDir.
+func (c *Compiler) WithPluginOutputDir(plugin, dir string) *Compiler {
+	if c.pluginOut == nil {
+		c.pluginOut = make(map[string]string)
+	}
+	c.pluginOut[plugin] = dir
+	return c
+}
+
 // WithPlugins sets which protoc plugins to use.
 func (c *Compiler) WithPlugins(plugins ...string) *Compiler {
 	c.plugins = plugins
@@ -75,12 +160,129 @@ func (c *Compiler) WithGoGrpcOpts(opts ...string) *Compiler {
 	return c
 }
 
+// WithGoPackageMapping sets the Go import path of .proto files, keyed by
+// their name relative to the include path, e.g.
+// "vendor/thing.proto": "example.com/gen/thing". The mapping is passed to
+// the go and go-grpc plugins as M options and takes precedence over the
+// go_package option of the files.
+func (c *Compiler) WithGoPackageMapping(mapping map[string]string) *Compiler {
+	if c.goPackageMap == nil {
+		c.goPackageMap = make(map[string]string)
+	}
+	for file, importPath := range mapping {
+		c.goPackageMap[file] = importPath
+	}
+	return c
+}
+
+// WithGoPackagePrefix infers the Go import path of files without a
+// go_package option from their location: a/b/c.proto becomes
+// <modulePath>/a/b. This covers the compiled files and every file they
+// import, including ones found in import paths.
+func (c *Compiler) WithGoPackagePrefix(modulePath string) *Compiler {
+	c.goPkgPrefix = strings.TrimSuffix(modulePath, "/")
+	return c
+}
+
+// WithGoPackageValidation enables or disables the check, before protoc
+// runs and in Check, that the go and go-grpc plugins can place every file:
+// each has a Go import path, its code lands in the directory of that
+// package when the output is inside a Go module (found through go.mod),
+// and no two files generate conflicting packages or the same file. It is
+// disabled by default, as it parses every source and can reject layouts
+// protoc itself accepts.
+func (c *Compiler) WithGoPackageValidation(enabled bool) *Compiler {
+	c.goPkgCheck = enabled
+	return c
+}
+
+// WithPluginOpts sets options for any plugin. Options for the go and
+// go-grpc plugins can also be set with WithGoOpts and WithGoGrpcOpts.
+func (c *Compiler) WithPluginOpts(plugin string, opts ...string) *Compiler {
+	switch plugin {
+	case "go":
+		return c.WithGoOpts(opts...)
+	case "go-grpc":
+		return c.WithGoGrpcOpts(opts...)
+	}
+	if c.pluginOpts == nil {
+		c.pluginOpts = make(map[string][]string)
+	}
+	c.pluginOpts[plugin] = opts
+	return c
+}
+
+// WithPluginPath sets the executable used for a plugin instead of looking
+// up protoc-gen-<plugin> in PATH. It is passed to protoc as
+// --plugin=protoc-gen-<plugin>=<path>.
+func (c *Compiler) WithPluginPath(plugin, path string) *Compiler {
+	if c.pluginPaths == nil {
+		c.pluginPaths = make(map[string]string)
+	}
+	c.pluginPaths[plugin] = path
+	return c
+}
+
+// WithPluginStrategy sets how files are batched into protoc invocations for
+// a plugin. Plugins use StrategyAll unless set otherwise.
+func (c *Compiler) WithPluginStrategy(plugin string, strategy Strategy) *Compiler {
+	if c.strategies == nil {
+		c.strategies = make(map[string]Strategy)
+	}
+	c.strategies[plugin] = strategy
+	return c
+}
+
+// WithLintRules selects the rules Lint runs, by name, instead of all of
+// them. See LintRules for the available rules.
+func (c *Compiler) WithLintRules(rules ...string) *Compiler {
+	c.lintRules = append(c.lintRules, rules...)
+	return c
+}
+
+// WithLintExcept disables lint rules by name.
+func (c *Compiler) WithLintExcept(rules ...string) *Compiler {
+	c.lintExcept = append(c.lintExcept, rules...)
+	return c
+}
+
+// WithBreakingLevel sets how strictly Breaking compares against the
+// baseline. The default is BreakingFile.
+func (c *Compiler) WithBreakingLevel(level BreakingLevel) *Compiler {
+	c.breakingLevel = level
+	return c
+}
+
+// WithProtocVersion requires the protoc found in PATH to have the given
+// version. A partial version matches any release it is a prefix of, so
+// "28" accepts 28.0 and 28.3 while "3.21.12" accepts only that release.
+// It also selects the release installed from WithProtocSource.
+func (c *Compiler) WithProtocVersion(version string) *Compiler {
+	c.protocVer = version
+	return c
+}
+
 // WithVerbose enables verbose output.
 func (c *Compiler) WithVerbose(verbose bool) *Compiler {
 	c.verbose = verbose
 	return c
 }
 
+// WithPollInterval sets how often Watch checks the sources for changes.
+// The default is 500ms.
+func (c *Compiler) WithPollInterval(d time.Duration) *Compiler {
+	c.pollInterval = d
+	return c
+}
+
+// WithDebounce sets how long the sources must stay unchanged before Watch
+// recompiles, so that a burst of saves triggers a single compilation.
+// The default is 200ms.
+func (c *Compiler) WithDebounce(d time.Duration) *Compiler {
+	c.debounce = d
+	return c
+}
+
 // WithContext sets the context for cancellation and timeout.
 func (c *Compiler) WithContext(ctx context.Context) *Compiler {
 	c.ctx = ctx
@@ -89,29 +291,78 @@ func (c *Compiler) WithContext(ctx context.Context) *Compiler {
 
 // Compile compiles all .proto files in the configured directory.
 func (c *Compiler) Compile() (string, error) {
-	if c.protoDir == "" {
+	return c.compile(c.ctx, nil)
+}
+
+// compile validates the builder settings and compiles with the given
+// context, collecting details in result unless it is nil.
+func (c *Compiler) compile(ctx context.Context, result *CompileResult) (string, error) {
+	if c.protoDir == "" && len(c.roots) == 0 && c.protoFS == nil {
 		return "", fmt.Errorf("proto directory not specified")
 	}
-	if c.workspaceDir == "" {
+	if c.workspaceDir == "" && c.protoFS == nil {
 		return "", fmt.Errorf("workspace directory not specified")
 	}
 	if c.outputDir == "" {
 		return "", fmt.Errorf("output directory not specified")
 	}
 
-	// Create a new compiler instance to avoid mutating the original
-	compiler := &compilerImpl{
-		protoDir:     c.protoDir,
-		workspaceDir: c.workspaceDir,
-		outputDir:    c.outputDir,
-		plugins:      c.plugins,
-		goOpts:       c.goOpts,
-		goGrpcOpts:   c.goGrpcOpts,
-		verbose:      c.verbose,
-		ctx:          c.ctx,
+	impl := c.newImpl()
+	impl.ctx = ctx
+	impl.result = result
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	if _, dir := fsDir(c.protoFS); result != nil && (c.protoFS == nil || dir) {
+		result.Workspace, _ = filepath.Abs(impl.workspaceDir)
 	}
+	output, err := impl.compile()
+	impl.recordParseError(err)
+	return output, err
+}
 
-	return compiler.compile()
+// newImpl creates a new compiler instance to avoid mutating the original.
+func (c *Compiler) newImpl() *compilerImpl {
+	return &compilerImpl{
+		protoDir:       c.protoDir,
+		workspaceDir:   c.workspaceDir,
+		protoFS:        c.protoFS,
+		protoFSRoot:    c.protoFSRoot,
+		outputDir:      c.outputDir,
+		importPaths:    c.importPaths,
+		roots:          c.roots,
+		excludes:       c.excludes,
+		plugins:        c.plugins,
+		goOpts:         c.goOpts,
+		goGrpcOpts:     c.goGrpcOpts,
+		goPackageMap:   c.goPackageMap,
+		goPkgPrefix:    c.goPkgPrefix,
+		goPkgCheck:     c.goPkgCheck,
+		goFormat:       c.goFormat,
+		fixImports:     c.fixImports,
+		headers:        c.headers,
+		buildTags:      c.buildTags,
+		postProcessors: c.postProcessors,
+		descPkgDir:     c.descPkgDir,
+		descPkgName:    c.descPkgName,
+		generators:     c.generators,
+		backend:        c.backend,
+		pluginOpts:     c.pluginOpts,
+		pluginOut:      c.pluginOut,
+		pluginPaths:    c.pluginPaths,
+		strategies:     c.strategies,
+		lintRules:      c.lintRules,
+		lintExcept:     c.lintExcept,
+		breakingLevel:  c.breakingLevel,
+		protocVer:      c.protocVer,
+		protocSource:   c.protocSource,
+		protocCache:    c.protocCache,
+		wellKnownTypes: c.wellKnownTypes,
+		verbose:        c.verbose,
+		ctx:            c.ctx,
+	}
 }
 
 // Compile is a convenience function that compiles .proto files with default options.
@@ -0,0 +1,223 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/reporter"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Backend turns .proto files into descriptors.
+type Backend interface {
+	// Descriptors parses and links files, named relative to the include
+	// directories, and returns the descriptors of the files and of
+	// everything they import, dependencies first, with source code info.
+	Descriptors(ctx context.Context, includes, files []string) (*descriptorpb.FileDescriptorSet, error)
+}
+
+// ProtocBackend is the default Backend. It runs the protoc binary, which
+// also runs the plugins.
+type ProtocBackend struct {
+	// Path is the protoc binary to run. The compiler uses protoc from PATH
+	// or from the source set with WithProtocSource if it is empty.
+	Path string
+}
+
+// Descriptors runs protoc with --descriptor_set_out.
+func (b ProtocBackend) Descriptors(ctx context.Context, includes, files []string) (*descriptorpb.FileDescriptorSet, error) {
+	tmp, err := os.MkdirTemp("", "protoc-go-descriptors-")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	out := filepath.Join(tmp, "descriptors.binpb")
+
+	args := []string{"--descriptor_set_out=" + filepath.ToSlash(out), "--include_source_info", "--include_imports"}
+	for _, dir := range includes {
+		args = append(args, "-I", filepath.ToSlash(dir))
+	}
+	args = append(args, files...)
+	protoc := b.Path
+	if protoc == "" {
+		protoc = "protoc"
+	}
+	if output, err := exec.CommandContext(ctx, protoc, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("protoc execution failed: %w\n%s", err, output)
+	}
+	return readDescriptorSet(out)
+}
+
+// GoBackend is a Backend that parses and links the files in Go, without
+// the protoc binary. The compiler then runs the plugins itself, sending
+// them a CodeGeneratorRequest, so only the plugin executables are needed.
+// The well-known types, such as google/protobuf/timestamp.proto, are built
+// in. The languages protoc implements itself, such as cpp or java, are not
+// available.
+type GoBackend struct{}
+
+// Descriptors compiles the files in-process.
+func (GoBackend) Descriptors(ctx context.Context, includes, files []string) (*descriptorpb.FileDescriptorSet, error) {
+	var errs []error
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: includes,
+		}),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+		Reporter: reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+			errs = append(errs, err)
+			return nil
+		}, nil),
+	}
+	linked, err := compiler.Compile(ctx, files...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("compilation failed:\n%w", errors.Join(errs...))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compilation failed: %w", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, fileDescriptorProto(fd))
+	}
+	for _, fd := range linked {
+		add(fd)
+	}
+	return set, nil
+}
+
+// fileDescriptorProto returns the descriptor proto of a compiled file,
+// keeping the json_name and source info the compiler produced.
+func fileDescriptorProto(fd protoreflect.FileDescriptor) *descriptorpb.FileDescriptorProto {
+	if res, ok := fd.(interface {
+		FileDescriptorProto() *descriptorpb.FileDescriptorProto
+	}); ok {
+		return proto.Clone(res.FileDescriptorProto()).(*descriptorpb.FileDescriptorProto)
+	}
+	return protodesc.ToFileDescriptorProto(fd)
+}
+
+// WithBackend sets how the .proto files are compiled. The default is
+// ProtocBackend; GoBackend needs no protoc installation.
+func (c *Compiler) WithBackend(backend Backend) *Compiler {
+	c.backend = backend
+	return c
+}
+
+// ParseBackend returns the backend with the given name, "protoc" or "go".
+func ParseBackend(name string) (Backend, error) {
+	switch strings.ToLower(name) {
+	case "", "protoc":
+		return ProtocBackend{}, nil
+	case "go":
+		return GoBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", name)
+}
+
+// usesProtoc reports whether the protoc binary compiles the files and runs
+// the plugins.
+func (c *compilerImpl) usesProtoc() bool {
+	switch c.backend.(type) {
+	case nil, ProtocBackend, *ProtocBackend:
+		return true
+	}
+	return false
+}
+
+// includeDirs returns the include directories, in search order.
+func (c *compilerImpl) includeDirs() []string {
+	dirs := append([]string{c.workspaceDir}, c.importPaths...)
+	return append(dirs, c.wellKnownIncludes()...)
+}
+
+// runPlugins compiles files with the backend and runs plugins on the
+// result by sending them a CodeGeneratorRequest: in-process generators
+// directly, others by executing them.
+func (c *compilerImpl) runPlugins(plugins, files []string) error {
+	set, err := c.descriptorSet(files, true)
+	if err != nil {
+		return err
+	}
+	names, err := c.importNames(files)
+	if err != nil {
+		return err
+	}
+
+	for _, plugin := range plugins {
+		req := generatorRequest(set, names, strings.Join(c.pluginParameter(plugin), ","))
+		var resp *pluginpb.CodeGeneratorResponse
+		if gen, ok := c.generator(plugin); ok {
+			if c.verbose {
+				fmt.Printf("Running %s in-process\n", plugin)
+			}
+			if resp, err = gen(req); err != nil {
+				return fmt.Errorf("plugin %s: %w", plugin, err)
+			}
+		} else if resp, err = c.execPlugin(plugin, req); err != nil {
+			return err
+		}
+		if err := c.writeResponse(plugin, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execPlugin runs a plugin executable with a request.
+func (c *compilerImpl) execPlugin(plugin string, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	path, ok := c.pluginPaths[plugin]
+	if !ok || path == "" {
+		if builtinPlugins[plugin] {
+			return nil, fmt.Errorf("plugin %s is built into protoc and needs the protoc backend", plugin)
+		}
+		var err error
+		if path, err = exec.LookPath("protoc-gen-" + plugin); err != nil {
+			return nil, fmt.Errorf("protoc-gen-%s not found in PATH", plugin)
+		}
+	}
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.verbose {
+		fmt.Printf("Executing: %s\n", path)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(c.ctx, path)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %w\n%s", plugin, err, stderr.Bytes())
+	}
+
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid response: %w", plugin, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,133 @@
+package protoc_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestGoBackend(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto": `syntax = "proto3";
+package a;
+import "b/b.proto";
+import "google/protobuf/timestamp.proto";
+// A is documented.
+message A { b.B b = 1; google.protobuf.Timestamp at = 2; }
+`,
+		"b/b.proto": `syntax = "proto3"; package b; message B {}`,
+	})
+
+	var req *pluginpb.CodeGeneratorRequest
+	record := func(r *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		req = r
+		return &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+			{Name: proto.String("params.txt"), Content: proto.String(r.GetParameter())},
+		}}, nil
+	}
+
+	// Without protoc in PATH
+	t.Setenv("PATH", tmpDir)
+	_, err := protoc.NewCompiler().
+		WithProtoDir(filepath.Join(workspace, "a")).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(gen).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins("record").
+		WithGenerator("record", record).
+		WithPluginOpts("record", "x=1").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var names []string
+	for _, file := range req.GetProtoFile() {
+		names = append(names, file.GetName())
+	}
+	if got := strings.Join(names, " "); got != "b/b.proto google/protobuf/timestamp.proto a/a.proto" {
+		t.Errorf("ProtoFile = %s, want dependencies first", got)
+	}
+	if got := req.GetFileToGenerate(); len(got) != 1 || got[0] != "a/a.proto" {
+		t.Errorf("FileToGenerate = %v, want [a/a.proto]", got)
+	}
+	a := req.GetProtoFile()[2]
+	if got := a.GetMessageType()[0].GetField()[1].GetJsonName(); got != "at" {
+		t.Errorf("json_name = %q, want at", got)
+	}
+	var comments string
+	for _, loc := range a.GetSourceCodeInfo().GetLocation() {
+		comments += loc.GetLeadingComments()
+	}
+	if !strings.Contains(comments, "A is documented.") {
+		t.Errorf("expected source info with comments, got %q", comments)
+	}
+	if got, _ := os.ReadFile(filepath.Join(gen, "params.txt")); string(got) != "x=1" {
+		t.Errorf("params.txt = %q, want x=1", got)
+	}
+}
+
+func TestGoBackendPlugins(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, workspace, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+	stub := writeStubPlugin(t, tmpDir, "stub", map[string]string{"stub.txt": "generated\n"})
+
+	compiler := func(plugins ...string) *protoc.Compiler {
+		return protoc.NewCompiler().
+			WithProtoDir(workspace).
+			WithProtoWorkSpace(workspace).
+			WithOutputDir(gen).
+			WithBackend(protoc.GoBackend{}).
+			WithPlugins(plugins...).
+			WithPluginPath("stub", stub)
+	}
+
+	if _, err := compiler("stub").Compile(); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(gen, "stub.txt")); string(got) != "generated\n" {
+		t.Errorf("stub.txt = %q", got)
+	}
+
+	tests := []struct {
+		plugin string
+		want   string
+	}{
+		{"java", "plugin java is built into protoc and needs the protoc backend"},
+		{"nope", "protoc-gen-nope not found in PATH"},
+	}
+	for _, tt := range tests {
+		if _, err := compiler(tt.plugin).Compile(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("plugin %s: expected error containing %q, got: %v", tt.plugin, tt.want, err)
+		}
+	}
+}
+
+func TestGoBackendErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"a.proto": "syntax = \"proto3\";\npackage a;\nmessage A { Missing m = 1; }\n",
+	})
+
+	_, err := protoc.NewCompiler().
+		WithProtoDir(tmpDir).
+		WithProtoWorkSpace(tmpDir).
+		WithOutputDir(filepath.Join(tmpDir, "gen")).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins().
+		Compile()
+	if err == nil || !strings.Contains(err.Error(), `a.proto:3:13: field a.A.m: unknown type Missing`) {
+		t.Errorf("expected a positioned error, got: %v", err)
+	}
+}
@@ -0,0 +1,787 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// BreakingLevel selects how strictly Breaking compares against the
+// baseline.
+type BreakingLevel int
+
+// Breaking levels, from the strictest to the loosest.
+const (
+	// BreakingFile reports every change that breaks generated code: in
+	// addition to the WIRE_JSON checks, deleted files, messages and
+	// enums, and renamed fields.
+	BreakingFile BreakingLevel = iota
+	// BreakingWireJSON reports changes that break the binary or the JSON
+	// encoding: in addition to the WIRE checks, changed JSON names, enum
+	// value names and reserved names.
+	BreakingWireJSON
+	// BreakingWire reports changes that break the binary encoding and
+	// RPCs: deleted or renumbered fields and enum values whose number is
+	// not reserved, incompatible field types and cardinality, removed
+	// reserved ranges, renamed packages and deleted or changed RPCs.
+	BreakingWire
+)
+
+// String returns the name of the level: FILE, WIRE_JSON or WIRE.
+func (l BreakingLevel) String() string {
+	switch l {
+	case BreakingFile:
+		return "FILE"
+	case BreakingWireJSON:
+		return "WIRE_JSON"
+	case BreakingWire:
+		return "WIRE"
+	}
+	return fmt.Sprintf("BreakingLevel(%d)", int(l))
+}
+
+// ParseBreakingLevel returns the level with the given name.
+func ParseBreakingLevel(name string) (BreakingLevel, error) {
+	for _, level := range []BreakingLevel{BreakingFile, BreakingWireJSON, BreakingWire} {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown breaking level %q, expected FILE, WIRE_JSON or WIRE", name)
+}
+
+// Breaking change rules.
+const (
+	// BreakingFileNoDelete forbids deleting a file.
+	BreakingFileNoDelete = "FILE_NO_DELETE"
+	// BreakingMessageNoDelete forbids deleting a message.
+	BreakingMessageNoDelete = "MESSAGE_NO_DELETE"
+	// BreakingEnumNoDelete forbids deleting an enum.
+	BreakingEnumNoDelete = "ENUM_NO_DELETE"
+	// BreakingFieldSameName forbids renaming a field.
+	BreakingFieldSameName = "FIELD_SAME_NAME"
+	// BreakingFieldSameJSONName forbids changing a field's JSON name.
+	BreakingFieldSameJSONName = "FIELD_SAME_JSON_NAME"
+	// BreakingEnumValueSameName forbids renaming an enum value.
+	BreakingEnumValueSameName = "ENUM_VALUE_SAME_NAME"
+	// BreakingReservedNameNoDelete forbids removing a reserved name.
+	BreakingReservedNameNoDelete = "RESERVED_NAME_NO_DELETE"
+	// BreakingFileSamePackage forbids changing the package of a file.
+	BreakingFileSamePackage = "FILE_SAME_PACKAGE"
+	// BreakingFieldNoDelete forbids deleting a field. At WIRE_JSON it may
+	// be deleted if its number and name are reserved, at WIRE if its
+	// number is.
+	BreakingFieldNoDelete = "FIELD_NO_DELETE"
+	// BreakingFieldSameNumber forbids changing the number of a field.
+	BreakingFieldSameNumber = "FIELD_SAME_NUMBER"
+	// BreakingFieldSameType forbids incompatible changes of a field's type.
+	BreakingFieldSameType = "FIELD_SAME_TYPE"
+	// BreakingFieldSameCardinality forbids changing a field's cardinality.
+	BreakingFieldSameCardinality = "FIELD_SAME_CARDINALITY"
+	// BreakingEnumValueNoDelete forbids deleting an enum value, with the
+	// same exceptions as BreakingFieldNoDelete.
+	BreakingEnumValueNoDelete = "ENUM_VALUE_NO_DELETE"
+	// BreakingReservedNoDelete forbids removing a reserved number or range.
+	BreakingReservedNoDelete = "RESERVED_NO_DELETE"
+	// BreakingServiceNoDelete forbids deleting a service.
+	BreakingServiceNoDelete = "SERVICE_NO_DELETE"
+	// BreakingRPCNoDelete forbids deleting an RPC.
+	BreakingRPCNoDelete = "RPC_NO_DELETE"
+	// BreakingRPCSameRequestType forbids changing an RPC's request type.
+	BreakingRPCSameRequestType = "RPC_SAME_REQUEST_TYPE"
+	// BreakingRPCSameResponseType forbids changing an RPC's response type.
+	BreakingRPCSameResponseType = "RPC_SAME_RESPONSE_TYPE"
+	// BreakingRPCSameStreaming forbids changing whether the request or the
+	// response of an RPC is streamed.
+	BreakingRPCSameStreaming = "RPC_SAME_STREAMING"
+)
+
+// breakingRules maps each breaking change rule to the loosest level that
+// still reports it.
+var breakingRules = map[string]BreakingLevel{
+	BreakingFileNoDelete:         BreakingFile,
+	BreakingMessageNoDelete:      BreakingFile,
+	BreakingEnumNoDelete:         BreakingFile,
+	BreakingFieldSameName:        BreakingFile,
+	BreakingFieldSameJSONName:    BreakingWireJSON,
+	BreakingEnumValueSameName:    BreakingWireJSON,
+	BreakingReservedNameNoDelete: BreakingWireJSON,
+	BreakingFileSamePackage:      BreakingWire,
+	BreakingFieldNoDelete:        BreakingWire,
+	BreakingFieldSameNumber:      BreakingWire,
+	BreakingFieldSameType:        BreakingWire,
+	BreakingFieldSameCardinality: BreakingWire,
+	BreakingEnumValueNoDelete:    BreakingWire,
+	BreakingReservedNoDelete:     BreakingWire,
+	BreakingServiceNoDelete:      BreakingWire,
+	BreakingRPCNoDelete:          BreakingWire,
+	BreakingRPCSameRequestType:   BreakingWire,
+	BreakingRPCSameResponseType:  BreakingWire,
+	BreakingRPCSameStreaming:     BreakingWire,
+}
+
+// Breaking compares the discovered files with a baseline and reports the
+// changes that break compatibility at the configured level (see
+// WithBreakingLevel; FILE by default), sorted by position in the current
+// files.
+//
+// The baseline is either a descriptor set file written by
+// protoc --descriptor_set_out (ideally with --include_imports and
+// --include_source_info), or a directory holding an older copy of the
+// workspace, such as a git worktree of the main branch. For a directory,
+// the proto directory, roots and import paths inside the workspace are
+// looked up at the same relative locations below it.
+//
+// Both sides are compiled into descriptors with the configured backend
+// (see WithBackend), so protoc is only needed with ProtocBackend; GoBackend
+// compiles without it. No plugins are run.
+func (c *Compiler) Breaking(ctx context.Context, baseline string) ([]Diagnostic, error) {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if err := impl.validateSources(); err != nil {
+		return nil, err
+	}
+	if err := impl.checkProtocAvailable(); err != nil {
+		return nil, err
+	}
+	if err := impl.checkProtocVersion(); err != nil {
+		return nil, err
+	}
+
+	files, err := impl.collectFiles()
+	if err != nil {
+		return nil, err
+	}
+	current, err := impl.descriptorSet(files, false)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(baseline)
+	if err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+	var base *descriptorpb.FileDescriptorSet
+	if info.IsDir() {
+		baseImpl := c.newImpl()
+		baseImpl.ctx = ctx
+		// The baseline is a directory even with a proto file system
+		baseImpl.protoFS = nil
+		baseImpl.workspaceDir, baseImpl.protoDir = impl.workspaceDir, impl.protoDir
+		if err := baseImpl.rebase(baseline); err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+		if err := baseImpl.validateSources(); err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+		baseFiles, err := baseImpl.collectFiles()
+		if err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+		if base, err = baseImpl.descriptorSet(baseFiles, false); err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+	} else {
+		if base, err = readDescriptorSet(baseline); err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+		if err := impl.selectBaselineFiles(base, files); err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+	}
+
+	b := &breaker{
+		level:   c.breakingLevel,
+		current: indexDescriptors(current),
+		base:    indexDescriptors(base),
+		renames: make(map[string]string),
+	}
+	return b.compare(), nil
+}
+
+// selectBaselineFiles restricts a baseline descriptor set to the files the
+// current side compiles, dropping the imports protoc adds with
+// --include_imports. A baseline file that no longer exists is kept when it
+// would have been compiled: with a proto directory, when it is below it
+// and not excluded; with roots, when a root imports it, directly or
+// transitively, and it is not found outside the workspace.
+func (c *compilerImpl) selectBaselineFiles(set *descriptorpb.FileDescriptorSet, files []string) error {
+	names, err := c.importNames(files)
+	if err != nil {
+		return err
+	}
+	compiled := make(map[string]bool, len(names))
+	for _, name := range names {
+		compiled[name] = true
+	}
+
+	includes := c.includePaths()
+	workspaceFile := func(name string) bool {
+		if isWellKnownType(name) {
+			return false
+		}
+		path := resolveImport(includes, name)
+		return path == "" || c.inWorkspace(path)
+	}
+
+	keep := make(map[string]bool)
+	if len(c.roots) > 0 {
+		byName := make(map[string]*descriptorpb.FileDescriptorProto)
+		for _, file := range set.GetFile() {
+			byName[file.GetName()] = file
+		}
+		roots, err := c.resolveRoots()
+		if err != nil {
+			return err
+		}
+		queue, err := c.importNames(roots)
+		if err != nil {
+			return err
+		}
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			if keep[name] || !workspaceFile(name) {
+				continue
+			}
+			keep[name] = true
+			queue = append(queue, byName[name].GetDependency()...)
+		}
+	} else {
+		absProtoDir, err := filepath.Abs(c.protoDir)
+		if err != nil {
+			return err
+		}
+		dir, err := c.importName(absProtoDir)
+		if err != nil {
+			return err
+		}
+		for _, file := range set.GetFile() {
+			name := file.GetName()
+			deleted := !isWellKnownType(name) && resolveImport(includes, name) == ""
+			if deleted && (dir == "." || strings.HasPrefix(name, dir+"/")) &&
+				!c.excluded(filepath.Join(c.workspaceDir, filepath.FromSlash(name))) {
+				keep[name] = true
+			}
+		}
+	}
+
+	var own []*descriptorpb.FileDescriptorProto
+	for _, file := range set.GetFile() {
+		if compiled[file.GetName()] || keep[file.GetName()] {
+			own = append(own, file)
+		}
+	}
+	set.File = own
+	return nil
+}
+
+// rebase moves the workspace to dir, together with the proto directory,
+// roots and import paths located inside the workspace.
+func (c *compilerImpl) rebase(dir string) error {
+	workspace, err := filepath.Abs(c.workspaceDir)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	move := func(path string) string {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return path
+		}
+		rel, err := filepath.Rel(workspace, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path
+		}
+		return filepath.Join(target, rel)
+	}
+
+	if c.protoDir != "" {
+		c.protoDir = move(c.protoDir)
+	}
+	importPaths := make([]string, len(c.importPaths))
+	for i, path := range c.importPaths {
+		importPaths[i] = move(path)
+	}
+	c.importPaths = importPaths
+	roots := make([]string, len(c.roots))
+	for i, root := range c.roots {
+		if filepath.IsAbs(root) {
+			root = move(root)
+		}
+		roots[i] = root
+	}
+	c.roots = roots
+	c.workspaceDir = target
+	return nil
+}
+
+// descFile is a file of a descriptor set with its source locations.
+type descFile struct {
+	proto *descriptorpb.FileDescriptorProto
+	locs  sourceLocations
+}
+
+// descElement is a message, enum or service of a descriptor set, with the
+// source info path it is declared at.
+type descElement struct {
+	file    *descFile
+	path    []int32
+	message *descriptorpb.DescriptorProto
+	enum    *descriptorpb.EnumDescriptorProto
+	service *descriptorpb.ServiceDescriptorProto
+}
+
+// descIndex indexes a descriptor set by file name and fully qualified
+// element name, without the leading dot.
+type descIndex struct {
+	files    map[string]*descFile
+	messages map[string]*descElement
+	enums    map[string]*descElement
+	services map[string]*descElement
+}
+
+func indexDescriptors(set *descriptorpb.FileDescriptorSet) *descIndex {
+	idx := &descIndex{
+		files:    make(map[string]*descFile),
+		messages: make(map[string]*descElement),
+		enums:    make(map[string]*descElement),
+		services: make(map[string]*descElement),
+	}
+	for _, fd := range set.GetFile() {
+		file := &descFile{proto: fd, locs: locations(fd)}
+		idx.files[fd.GetName()] = file
+		prefix := ""
+		if fd.GetPackage() != "" {
+			prefix = fd.GetPackage() + "."
+		}
+		for i, msg := range fd.GetMessageType() {
+			idx.addMessage(file, prefix, []int32{fileDescriptorMessageType, int32(i)}, msg)
+		}
+		for i, enum := range fd.GetEnumType() {
+			idx.enums[prefix+enum.GetName()] = &descElement{file: file, path: []int32{fileDescriptorEnumType, int32(i)}, enum: enum}
+		}
+		for i, svc := range fd.GetService() {
+			idx.services[prefix+svc.GetName()] = &descElement{file: file, path: []int32{fileDescriptorService, int32(i)}, service: svc}
+		}
+	}
+	return idx
+}
+
+func (idx *descIndex) addMessage(file *descFile, prefix string, path []int32, msg *descriptorpb.DescriptorProto) {
+	name := prefix + msg.GetName()
+	idx.messages[name] = &descElement{file: file, path: path, message: msg}
+	for i, nested := range msg.GetNestedType() {
+		idx.addMessage(file, name+".", appendPath(path, messageDescriptorNested, int32(i)), nested)
+	}
+	for i, enum := range msg.GetEnumType() {
+		idx.enums[name+"."+enum.GetName()] = &descElement{file: file, path: appendPath(path, messageDescriptorEnumType, int32(i)), enum: enum}
+	}
+}
+
+// breaker compares a baseline with the current descriptors.
+type breaker struct {
+	level   BreakingLevel
+	current *descIndex
+	base    *descIndex
+	renames map[string]string // Renamed packages, from the baseline name
+	diags   []Diagnostic
+}
+
+// report records a breaking change at the element at path, or at the
+// file itself if file has no source info for it.
+func (b *breaker) report(file *descFile, name string, path []int32, rule, format string, args ...interface{}) {
+	if b.level > breakingRules[rule] {
+		return
+	}
+	d := Diagnostic{File: name, Rule: rule, Message: fmt.Sprintf(format, args...)}
+	if file != nil {
+		d.File = file.proto.GetName()
+		if d.Line, d.Column = file.locs.position(appendPath(path, descriptorName)); d.Line == 0 {
+			d.Line, d.Column = file.locs.position(path)
+		}
+	}
+	b.diags = append(b.diags, d)
+}
+
+// reportAt records a breaking change on a current element.
+func (b *breaker) reportAt(el *descElement, path []int32, rule, format string, args ...interface{}) {
+	b.report(el.file, "", path, rule, format, args...)
+}
+
+// reportMissing records a deleted element at its parent in the current
+// files: the enclosing message, or the package statement of the file it
+// was declared in. Elements of deleted files are reported against the
+// file name only.
+func (b *breaker) reportMissing(old *descElement, parent, rule, format string, args ...interface{}) {
+	if cur, ok := b.current.messages[b.rename(parent)]; ok {
+		b.reportAt(cur, cur.path, rule, format, args...)
+		return
+	}
+	name := old.file.proto.GetName()
+	if file, ok := b.current.files[name]; ok {
+		b.report(file, name, []int32{fileDescriptorPackage}, rule, format, args...)
+		return
+	}
+	if breakingRules[rule] == BreakingFile {
+		// Already reported as FILE_NO_DELETE.
+		return
+	}
+	b.report(nil, name, nil, rule, format, args...)
+}
+
+// rename translates a fully qualified baseline name to the current
+// package name.
+func (b *breaker) rename(name string) string {
+	best := ""
+	for old := range b.renames {
+		if (name == old || strings.HasPrefix(name, old+".")) && len(old) > len(best) {
+			best = old
+		}
+	}
+	if best == "" {
+		return name
+	}
+	return b.renames[best] + strings.TrimPrefix(name, best)
+}
+
+func (b *breaker) compare() []Diagnostic {
+	for _, name := range sortedKeys(b.base.files) {
+		old := b.base.files[name]
+		cur, ok := b.current.files[name]
+		if !ok {
+			b.report(nil, name, nil, BreakingFileNoDelete, "file %s was deleted", name)
+			continue
+		}
+		if oldPkg, curPkg := old.proto.GetPackage(), cur.proto.GetPackage(); oldPkg != curPkg {
+			b.report(cur, name, []int32{fileDescriptorPackage}, BreakingFileSamePackage, "package changed from %q to %q", oldPkg, curPkg)
+			if oldPkg != "" && curPkg != "" {
+				b.renames[oldPkg] = curPkg
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(b.base.messages) {
+		old := b.base.messages[name]
+		cur, ok := b.current.messages[b.rename(name)]
+		if !ok {
+			b.reportMissing(old, parentName(name), BreakingMessageNoDelete, "message %s was deleted", name)
+			continue
+		}
+		b.message(name, old, cur)
+	}
+	for _, name := range sortedKeys(b.base.enums) {
+		old := b.base.enums[name]
+		cur, ok := b.current.enums[b.rename(name)]
+		if !ok {
+			b.reportMissing(old, parentName(name), BreakingEnumNoDelete, "enum %s was deleted", name)
+			continue
+		}
+		b.enum(name, old, cur)
+	}
+	for _, name := range sortedKeys(b.base.services) {
+		old := b.base.services[name]
+		cur, ok := b.current.services[b.rename(name)]
+		if !ok {
+			b.reportMissing(old, "", BreakingServiceNoDelete, "service %s was deleted", name)
+			continue
+		}
+		b.service(name, old, cur)
+	}
+
+	sortDiagnostics(b.diags)
+	return b.diags
+}
+
+// parentName returns the enclosing scope of a fully qualified name.
+func parentName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func (b *breaker) message(name string, old, cur *descElement) {
+	var oldRanges, curRanges [][2]int64
+	for _, r := range old.message.GetReservedRange() {
+		oldRanges = append(oldRanges, [2]int64{int64(r.GetStart()), int64(r.GetEnd())})
+	}
+	for _, r := range cur.message.GetReservedRange() {
+		curRanges = append(curRanges, [2]int64{int64(r.GetStart()), int64(r.GetEnd())})
+	}
+
+	curFields := make(map[int32]int)
+	curNames := make(map[string]int)
+	for i, field := range cur.message.GetField() {
+		curFields[field.GetNumber()] = i
+		curNames[field.GetName()] = i
+	}
+
+	for _, field := range old.message.GetField() {
+		i, ok := curFields[field.GetNumber()]
+		if !ok {
+			if j, ok := curNames[field.GetName()]; ok {
+				moved := cur.message.GetField()[j]
+				b.reportAt(cur, appendPath(cur.path, messageDescriptorField, int32(j)), BreakingFieldSameNumber,
+					"field %s.%s changed number from %d to %d", name, field.GetName(), field.GetNumber(), moved.GetNumber())
+				continue
+			}
+			b.fieldDeleted(name, field, cur, curRanges)
+			continue
+		}
+		b.field(name, field, cur.message.GetField()[i], cur, appendPath(cur.path, messageDescriptorField, int32(i)))
+	}
+
+	b.reserved(name, cur, oldRanges, curRanges, old.message.GetReservedName(), cur.message.GetReservedName())
+}
+
+// fieldDeleted reports a deleted field, unless the level allows deleting
+// it because its number, and for JSON its name, are reserved.
+func (b *breaker) fieldDeleted(name string, field *descriptorpb.FieldDescriptorProto, cur *descElement, curRanges [][2]int64) {
+	numberReserved := rangesCover(curRanges, int64(field.GetNumber()), int64(field.GetNumber())+1)
+	nameReserved := containsString(cur.message.GetReservedName(), field.GetName())
+
+	switch {
+	case b.level == BreakingFile:
+		b.reportAt(cur, cur.path, BreakingFieldNoDelete, "field %d %q of %s was deleted", field.GetNumber(), field.GetName(), name)
+	case !numberReserved:
+		b.reportAt(cur, cur.path, BreakingFieldNoDelete, "field %d %q of %s was deleted without reserving its number", field.GetNumber(), field.GetName(), name)
+	case b.level == BreakingWireJSON && !nameReserved:
+		b.reportAt(cur, cur.path, BreakingFieldNoDelete, "field %d %q of %s was deleted without reserving its name", field.GetNumber(), field.GetName(), name)
+	}
+}
+
+func (b *breaker) field(msgName string, old, cur *descriptorpb.FieldDescriptorProto, el *descElement, path []int32) {
+	if old.GetName() != cur.GetName() {
+		b.reportAt(el, path, BreakingFieldSameName, "field %d of %s changed name from %q to %q", old.GetNumber(), msgName, old.GetName(), cur.GetName())
+	}
+	if oldJSON, curJSON := fieldJSONName(old), fieldJSONName(cur); oldJSON != curJSON {
+		b.reportAt(el, path, BreakingFieldSameJSONName, "field %d of %s changed JSON name from %q to %q", old.GetNumber(), msgName, oldJSON, curJSON)
+	}
+	if oldRepeated, curRepeated := old.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED,
+		cur.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED; oldRepeated != curRepeated {
+		b.reportAt(el, path, BreakingFieldSameCardinality, "field %d %q of %s changed from %s to %s",
+			old.GetNumber(), cur.GetName(), msgName, cardinality(old), cardinality(cur))
+	}
+
+	oldType, curType := b.fieldType(old, true), b.fieldType(cur, false)
+	if oldType == curType {
+		return
+	}
+	if b.level == BreakingWire {
+		if group := wireCompatible[old.GetType()]; group != 0 && group == wireCompatible[cur.GetType()] {
+			return
+		}
+	}
+	b.reportAt(el, path, BreakingFieldSameType, "field %d %q of %s changed type from %s to %s", old.GetNumber(), cur.GetName(), msgName, oldType, curType)
+}
+
+// fieldType returns the type of a field: the scalar type name, or the
+// fully qualified message or enum name, translated to the current package
+// names for baseline fields.
+func (b *breaker) fieldType(field *descriptorpb.FieldDescriptorProto, baseline bool) string {
+	if field.GetTypeName() == "" {
+		return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+	}
+	name := strings.TrimPrefix(field.GetTypeName(), ".")
+	if baseline {
+		name = b.rename(name)
+	}
+	return name
+}
+
+// wireCompatible groups the scalar types that share a wire encoding, so
+// that values written as one are read correctly as another.
+var wireCompatible = map[descriptorpb.FieldDescriptorProto_Type]int{
+	descriptorpb.FieldDescriptorProto_TYPE_INT32:    1,
+	descriptorpb.FieldDescriptorProto_TYPE_INT64:    1,
+	descriptorpb.FieldDescriptorProto_TYPE_UINT32:   1,
+	descriptorpb.FieldDescriptorProto_TYPE_UINT64:   1,
+	descriptorpb.FieldDescriptorProto_TYPE_BOOL:     1,
+	descriptorpb.FieldDescriptorProto_TYPE_SINT32:   2,
+	descriptorpb.FieldDescriptorProto_TYPE_SINT64:   2,
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED32:  3,
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED32: 3,
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED64:  4,
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED64: 4,
+	descriptorpb.FieldDescriptorProto_TYPE_STRING:   5,
+	descriptorpb.FieldDescriptorProto_TYPE_BYTES:    5,
+}
+
+func cardinality(field *descriptorpb.FieldDescriptorProto) string {
+	if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return "repeated"
+	}
+	return "singular"
+}
+
+// fieldJSONName returns the JSON name of a field, computing the default
+// lowerCamelCase name if the descriptor does not carry one.
+func fieldJSONName(field *descriptorpb.FieldDescriptorProto) string {
+	if field.JsonName != nil {
+		return field.GetJsonName()
+	}
+	var sb strings.Builder
+	upper := false
+	for _, r := range field.GetName() {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && r >= 'a' && r <= 'z':
+			sb.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteRune(r)
+			upper = false
+		}
+	}
+	return sb.String()
+}
+
+func (b *breaker) enum(name string, old, cur *descElement) {
+	curNames := make(map[int32][]string)
+	for _, value := range cur.enum.GetValue() {
+		curNames[value.GetNumber()] = append(curNames[value.GetNumber()], value.GetName())
+	}
+
+	// Enum reserved ranges are inclusive; compare them as half-open ranges
+	// like message reserved ranges. The end of "to max" is MaxInt32, so the
+	// ranges need 64 bits.
+	var oldRanges, curRanges [][2]int64
+	for _, r := range old.enum.GetReservedRange() {
+		oldRanges = append(oldRanges, [2]int64{int64(r.GetStart()), int64(r.GetEnd()) + 1})
+	}
+	for _, r := range cur.enum.GetReservedRange() {
+		curRanges = append(curRanges, [2]int64{int64(r.GetStart()), int64(r.GetEnd()) + 1})
+	}
+
+	for _, value := range old.enum.GetValue() {
+		names, ok := curNames[value.GetNumber()]
+		if !ok {
+			numberReserved := rangesCover(curRanges, int64(value.GetNumber()), int64(value.GetNumber())+1)
+			nameReserved := containsString(cur.enum.GetReservedName(), value.GetName())
+			switch {
+			case b.level == BreakingFile:
+				b.reportAt(cur, cur.path, BreakingEnumValueNoDelete, "enum value %d %q of %s was deleted", value.GetNumber(), value.GetName(), name)
+			case !numberReserved:
+				b.reportAt(cur, cur.path, BreakingEnumValueNoDelete, "enum value %d %q of %s was deleted without reserving its number", value.GetNumber(), value.GetName(), name)
+			case b.level == BreakingWireJSON && !nameReserved:
+				b.reportAt(cur, cur.path, BreakingEnumValueNoDelete, "enum value %d %q of %s was deleted without reserving its name", value.GetNumber(), value.GetName(), name)
+			}
+			continue
+		}
+		if !containsString(names, value.GetName()) {
+			path := cur.path
+			for j, v := range cur.enum.GetValue() {
+				if v.GetNumber() == value.GetNumber() {
+					path = appendPath(cur.path, enumDescriptorValue, int32(j))
+					break
+				}
+			}
+			b.reportAt(cur, path, BreakingEnumValueSameName, "enum value %d of %s changed name from %q to %q", value.GetNumber(), name, value.GetName(), names[0])
+		}
+	}
+
+	b.reserved(name, cur, oldRanges, curRanges, old.enum.GetReservedName(), cur.enum.GetReservedName())
+}
+
+// reserved reports reserved numbers and names of the baseline that are no
+// longer reserved. Ranges are half-open.
+func (b *breaker) reserved(name string, cur *descElement, oldRanges, curRanges [][2]int64, oldNames, curNames []string) {
+	for _, r := range oldRanges {
+		if !rangesCover(curRanges, r[0], r[1]) {
+			b.reportAt(cur, cur.path, BreakingReservedNoDelete, "reserved range %s of %s was removed", formatRange(r), name)
+		}
+	}
+	for _, reserved := range oldNames {
+		if !containsString(curNames, reserved) {
+			b.reportAt(cur, cur.path, BreakingReservedNameNoDelete, "reserved name %q of %s was removed", reserved, name)
+		}
+	}
+}
+
+// rangesCover reports whether the half-open ranges cover [start, end).
+func rangesCover(ranges [][2]int64, start, end int64) bool {
+	sorted := append([][2]int64(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	next := start
+	for _, r := range sorted {
+		if r[0] > next {
+			break
+		}
+		if r[1] > next {
+			next = r[1]
+		}
+		if next >= end {
+			return true
+		}
+	}
+	return next >= end
+}
+
+func formatRange(r [2]int64) string {
+	if r[1]-r[0] == 1 {
+		return fmt.Sprint(r[0])
+	}
+	return fmt.Sprintf("%d to %d", r[0], r[1]-1)
+}
+
+func (b *breaker) service(name string, old, cur *descElement) {
+	curMethods := make(map[string]int)
+	for i, method := range cur.service.GetMethod() {
+		curMethods[method.GetName()] = i
+	}
+	for _, method := range old.service.GetMethod() {
+		i, ok := curMethods[method.GetName()]
+		if !ok {
+			b.reportAt(cur, cur.path, BreakingRPCNoDelete, "RPC %s.%s was deleted", name, method.GetName())
+			continue
+		}
+		curMethod := cur.service.GetMethod()[i]
+		path := appendPath(cur.path, serviceDescriptorMethod, int32(i))
+		rpc := name + "." + method.GetName()
+		if oldType, curType := b.rename(strings.TrimPrefix(method.GetInputType(), ".")), strings.TrimPrefix(curMethod.GetInputType(), "."); oldType != curType {
+			b.reportAt(cur, path, BreakingRPCSameRequestType, "RPC %s changed request type from %s to %s", rpc, oldType, curType)
+		}
+		if oldType, curType := b.rename(strings.TrimPrefix(method.GetOutputType(), ".")), strings.TrimPrefix(curMethod.GetOutputType(), "."); oldType != curType {
+			b.reportAt(cur, path, BreakingRPCSameResponseType, "RPC %s changed response type from %s to %s", rpc, oldType, curType)
+		}
+		if method.GetClientStreaming() != curMethod.GetClientStreaming() || method.GetServerStreaming() != curMethod.GetServerStreaming() {
+			b.reportAt(cur, path, BreakingRPCSameStreaming, "RPC %s changed streaming from %s to %s", rpc, streaming(method), streaming(curMethod))
+		}
+	}
+}
+
+func streaming(method *descriptorpb.MethodDescriptorProto) string {
+	switch {
+	case method.GetClientStreaming() && method.GetServerStreaming():
+		return "bidirectional"
+	case method.GetClientStreaming():
+		return "client streaming"
+	case method.GetServerStreaming():
+		return "server streaming"
+	}
+	return "unary"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
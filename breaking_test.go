@@ -0,0 +1,277 @@
+package protoc_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+const breakingBaseline = `syntax = "proto3";
+package acme.user.v1;
+
+message User {
+  reserved 9;
+  string name = 1;
+  string email = 2;
+  int32 age = 3;
+  repeated string tags = 4;
+  int64 created = 5;
+}
+
+enum Role {
+  ROLE_UNSPECIFIED = 0;
+  ROLE_ADMIN = 1;
+}
+
+service UserService {
+  rpc GetUser(User) returns (User);
+  rpc DeleteUser(User) returns (User);
+}
+`
+
+const breakingCurrent = `syntax = "proto3";
+package acme.user.v1;
+
+message User {
+  reserved 2;
+  string full_name = 1;
+  int64 age = 3;
+  string tags = 4;
+  string created = 5;
+  string email = 6;
+}
+
+enum Role {
+  ROLE_UNSPECIFIED = 0;
+}
+
+service UserService {
+  rpc GetUser(User) returns (stream User);
+}
+`
+
+func TestBreaking(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping breaking change test")
+	}
+
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"main/proto/user.proto":     breakingCurrent,
+		"baseline/proto/user.proto": breakingBaseline,
+	})
+	main := filepath.Join(tmpDir, "main")
+
+	tests := []struct {
+		level protoc.BreakingLevel
+		want  []string
+	}{
+		{protoc.BreakingWire, []string{
+			`proto/user.proto:4:9: reserved range 9 of acme.user.v1.User was removed (RESERVED_NO_DELETE)`,
+			`proto/user.proto:8:10: field 4 "tags" of acme.user.v1.User changed from repeated to singular (FIELD_SAME_CARDINALITY)`,
+			`proto/user.proto:9:10: field 5 "created" of acme.user.v1.User changed type from int64 to string (FIELD_SAME_TYPE)`,
+			`proto/user.proto:10:10: field acme.user.v1.User.email changed number from 2 to 6 (FIELD_SAME_NUMBER)`,
+			`proto/user.proto:13:6: enum value 1 "ROLE_ADMIN" of acme.user.v1.Role was deleted without reserving its number (ENUM_VALUE_NO_DELETE)`,
+			`proto/user.proto:17:9: RPC acme.user.v1.UserService.DeleteUser was deleted (RPC_NO_DELETE)`,
+			`proto/user.proto:18:7: RPC acme.user.v1.UserService.GetUser changed streaming from unary to server streaming (RPC_SAME_STREAMING)`,
+		}},
+		{protoc.BreakingFile, []string{
+			`proto/user.proto:4:9: reserved range 9 of acme.user.v1.User was removed (RESERVED_NO_DELETE)`,
+			`proto/user.proto:6:10: field 1 of acme.user.v1.User changed JSON name from "name" to "fullName" (FIELD_SAME_JSON_NAME)`,
+			`proto/user.proto:6:10: field 1 of acme.user.v1.User changed name from "name" to "full_name" (FIELD_SAME_NAME)`,
+			`proto/user.proto:7:9: field 3 "age" of acme.user.v1.User changed type from int32 to int64 (FIELD_SAME_TYPE)`,
+			`proto/user.proto:8:10: field 4 "tags" of acme.user.v1.User changed from repeated to singular (FIELD_SAME_CARDINALITY)`,
+			`proto/user.proto:9:10: field 5 "created" of acme.user.v1.User changed type from int64 to string (FIELD_SAME_TYPE)`,
+			`proto/user.proto:10:10: field acme.user.v1.User.email changed number from 2 to 6 (FIELD_SAME_NUMBER)`,
+			`proto/user.proto:13:6: enum value 1 "ROLE_ADMIN" of acme.user.v1.Role was deleted (ENUM_VALUE_NO_DELETE)`,
+			`proto/user.proto:17:9: RPC acme.user.v1.UserService.DeleteUser was deleted (RPC_NO_DELETE)`,
+			`proto/user.proto:18:7: RPC acme.user.v1.UserService.GetUser changed streaming from unary to server streaming (RPC_SAME_STREAMING)`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			diags, err := protoc.NewCompiler().
+				WithProtoDir(filepath.Join(main, "proto")).
+				WithProtoWorkSpace(main).
+				WithBreakingLevel(tt.level).
+				Breaking(context.Background(), filepath.Join(tmpDir, "baseline"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, d.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("unexpected diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestBreakingDescriptorSet(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping breaking change test")
+	}
+
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"baseline/a.proto": `syntax = "proto3"; package a; message A { string id = 1; }`,
+		"baseline/b.proto": `syntax = "proto3"; package b; service BService {}`,
+		"current/a.proto":  `syntax = "proto3"; package a.v2; message A { reserved 1; reserved "id"; }`,
+	})
+	set := filepath.Join(tmpDir, "baseline.binpb")
+	cmd := exec.Command("protoc", "-I", filepath.Join(tmpDir, "baseline"), "--descriptor_set_out="+set, "a.proto", "b.proto")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("protoc failed: %v\n%s", err, output)
+	}
+
+	current := filepath.Join(tmpDir, "current")
+	diags, err := protoc.NewCompiler().
+		WithProtoDir(current).
+		WithProtoWorkSpace(current).
+		WithBreakingLevel(protoc.BreakingWireJSON).
+		Breaking(context.Background(), set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`a.proto:1:20: package changed from "a" to "a.v2" (FILE_SAME_PACKAGE)`,
+		`b.proto: service b.BService was deleted (SERVICE_NO_DELETE)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := protoc.ParseBreakingLevel("wire_json"); err != nil {
+		t.Error(err)
+	}
+	if _, err := protoc.ParseBreakingLevel("SOURCE"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestBreakingReservedToMax(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"v1/e.proto": `syntax = "proto3"; package e; enum E { E_UNSPECIFIED = 0; E_TWENTY = 20; }`,
+		"v2/e.proto": `syntax = "proto3"; package e; enum E { E_UNSPECIFIED = 0; reserved 10 to max; }`,
+		"v3/e.proto": `syntax = "proto3"; package e; enum E { E_UNSPECIFIED = 0; }`,
+	})
+	breaking := func(current, baseline string) []string {
+		t.Helper()
+		dir := filepath.Join(tmpDir, current)
+		diags, err := protoc.NewCompiler().
+			WithProtoDir(dir).
+			WithProtoWorkSpace(dir).
+			WithBackend(protoc.GoBackend{}).
+			WithBreakingLevel(protoc.BreakingWire).
+			Breaking(context.Background(), filepath.Join(tmpDir, baseline))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range diags {
+			got = append(got, d.String())
+		}
+		return got
+	}
+
+	// Deleting a value is fine once its number is reserved up to max
+	if got := breaking("v2", "v1"); len(got) != 0 {
+		t.Errorf("unexpected diagnostics:\n%s", strings.Join(got, "\n"))
+	}
+	want := `e.proto:1:36: reserved range 10 to 2147483647 of e.E was removed (RESERVED_NO_DELETE)`
+	if got := breaking("v3", "v2"); strings.Join(got, "\n") != want {
+		t.Errorf("unexpected diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), want)
+	}
+}
+
+func TestBreakingIgnoresImports(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"v1/a.proto": `syntax = "proto3"; package a; import "google/protobuf/timestamp.proto"; message A {}`,
+		"v2/a.proto": `syntax = "proto3"; package a; message A {}`,
+	})
+	current := filepath.Join(tmpDir, "v2")
+	c := protoc.NewCompiler().
+		WithProtoDir(current).
+		WithProtoWorkSpace(current).
+		WithBackend(protoc.GoBackend{})
+
+	// The import is not a workspace file, so dropping it deletes nothing
+	baselines := []string{filepath.Join(tmpDir, "v1")}
+	if _, err := exec.LookPath("protoc"); err == nil {
+		set := filepath.Join(tmpDir, "v1.binpb")
+		cmd := exec.Command("protoc", "-I", filepath.Join(tmpDir, "v1"), "--include_imports", "--descriptor_set_out="+set, "a.proto")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("protoc failed: %v\n%s", err, output)
+		}
+		baselines = append(baselines, set)
+	}
+	for _, baseline := range baselines {
+		diags, err := c.Breaking(context.Background(), baseline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range diags {
+			t.Errorf("%s: unexpected diagnostic: %s", filepath.Base(baseline), d)
+		}
+	}
+}
+
+func TestBreakingBaselineScope(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping descriptor set baseline test")
+	}
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	writeProtos(t, workspace, map[string]string{
+		"api/a.proto":    `syntax = "proto3"; package api; import "common/c.proto"; message A { common.C c = 1; }`,
+		"api/b.proto":    `syntax = "proto3"; package api; message B {}`,
+		"common/c.proto": `syntax = "proto3"; package common; message C {}`,
+	})
+	set := filepath.Join(tmpDir, "base.binpb")
+	cmd := exec.Command("protoc", "-I", workspace, "--include_imports", "--descriptor_set_out="+set, "api/a.proto", "api/b.proto")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("protoc failed: %v\n%s", err, output)
+	}
+
+	breaking := func(c *protoc.Compiler) []string {
+		t.Helper()
+		diags, err := c.WithProtoWorkSpace(workspace).Breaking(context.Background(), set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range diags {
+			got = append(got, d.String())
+		}
+		return got
+	}
+
+	// The import of the proto directory is in the workspace, but not
+	// compiled, so it is not compared
+	if got := breaking(protoc.NewCompiler().WithProtoDir(filepath.Join(workspace, "api"))); len(got) != 0 {
+		t.Errorf("unchanged tree: unexpected diagnostics %v", got)
+	}
+	if got := breaking(protoc.NewCompiler().WithRoots("api/a.proto", "api/b.proto")); len(got) != 0 {
+		t.Errorf("unchanged roots: unexpected diagnostics %v", got)
+	}
+
+	// A deleted file of the proto directory is still reported
+	os.Remove(filepath.Join(workspace, "api", "b.proto"))
+	want := "api/b.proto: file api/b.proto was deleted (FILE_NO_DELETE)"
+	if got := breaking(protoc.NewCompiler().WithProtoDir(filepath.Join(workspace, "api"))); len(got) != 1 || got[0] != want {
+		t.Errorf("deleted file: got %v, want %s", got, want)
+	}
+}
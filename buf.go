@@ -0,0 +1,478 @@
+package protoc
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadBufConfig builds a Config from a buf.gen.yaml file and the module
+// layout next to it, so a repository can be compiled with this package and
+// with buf from the same configuration.
+//
+// Local plugins (plugin/name with an optional path in v1, local and
+// protoc_builtin in v2) are mapped with their out, opt and strategy
+// settings; like buf, plugins default to StrategyDirectory. Module roots and
+// excludes are read from buf.yaml (v1beta1, v1 or v2) or buf.work.yaml in
+// the same directory, or from directory inputs in a v2 buf.gen.yaml. Each
+// module root becomes a target whose workspace is the root, with the other
+// roots as import paths. Without any module configuration the directory
+// itself is the single root.
+//
+// Features that cannot be expressed as a protoc invocation, such as remote
+// plugins and managed mode, are reported as errors rather than ignored.
+func LoadBufConfig(genPath string) (*Config, error) {
+	d, root, err := readConfigFile(genPath)
+	if err != nil {
+		return nil, err
+	}
+
+	gen, err := d.bufGen(root)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := gen.inputs
+	if modules == nil {
+		if modules, err = loadBufModules(d.dir); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &Config{Path: genPath}
+	for _, module := range modules {
+		t := &configTarget{
+			name:       module.name,
+			workspace:  module.root,
+			protoDir:   module.root,
+			excludes:   module.excludes,
+			plugins:    gen.plugins,
+			pluginsSet: true,
+		}
+		for _, other := range modules {
+			if other.root != module.root {
+				t.importPaths = append(t.importPaths, other.root)
+			}
+		}
+		cfg.Targets = append(cfg.Targets, &Target{Name: t.name, Compiler: t.compiler()})
+	}
+	return cfg, nil
+}
+
+// bufModule is a buf module root with its excludes, which are relative to
+// the root. dir is the root relative to the configuration directory.
+type bufModule struct {
+	name     string
+	dir      string
+	root     string
+	excludes []string
+}
+
+// bufGenConfig is the part of buf.gen.yaml this package understands.
+type bufGenConfig struct {
+	plugins []configPlugin
+	inputs  []bufModule
+}
+
+// bufGen decodes a buf.gen.yaml root node.
+func (d *configDecoder) bufGen(root *yaml.Node) (*bufGenConfig, error) {
+	gen := &bufGenConfig{}
+	version := "v1"
+	if node := mappingValue(root, "version"); node != nil {
+		var err error
+		if version, err = d.str(node); err != nil {
+			return nil, err
+		}
+		if version != "v1beta1" && version != "v1" && version != "v2" {
+			return nil, d.errorf(node, "unsupported buf.gen.yaml version %q", version)
+		}
+	}
+
+	var pluginsNode *yaml.Node
+	err := d.fields(root, "buf.gen.yaml", func(key string, value *yaml.Node) (bool, error) {
+		switch key {
+		case "version", "clean":
+			return true, nil
+		case "plugins":
+			pluginsNode = value
+			return true, nil
+		case "managed":
+			return true, d.bufManaged(value)
+		case "types":
+			if version == "v2" {
+				return false, nil
+			}
+			return true, d.errorf(value, "type filters are not supported")
+		case "inputs":
+			if version != "v2" {
+				return false, nil
+			}
+			var err error
+			gen.inputs, err = d.bufInputs(value)
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if pluginsNode == nil || pluginsNode.Kind != yaml.SequenceNode || len(pluginsNode.Content) == 0 {
+		if pluginsNode == nil {
+			pluginsNode = root
+		}
+		return nil, d.errorf(pluginsNode, "buf.gen.yaml must list at least one plugin")
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range pluginsNode.Content {
+		plugin, err := d.bufPlugin(entry, version)
+		if err != nil {
+			return nil, err
+		}
+		if seen[plugin.name] {
+			return nil, d.errorf(entry, "duplicate plugin %q", plugin.name)
+		}
+		seen[plugin.name] = true
+		gen.plugins = append(gen.plugins, plugin)
+	}
+	return gen, nil
+}
+
+// bufManaged rejects managed mode, which rewrites file options in ways a
+// plain protoc invocation cannot reproduce.
+func (d *configDecoder) bufManaged(node *yaml.Node) error {
+	enabled := mappingValue(node, "enabled")
+	if enabled != nil && enabled.Value == "true" {
+		return d.errorf(enabled, "managed mode is not supported")
+	}
+	return nil
+}
+
+// bufInputs decodes the inputs of a v2 buf.gen.yaml. Only local
+// directories are supported.
+func (d *configDecoder) bufInputs(node *yaml.Node) ([]bufModule, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, d.errorf(node, "inputs must be a list")
+	}
+
+	var modules []bufModule
+	for _, entry := range node.Content {
+		if entry.Kind != yaml.MappingNode {
+			return nil, d.errorf(entry, "input must be a mapping")
+		}
+		var module bufModule
+		err := d.fields(entry, "input", func(key string, value *yaml.Node) (bool, error) {
+			var err error
+			switch key {
+			case "directory":
+				var dir string
+				if dir, err = d.str(value); err == nil {
+					// Keep exclude_paths decoded before the directory
+					at := d.moduleAt(dir)
+					module.name, module.dir, module.root = at.name, at.dir, at.root
+				}
+			case "exclude_paths":
+				module.excludes, err = d.strs(value)
+			default:
+				return true, d.errorf(value, "input type %q is not supported, only directory inputs are", key)
+			}
+			return true, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if module.root == "" {
+			return nil, d.errorf(entry, "input is missing \"directory\"")
+		}
+		// exclude_paths are relative to the buf.gen.yaml directory
+		module.excludes = relativeExcludes(module.dir, module.excludes)
+		modules = append(modules, module)
+	}
+	return modules, nil
+}
+
+// bufPlugin decodes a plugin entry of buf.gen.yaml.
+func (d *configDecoder) bufPlugin(node *yaml.Node, version string) (configPlugin, error) {
+	plugin := configPlugin{node: node, strategy: StrategyDirectory}
+	if node.Kind != yaml.MappingNode {
+		return plugin, d.errorf(node, "plugin must be a mapping")
+	}
+
+	err := d.fields(node, "plugin", func(key string, value *yaml.Node) (bool, error) {
+		var err error
+		switch key {
+		case "out":
+			plugin.out, err = d.path(value)
+		case "opt":
+			plugin.opts, err = d.strs(value)
+		case "strategy":
+			plugin.strategy, err = d.strategy(value)
+		case "revision", "protoc_path":
+			// Only meaningful for remote and protoc built-in plugins
+			// run by buf itself.
+		case "plugin", "name":
+			if version == "v2" {
+				return false, nil
+			}
+			var name string
+			if name, err = d.str(value); err == nil {
+				if strings.Contains(name, "/") {
+					return true, d.errorf(value, "remote plugin %q is not supported, install it locally", name)
+				}
+				plugin.name = name
+			}
+		case "path":
+			if version == "v2" {
+				return false, nil
+			}
+			plugin.path, err = d.bufCommand(value)
+		case "local":
+			if version != "v2" {
+				return false, nil
+			}
+			var command string
+			if command, err = d.bufCommand(value); err == nil {
+				plugin.name = strings.TrimPrefix(path.Base(filepath.ToSlash(command)), "protoc-gen-")
+				if strings.ContainsAny(command, `/\`) {
+					plugin.path = command
+				}
+			}
+		case "protoc_builtin":
+			if version != "v2" {
+				return false, nil
+			}
+			plugin.name, err = d.str(value)
+		case "remote":
+			if version != "v2" {
+				return false, nil
+			}
+			return true, d.errorf(value, "remote plugin %q is not supported, install it locally", value.Value)
+		case "include_imports", "include_wkt":
+			if value.Value == "true" {
+				return true, d.errorf(value, "%s is not supported", key)
+			}
+		case "types", "exclude_types":
+			return true, d.errorf(value, "type filters are not supported")
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return plugin, err
+	}
+
+	if plugin.name == "" {
+		return plugin, d.errorf(node, "plugin is missing its name")
+	}
+	if plugin.out == "" {
+		return plugin, d.errorf(node, "plugin %q is missing required field \"out\"", plugin.name)
+	}
+	return plugin, nil
+}
+
+// bufCommand decodes a plugin command. buf allows a list with arguments;
+// protoc can only run a single executable.
+func (d *configDecoder) bufCommand(node *yaml.Node) (string, error) {
+	command, err := d.strs(node)
+	if err != nil {
+		return "", err
+	}
+	if len(command) != 1 {
+		return "", d.errorf(node, "plugin commands with arguments are not supported, use a single executable")
+	}
+	if strings.ContainsAny(command[0], `/\`) {
+		return d.resolve(command[0]), nil
+	}
+	return command[0], nil
+}
+
+// moduleAt returns the module rooted at dir, relative to the decoder's
+// directory. The module at the directory itself is named "default".
+func (d *configDecoder) moduleAt(dir string) bufModule {
+	rel := path.Clean(filepath.ToSlash(dir))
+	name := rel
+	if name == "." {
+		name = "default"
+	}
+	return bufModule{name: name, dir: rel, root: d.resolve(dir)}
+}
+
+// loadBufModules reads the module roots and excludes from buf.yaml or
+// buf.work.yaml in dir.
+func loadBufModules(dir string) ([]bufModule, error) {
+	workPath := filepath.Join(dir, "buf.work.yaml")
+	if _, err := os.Stat(workPath); err == nil {
+		return loadBufWork(workPath)
+	}
+
+	bufPath := filepath.Join(dir, "buf.yaml")
+	if _, err := os.Stat(bufPath); os.IsNotExist(err) {
+		d := &configDecoder{dir: dir}
+		return []bufModule{d.moduleAt(".")}, nil
+	}
+	return loadBufYAML(bufPath, "")
+}
+
+// loadBufWork reads a v1 buf.work.yaml, whose directories each hold a
+// module with an optional buf.yaml of its own.
+func loadBufWork(workPath string) ([]bufModule, error) {
+	d, root, err := readConfigFile(workPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var modules []bufModule
+	err = d.fields(root, "buf.work.yaml", func(key string, value *yaml.Node) (bool, error) {
+		switch key {
+		case "version":
+			return true, nil
+		case "directories":
+			dirs, err := d.strs(value)
+			if err != nil {
+				return true, err
+			}
+			for _, dir := range dirs {
+				module := d.moduleAt(dir)
+				bufPath := filepath.Join(module.root, "buf.yaml")
+				if _, err := os.Stat(bufPath); err == nil {
+					nested, err := loadBufYAML(bufPath, dir)
+					if err != nil {
+						return true, err
+					}
+					module.excludes = nested[0].excludes
+				}
+				modules = append(modules, module)
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) == 0 {
+		return nil, d.errorf(root, "buf.work.yaml must list at least one directory")
+	}
+	return modules, nil
+}
+
+// loadBufYAML reads module roots and excludes from a buf.yaml. name, if
+// set, names the module of a v1 file, which is rooted at its directory.
+func loadBufYAML(bufPath, name string) ([]bufModule, error) {
+	d, root, err := readConfigFile(bufPath)
+	if err != nil {
+		return nil, err
+	}
+
+	version := "v1"
+	if node := mappingValue(root, "version"); node != nil {
+		version = node.Value
+	}
+
+	switch version {
+	case "v1beta1", "v1":
+		var roots, excludes []string
+		if build := mappingValue(root, "build"); build != nil {
+			err := d.fields(build, "build", func(key string, value *yaml.Node) (bool, error) {
+				var err error
+				switch key {
+				case "roots":
+					roots, err = d.strs(value)
+				case "excludes":
+					excludes, err = d.strs(value)
+				default:
+					return false, nil
+				}
+				return true, err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(roots) == 0 {
+			module := d.moduleAt(".")
+			if name != "" {
+				module.name = name
+			}
+			module.excludes = excludes
+			return []bufModule{module}, nil
+		}
+
+		// v1beta1 excludes are relative to the buf.yaml directory
+		var modules []bufModule
+		for _, dir := range roots {
+			module := d.moduleAt(dir)
+			module.excludes = relativeExcludes(module.dir, excludes)
+			modules = append(modules, module)
+		}
+		return modules, nil
+
+	case "v2":
+		modulesNode := mappingValue(root, "modules")
+		if modulesNode == nil {
+			return []bufModule{d.moduleAt(".")}, nil
+		}
+		if modulesNode.Kind != yaml.SequenceNode {
+			return nil, d.errorf(modulesNode, "modules must be a list")
+		}
+		var modules []bufModule
+		for _, entry := range modulesNode.Content {
+			dir := "."
+			if node := mappingValue(entry, "path"); node != nil {
+				if dir, err = d.str(node); err != nil {
+					return nil, err
+				}
+			}
+			module := d.moduleAt(dir)
+			if node := mappingValue(entry, "excludes"); node != nil {
+				excludes, err := d.strs(node)
+				if err != nil {
+					return nil, err
+				}
+				// v2 excludes are relative to the buf.yaml directory
+				module.excludes = relativeExcludes(module.dir, excludes)
+			}
+			modules = append(modules, module)
+		}
+		return modules, nil
+	}
+
+	node := mappingValue(root, "version")
+	return nil, d.errorf(node, "unsupported buf.yaml version %q", version)
+}
+
+// relativeExcludes rewrites excludes given relative to a parent directory
+// so they are relative to the module root at prefix. Excludes outside the
+// module are dropped.
+func relativeExcludes(prefix string, excludes []string) []string {
+	if prefix == "." {
+		return excludes
+	}
+	var rel []string
+	for _, exclude := range excludes {
+		exclude = path.Clean(filepath.ToSlash(exclude))
+		if strings.HasPrefix(exclude, prefix+"/") {
+			rel = append(rel, strings.TrimPrefix(exclude, prefix+"/"))
+		}
+	}
+	return rel
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
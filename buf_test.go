@@ -0,0 +1,185 @@
+package protoc_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestLoadBufConfigV1(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"proto/api/api.proto":       `syntax = "proto3"; package api; import "model/user.proto";`,
+		"proto/api/legacy/v0.proto": `syntax = "proto3"; package api.legacy;`,
+		"vendor/model/user.proto":   `syntax = "proto3"; package model;`,
+	})
+	writeConfig(t, tmpDir, "buf.work.yaml", "version: v1\ndirectories:\n  - proto\n  - vendor\n")
+	writeConfig(t, filepath.Join(tmpDir, "proto"), "buf.yaml", "version: v1\nbuild:\n  excludes: [api/legacy]\nlint:\n  use: [DEFAULT]\n")
+	path := writeConfig(t, tmpDir, "buf.gen.yaml", `version: v1
+plugins:
+  - plugin: go
+    out: gen
+    opt: paths=source_relative
+  - name: go-grpc
+    out: gen
+    path: ./bin/protoc-gen-go-grpc
+    strategy: all
+`)
+
+	cfg, err := protoc.LoadBufConfig(path)
+	if err != nil {
+		t.Fatalf("LoadBufConfig failed: %v", err)
+	}
+	if len(cfg.Targets) != 2 || cfg.Targets[0].Name != "proto" || cfg.Targets[1].Name != "vendor" {
+		t.Fatalf("unexpected targets: %+v", cfg.Targets)
+	}
+
+	target, _ := cfg.Target("proto")
+	graph, err := target.Compiler.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if want := []string{"api/api.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("files = %v, want %v (excludes not applied?)", graph.Files, want)
+	}
+	if len(graph.Unresolved) != 0 {
+		t.Errorf("imports from other modules should resolve, got %+v", graph.Unresolved)
+	}
+}
+
+func TestLoadBufConfigV2(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"proto/api/api.proto":     `syntax = "proto3"; package api;`,
+		"proto/api/tmp/tmp.proto": `syntax = "proto3"; package api.tmp;`,
+	})
+	writeConfig(t, tmpDir, "buf.yaml", "version: v2\nmodules:\n  - path: proto\n    excludes: [proto/api/tmp]\n")
+	path := writeConfig(t, tmpDir, "buf.gen.yaml", `version: v2
+clean: true
+managed:
+  enabled: false
+plugins:
+  - local: protoc-gen-go
+    out: gen
+    opt: [paths=source_relative]
+  - protoc_builtin: cpp
+    out: gen/cpp
+`)
+
+	cfg, err := protoc.LoadBufConfig(path)
+	if err != nil {
+		t.Fatalf("LoadBufConfig failed: %v", err)
+	}
+	compiler, err := cfg.Compiler()
+	if err != nil {
+		t.Fatalf("Compiler failed: %v", err)
+	}
+	graph, err := compiler.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if want := []string{"api/api.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("files = %v, want %v", graph.Files, want)
+	}
+}
+
+func TestLoadBufConfigInputs(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"proto/api/api.proto":     `syntax = "proto3"; package api;`,
+		"proto/api/tmp/tmp.proto": `syntax = "proto3"; package api.tmp;`,
+	})
+	// exclude_paths before directory are kept
+	path := writeConfig(t, tmpDir, "buf.gen.yaml", `version: v2
+plugins:
+  - local: protoc-gen-go
+    out: gen
+inputs:
+  - exclude_paths: [proto/api/tmp]
+    directory: proto
+`)
+
+	cfg, err := protoc.LoadBufConfig(path)
+	if err != nil {
+		t.Fatalf("LoadBufConfig failed: %v", err)
+	}
+	compiler, err := cfg.Compiler()
+	if err != nil {
+		t.Fatalf("Compiler failed: %v", err)
+	}
+	graph, err := compiler.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if want := []string{"api/api.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("files = %v, want %v", graph.Files, want)
+	}
+}
+
+func TestLoadBufConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "remote plugin v1",
+			content: "version: v1\nplugins:\n  - plugin: buf.build/protocolbuffers/go\n    out: gen\n",
+			want:    `:3:13: remote plugin "buf.build/protocolbuffers/go" is not supported`,
+		},
+		{
+			name:    "remote plugin v2",
+			content: "version: v2\nplugins:\n  - remote: buf.build/grpc/go\n    out: gen\n",
+			want:    `:3:13: remote plugin "buf.build/grpc/go" is not supported`,
+		},
+		{
+			name:    "managed mode",
+			content: "version: v1\nmanaged:\n  enabled: true\nplugins:\n  - plugin: go\n    out: gen\n",
+			want:    ":3:12: managed mode is not supported",
+		},
+		{
+			name:    "plugin arguments",
+			content: "version: v2\nplugins:\n  - local: [go, run, ./cmd/gen]\n    out: gen\n",
+			want:    ":3:12: plugin commands with arguments are not supported",
+		},
+		{
+			name:    "missing out",
+			content: "version: v1\nplugins:\n  - plugin: go\n",
+			want:    `:3:5: plugin "go" is missing required field "out"`,
+		},
+		{
+			name:    "no plugins",
+			content: "version: v1\n",
+			want:    ":1:1: buf.gen.yaml must list at least one plugin",
+		},
+		{
+			name:    "module input",
+			content: "version: v2\nplugins:\n  - local: protoc-gen-go\n    out: gen\ninputs:\n  - module: buf.build/acme/api\n",
+			want:    `:6:13: input type "module" is not supported`,
+		},
+		{
+			name:    "bad version",
+			content: "version: v3\n",
+			want:    `:1:10: unsupported buf.gen.yaml version "v3"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, t.TempDir(), "buf.gen.yaml", tt.content)
+			_, err := protoc.LoadBufConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got: %v", tt.want, err)
+			}
+			var cfgErr *protoc.ConfigError
+			if !errors.As(err, &cfgErr) || cfgErr.Line == 0 {
+				t.Errorf("expected *ConfigError with a line number, got %T", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,614 @@
+// Command protoc-go compiles Protocol Buffer files with the protoc package
+// from Makefiles and shell scripts.
+//
+// Usage:
+//
+//	protoc-go <command> [flags] [target ...]
+//
+// The commands are:
+//
+//	compile  run protoc for the selected targets
+//	check    validate the configuration and sources without generating code
+//	clean    remove the files compile is expected to generate
+//	list     list the .proto files each target compiles
+//	plan     print the protoc commands compile would run
+//	doctor   diagnose protoc, plugins, include paths and output directories
+//	lint     check the .proto files against the lint rules
+//	breaking report incompatible changes against the -against baseline
+//
+// Targets are read from a configuration file (-config, by default
+// protoc-go.yaml or buf.gen.yaml in the current directory) or described
+// with flags such as -proto_dir, -workspace and -output. Positional
+// arguments select targets of a configuration file by name.
+//
+// With -json, each command writes a single JSON document to standard
+// output instead of text. With -report, compile also writes a build
+// report for CI systems to a file, as JSON, JUnit XML or SARIF
+// (-report_format), and lint and breaking write their findings as SARIF
+// for code scanning.
+//
+// The exit code is 0 on success, 1 if the command failed for any target
+// and 2 for usage and configuration errors.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// Exit codes.
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+// commandList lists the commands for usage messages.
+const commandList = "compile, check, clean, list, plan, doctor, lint, breaking"
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// listFlag is a flag that may be repeated, or given comma separated values.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, strings.Split(value, ",")...)
+	return nil
+}
+
+// options are the parsed command line flags.
+type options struct {
+	config        string
+	protoDir      string
+	workspace     string
+	output        string
+	importPaths   listFlag
+	roots         listFlag
+	excludes      listFlag
+	plugins       listFlag
+	goOpts        listFlag
+	goGrpcOpts    listFlag
+	goPkgPrefix   string
+	protocVersion string
+	protocSource  string
+	wellKnown     bool
+	goPkgCheck    bool
+	against       string
+	level         string
+	backendName   string
+	parallelism   int
+	json          bool
+	reportFile    string
+	reportName    string
+	verbose       bool
+	targets       []string
+	command       string
+	breakingLevel protoc.BreakingLevel
+	backend       protoc.Backend
+	reportFormat  protoc.ReportFormat
+}
+
+// usageError is a problem with the command line or configuration, reported
+// with exit code 2.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+// run executes the command line and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		printUsage(stderr, nil)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	command := args[0]
+	switch command {
+	case "compile", "check", "clean", "list", "plan", "doctor", "lint", "breaking":
+	default:
+		fmt.Fprintf(stderr, "protoc-go: unknown command %q, expected one of %s\n", command, commandList)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("protoc-go "+command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts := &options{}
+	fs.StringVar(&opts.config, "config", "", "configuration `file` (default protoc-go.yaml or buf.gen.yaml)")
+	fs.StringVar(&opts.protoDir, "proto_dir", "", "`dir`ectory containing the .proto files to compile")
+	fs.StringVar(&opts.workspace, "workspace", "", "workspace `dir`ectory passed to protoc with -I (default the proto directory, or . with -root)")
+	fs.StringVar(&opts.output, "output", "", "output `dir`ectory for generated files")
+	fs.Var(&opts.importPaths, "I", "additional import `dir`ectory (repeatable)")
+	fs.Var(&opts.roots, "root", "entry point `file` to compile with its imports (repeatable)")
+	fs.Var(&opts.excludes, "exclude", "exclude files matching `pattern` (repeatable)")
+	fs.Var(&opts.plugins, "plugin", "protoc `plugin` to run (repeatable, default go)")
+	fs.Var(&opts.goOpts, "go_opt", "`option` for the go plugin (repeatable)")
+	fs.Var(&opts.goGrpcOpts, "go-grpc_opt", "`option` for the go-grpc plugin (repeatable)")
+	fs.StringVar(&opts.goPkgPrefix, "go_package_prefix", "", "infer go_package of files without one as `module` path plus their directory")
+	fs.StringVar(&opts.protocVersion, "protoc_version", "", "required protoc `version`")
+	fs.StringVar(&opts.protocSource, "protoc_source", "", "`directory` or file:// URL of protoc release archives to install protoc from when it is not in PATH")
+	fs.BoolVar(&opts.wellKnown, "well_known_types", false, "use the bundled well-known types if protoc has none")
+	fs.BoolVar(&opts.goPkgCheck, "go_package_validation", false, "check go_package against the output layout before running protoc")
+	fs.StringVar(&opts.backendName, "backend", "", "compiler `backend`: protoc, or go to compile without protoc (default protoc)")
+	fs.StringVar(&opts.against, "against", "", "baseline descriptor set `file` or workspace directory for breaking")
+	fs.StringVar(&opts.level, "level", "FILE", "breaking change `level`: FILE, WIRE_JSON or WIRE")
+	fs.IntVar(&opts.parallelism, "j", 0, "number of targets compiled in parallel (default number of CPUs)")
+	fs.BoolVar(&opts.json, "json", false, "write JSON output")
+	fs.StringVar(&opts.reportFile, "report", "", "write a report of compile, lint or breaking to `file`")
+	fs.StringVar(&opts.reportName, "report_format", "", "report `format`: json, junit or sarif for compile (default by extension, otherwise json); sarif for lint and breaking")
+	fs.BoolVar(&opts.verbose, "v", false, "verbose output")
+	fs.Usage = func() { printUsage(stderr, fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	opts.targets = fs.Args()
+	opts.command = command
+
+	if command == "breaking" && opts.against == "" {
+		fmt.Fprintln(stderr, "protoc-go: breaking requires -against")
+		return exitUsage
+	}
+	level, err := protoc.ParseBreakingLevel(opts.level)
+	if err != nil {
+		fmt.Fprintf(stderr, "protoc-go: %v\n", err)
+		return exitUsage
+	}
+	opts.breakingLevel = level
+	if opts.reportFile != "" {
+		if opts.reportFormat, err = reportFormat(command, opts.reportFile, opts.reportName); err != nil {
+			fmt.Fprintf(stderr, "protoc-go: %v\n", err)
+			return exitUsage
+		}
+	}
+	if opts.backendName != "" {
+		if opts.backend, err = protoc.ParseBackend(opts.backendName); err != nil {
+			fmt.Fprintf(stderr, "protoc-go: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	project, err := opts.project()
+	if err != nil {
+		return report(stdout, stderr, command, opts, &commandReport{}, err)
+	}
+
+	var rep *commandReport
+	switch command {
+	case "compile":
+		rep, err = compile(ctx, project, opts)
+	default:
+		rep, err = each(ctx, project, opts, command)
+	}
+	return report(stdout, stderr, command, opts, rep, err)
+}
+
+// printUsage writes the command line help.
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: protoc-go <command> [flags] [target ...]\n\nCommands: %s\n", commandList)
+	if fs != nil {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.PrintDefaults()
+	}
+}
+
+// project builds the targets from a configuration file or from flags.
+func (o *options) project() (*protoc.Project, error) {
+	fromFlags := o.protoDir != "" || len(o.roots) > 0
+	if fromFlags {
+		if o.config != "" {
+			return nil, &usageError{fmt.Errorf("-config cannot be combined with -proto_dir or -root")}
+		}
+		if len(o.targets) > 0 {
+			return nil, &usageError{fmt.Errorf("targets can only be selected from a configuration file")}
+		}
+		return protoc.NewProject().AddTarget("default", o.compiler()), nil
+	}
+	path := o.config
+	if path == "" {
+		for _, name := range []string{protoc.DefaultConfigFile, "buf.gen.yaml"} {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+	}
+	if path == "" && o.command == "doctor" {
+		// Without a configuration, diagnose the toolchain the flags
+		// describe, by default protoc and the go plugin.
+		return protoc.NewProject().AddTarget("default", o.compiler()), nil
+	}
+
+	if o.workspace != "" || o.output != "" || len(o.importPaths) > 0 || len(o.excludes) > 0 ||
+		len(o.plugins) > 0 || len(o.goOpts) > 0 || len(o.goGrpcOpts) > 0 || o.goPkgPrefix != "" {
+		return nil, &usageError{fmt.Errorf("-workspace, -output, -I, -exclude, -plugin and plugin options require -proto_dir or -root")}
+	}
+	if path == "" {
+		return nil, &usageError{fmt.Errorf("no %s or buf.gen.yaml found, use -config or -proto_dir", protoc.DefaultConfigFile)}
+	}
+
+	var cfg *protoc.Config
+	var err error
+	if strings.HasPrefix(filepath.Base(path), "buf.gen.") {
+		cfg, err = protoc.LoadBufConfig(path)
+	} else {
+		cfg, err = protoc.LoadConfig(path)
+	}
+	if err != nil {
+		return nil, &usageError{err}
+	}
+	for _, target := range cfg.Targets {
+		o.apply(target.Compiler)
+	}
+	return cfg.Project(), nil
+}
+
+// compiler builds a Compiler from the flags.
+func (o *options) compiler() *protoc.Compiler {
+	workspace := o.workspace
+	if workspace == "" {
+		workspace = o.protoDir
+	}
+	if workspace == "" {
+		workspace = "."
+	}
+
+	c := protoc.NewCompiler().
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(o.output).
+		WithImportPaths(o.importPaths...).
+		WithExcludes(o.excludes...)
+	if o.protoDir != "" {
+		c.WithProtoDir(o.protoDir)
+	}
+	if len(o.roots) > 0 {
+		c.WithRoots(o.roots...)
+	}
+	if len(o.plugins) > 0 {
+		c.WithPlugins(o.plugins...)
+	}
+	if len(o.goOpts) > 0 {
+		c.WithGoOpts(o.goOpts...)
+	}
+	if len(o.goGrpcOpts) > 0 {
+		c.WithGoGrpcOpts(o.goGrpcOpts...)
+	}
+	if o.goPkgPrefix != "" {
+		c.WithGoPackagePrefix(o.goPkgPrefix)
+	}
+	o.apply(c)
+	return c
+}
+
+// apply sets the flags that also make sense on top of a configuration file.
+func (o *options) apply(c *protoc.Compiler) {
+	if o.protocVersion != "" {
+		c.WithProtocVersion(o.protocVersion)
+	}
+	if o.protocSource != "" {
+		c.WithProtocSource(o.protocSource)
+	}
+	if o.wellKnown {
+		c.WithWellKnownTypes(true)
+	}
+	if o.goPkgCheck {
+		c.WithGoPackageValidation(true)
+	}
+	if o.backend != nil {
+		c.WithBackend(o.backend)
+	}
+	// Verbose output would corrupt the JSON document on stdout.
+	c.WithVerbose(o.verbose && !o.json)
+}
+
+// commandReport is the result of a command, written as text or JSON.
+type commandReport struct {
+	OK      bool            `json:"ok"`
+	Error   string          `json:"error,omitempty"`
+	Targets []*targetReport `json:"targets"`
+}
+
+// targetReport is the result of a command for one target.
+type targetReport struct {
+	Name       string               `json:"name"`
+	OK         bool                 `json:"ok"`
+	Status     string               `json:"status,omitempty"`
+	Errors     []string             `json:"errors,omitempty"`
+	DurationMS int64                `json:"duration_ms,omitempty"`
+	Output     string               `json:"output,omitempty"`
+	Files      []string             `json:"files,omitempty"`
+	Plan       *protoc.Plan         `json:"plan,omitempty"`
+	Removed    []string             `json:"removed,omitempty"`
+	Doctor     *protoc.DoctorReport `json:"doctor,omitempty"`
+	Lint       []protoc.Diagnostic  `json:"lint,omitempty"`
+	Breaking   []protoc.Diagnostic  `json:"breaking,omitempty"`
+}
+
+// compile builds the selected targets in dependency order.
+func compile(ctx context.Context, project *protoc.Project, opts *options) (*commandReport, error) {
+	if opts.parallelism > 0 {
+		project.WithParallelism(opts.parallelism)
+	}
+	result, err := project.Build(ctx, opts.targets...)
+	if result == nil {
+		return &commandReport{}, &usageError{err}
+	}
+
+	rep := &commandReport{}
+	for _, t := range result.Targets {
+		tr := &targetReport{
+			Name:       t.Name,
+			OK:         t.Status == protoc.TargetSucceeded,
+			Status:     t.Status.String(),
+			DurationMS: t.Duration.Milliseconds(),
+			Output:     t.Output,
+		}
+		if t.Err != nil {
+			tr.Errors = []string{t.Err.Error()}
+		}
+		rep.Targets = append(rep.Targets, tr)
+	}
+	if opts.reportFile != "" {
+		if werr := writeReport(opts.reportFile, opts.reportFormat, result.Results()); werr != nil {
+			return rep, errors.Join(err, werr)
+		}
+	}
+	return rep, err
+}
+
+// reportFormat returns the -report_format of a command, inferred from the
+// file extension if unset.
+func reportFormat(command, file, name string) (protoc.ReportFormat, error) {
+	switch command {
+	case "compile":
+		if name != "" {
+			return protoc.ParseReportFormat(name)
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".xml":
+			return protoc.ReportJUnit, nil
+		case ".sarif":
+			return protoc.ReportSARIF, nil
+		}
+		return protoc.ReportJSON, nil
+	case "lint", "breaking":
+		if name != "" && !strings.EqualFold(name, string(protoc.ReportSARIF)) {
+			return "", fmt.Errorf("%s reports are written as sarif, not %s", command, name)
+		}
+		return protoc.ReportSARIF, nil
+	}
+	return "", fmt.Errorf("-report is only supported by compile, lint and breaking")
+}
+
+// writeReport writes the report of a command to path.
+func writeReport(path string, format protoc.ReportFormat, results []*protoc.CompileResult) error {
+	var buf bytes.Buffer
+	if err := protoc.WriteReport(&buf, format, results...); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
+
+// each runs a command that does not generate code on every selected target.
+func each(ctx context.Context, project *protoc.Project, opts *options, command string) (*commandReport, error) {
+	order, err := project.Order(opts.targets...)
+	if err != nil {
+		return &commandReport{}, &usageError{err}
+	}
+	rep := &commandReport{}
+	var findings []*protoc.CompileResult
+	failed := 0
+	for _, name := range order {
+		c, _ := project.Compiler(name)
+		tr := &targetReport{Name: name}
+		start := time.Now()
+		switch command {
+		case "check":
+			err = c.Check(ctx)
+		case "clean":
+			tr.Removed, err = c.Clean(ctx)
+		case "list":
+			tr.Files, err = c.Files(ctx)
+		case "plan":
+			tr.Plan, err = c.Plan(ctx)
+		case "doctor":
+			tr.Doctor = c.Doctor(ctx)
+			err = doctorErrors(tr.Doctor)
+		case "lint":
+			tr.Lint, err = c.Lint(ctx)
+			if err == nil && len(tr.Lint) > 0 {
+				err = fmt.Errorf("%d lint problems", len(tr.Lint))
+			}
+		case "breaking":
+			tr.Breaking, err = c.WithBreakingLevel(opts.breakingLevel).Breaking(ctx, opts.against)
+			if err == nil && len(tr.Breaking) > 0 {
+				err = fmt.Errorf("%d breaking changes", len(tr.Breaking))
+			}
+		}
+		tr.DurationMS = time.Since(start).Milliseconds()
+		tr.OK = err == nil
+		if err != nil {
+			failed++
+			tr.Errors = splitErrors(err)
+		}
+		rep.Targets = append(rep.Targets, tr)
+		findings = append(findings, &protoc.CompileResult{Target: name, Diagnostics: append(tr.Lint, tr.Breaking...)})
+	}
+	if opts.reportFile != "" {
+		if err := writeReport(opts.reportFile, opts.reportFormat, findings); err != nil {
+			return rep, err
+		}
+	}
+	if failed > 0 {
+		return rep, fmt.Errorf("%s failed for %d of %d targets", command, failed, len(order))
+	}
+	return rep, nil
+}
+
+// doctorErrors returns the failed checks of a report as an error.
+func doctorErrors(report *protoc.DoctorReport) error {
+	var errs []error
+	for _, check := range report.Checks {
+		if check.Status == protoc.DoctorError {
+			errs = append(errs, fmt.Errorf("%s: %s", check.Name, check.Message))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitErrors returns the messages of an error, unwrapping joined errors.
+func splitErrors(err error) []string {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var msgs []string
+		for _, e := range joined.Unwrap() {
+			msgs = append(msgs, splitErrors(e)...)
+		}
+		return msgs
+	}
+	return []string{err.Error()}
+}
+
+// report writes the result of a command and returns the exit code.
+func report(stdout, stderr io.Writer, command string, opts *options, rep *commandReport, err error) int {
+	code := exitOK
+	if err != nil {
+		code = exitFailed
+		var usage *usageError
+		if errors.As(err, &usage) {
+			code = exitUsage
+		}
+	}
+
+	if opts.json {
+		rep.OK = err == nil
+		if err != nil {
+			rep.Error = err.Error()
+		}
+		if rep.Targets == nil {
+			rep.Targets = []*targetReport{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if encErr := enc.Encode(rep); encErr != nil {
+			fmt.Fprintf(stderr, "protoc-go: %v\n", encErr)
+			return exitFailed
+		}
+		return code
+	}
+
+	multi := len(rep.Targets) > 1
+	for _, t := range rep.Targets {
+		prefix := ""
+		if multi {
+			prefix = t.Name + ": "
+		}
+		switch command {
+		case "compile":
+			io.WriteString(stdout, t.Output)
+			if multi || !t.OK {
+				fmt.Fprintf(stderr, "%s%s", prefix, t.Status)
+				if t.DurationMS > 0 {
+					fmt.Fprintf(stderr, " (%s)", time.Duration(t.DurationMS)*time.Millisecond)
+				}
+				fmt.Fprintln(stderr)
+			}
+		case "check":
+			if t.OK {
+				fmt.Fprintf(stdout, "%sok\n", prefix)
+			}
+		case "list":
+			for _, file := range t.Files {
+				fmt.Fprintf(stdout, "%s%s\n", prefix, file)
+			}
+		case "plan":
+			if t.Plan == nil {
+				break
+			}
+			if multi {
+				fmt.Fprintf(stdout, "# %s\n", t.Name)
+			}
+			for _, cmd := range t.Plan.Commands {
+				if len(cmd.Args) == 0 {
+					fmt.Fprintf(stdout, "# %s without protoc on %d files\n", strings.Join(cmd.Plugins, ", "), len(cmd.Files))
+					continue
+				}
+				fmt.Fprintln(stdout, shellJoin(cmd.Args))
+			}
+		case "clean":
+			for _, file := range t.Removed {
+				fmt.Fprintf(stdout, "%s%s\n", prefix, file)
+			}
+		case "doctor":
+			if multi {
+				fmt.Fprintf(stdout, "# %s\n", t.Name)
+			}
+			t.Doctor.WriteText(stdout)
+			// The report already shows the failed checks.
+			continue
+		case "lint", "breaking":
+			diags := t.Lint
+			if command == "breaking" {
+				diags = t.Breaking
+			}
+			for _, d := range diags {
+				fmt.Fprintf(stdout, "%s%s\n", prefix, d)
+			}
+			if len(diags) > 0 {
+				continue
+			}
+		}
+		for _, msg := range t.Errors {
+			fmt.Fprintf(stderr, "%s%s\n", prefix, msg)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "protoc-go: %v\n", err)
+	}
+	return code
+}
+
+// shellJoin joins args into a command line that a POSIX shell splits back
+// into the same arguments.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, needsQuote) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// needsQuote reports whether r has a special meaning to the shell.
+func needsQuote(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,+@%", r))
+}
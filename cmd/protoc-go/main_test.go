@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func runCommand(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestListAndPlan(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/api/api.proto":    `syntax = "proto3"; package api; option go_package = "example.com/gen/api"; service Api {}`,
+		"proto/model/m.proto":    `syntax = "proto3"; package model; option go_package = "example.com/gen/model";`,
+		"proto/internal/x.proto": `syntax = "proto3"; package internal;`,
+	})
+	proto := filepath.Join(dir, "proto")
+	gen := filepath.Join(dir, "gen")
+
+	code, stdout, stderr := runCommand(t, "list", "-proto_dir", proto, "-exclude", "internal")
+	if code != exitOK {
+		t.Fatalf("list exited with %d: %s", code, stderr)
+	}
+	if want := "api/api.proto\nmodel/m.proto\n"; stdout != want {
+		t.Errorf("list output = %q, want %q", stdout, want)
+	}
+
+	code, stdout, stderr = runCommand(t, "plan", "-json", "-proto_dir", proto, "-output", gen,
+		"-exclude", "internal", "-plugin", "go,go-grpc")
+	if code != exitOK {
+		t.Fatalf("plan exited with %d: %s", code, stderr)
+	}
+	var rep commandReport
+	if err := json.Unmarshal([]byte(stdout), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if !rep.OK || len(rep.Targets) != 1 || rep.Targets[0].Plan == nil {
+		t.Fatalf("unexpected report: %s", stdout)
+	}
+	plan := rep.Targets[0].Plan
+	if len(plan.Commands) != 1 || plan.Commands[0].Args[0] != "protoc" {
+		t.Errorf("unexpected commands: %+v", plan.Commands)
+	}
+	var outputs []string
+	for _, out := range plan.Outputs {
+		rel, _ := filepath.Rel(gen, out.Path)
+		outputs = append(outputs, filepath.ToSlash(rel))
+	}
+	if want := "api/api.pb.go api/api_grpc.pb.go model/m.pb.go"; strings.Join(outputs, " ") != want {
+		t.Errorf("outputs = %v, want %s", outputs, want)
+	}
+}
+
+func TestClean(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/api/api.proto": `syntax = "proto3"; package api;`,
+		"gen/api/api.pb.go":   "package api\n",
+		"gen/keep.txt":        "keep\n",
+	})
+
+	code, stdout, stderr := runCommand(t, "clean", "-proto_dir", filepath.Join(dir, "proto"),
+		"-output", filepath.Join(dir, "gen"), "-go_opt", "paths=source_relative")
+	if code != exitOK {
+		t.Fatalf("clean exited with %d: %s", code, stderr)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(stdout), filepath.Join("api", "api.pb.go")) {
+		t.Errorf("clean output = %q", stdout)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen", "api")); !os.IsNotExist(err) {
+		t.Errorf("emptied directory should be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen", "keep.txt")); err != nil {
+		t.Errorf("unrelated file should be kept: %v", err)
+	}
+}
+
+func TestConfigTargets(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/a/a.proto": `syntax = "proto3"; package a;`,
+		"proto/b/b.proto": `syntax = "proto3"; package b; import "a/a.proto";`,
+		"protoc-go.yaml": `workspace: proto
+output: gen
+targets:
+  - name: a
+    proto_dir: proto/a
+  - name: b
+    proto_dir: proto/b
+    depends_on: [a]
+`,
+	})
+	config := filepath.Join(dir, "protoc-go.yaml")
+
+	code, stdout, stderr := runCommand(t, "list", "-config", config, "b")
+	if code != exitOK {
+		t.Fatalf("list exited with %d: %s", code, stderr)
+	}
+	if want := "a: a/a.proto\nb: b/b.proto\n"; stdout != want {
+		t.Errorf("list output = %q, want %q", stdout, want)
+	}
+
+	code, _, stderr = runCommand(t, "list", "-config", config, "c")
+	if code != exitUsage || !strings.Contains(stderr, `unknown target "c"`) {
+		t.Errorf("unknown target: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestCheckFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/a.proto": `syntax = "proto3"; import "missing.proto";`,
+	})
+
+	code, stdout, _ := runCommand(t, "check", "-json", "-proto_dir", filepath.Join(dir, "proto"), "-output", filepath.Join(dir, "gen"))
+	if code != exitFailed {
+		t.Fatalf("check exited with %d, want %d", code, exitFailed)
+	}
+	var rep commandReport
+	if err := json.Unmarshal([]byte(stdout), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if rep.OK || len(rep.Targets) != 1 || !strings.Contains(strings.Join(rep.Targets[0].Errors, "\n"), `a.proto:1: import "missing.proto" not found`) {
+		t.Errorf("unexpected report: %s", stdout)
+	}
+}
+
+func TestCompileReport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/a.proto": `syntax = "proto3"; message A { int32 }`,
+	})
+	path := filepath.Join(dir, "report.xml")
+
+	code, _, stderr := runCommand(t, "compile", "-backend", "go", "-report", path, "-proto_dir", filepath.Join(dir, "proto"), "-output", filepath.Join(dir, "gen"))
+	if code != exitFailed {
+		t.Fatalf("compile exited with %d, want %d: %s", code, exitFailed, stderr)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<testsuite name="default"`, `<testcase name="a.proto"`, `<failure message="a.proto:1:`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in the report:\n%s", want, data)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/api/api.proto": `syntax = "proto3"; package api; option go_package = "example.com/gen/api"; service Api {}`,
+	})
+
+	code, stdout, stderr := runCommand(t, "lint", "-proto_dir", filepath.Join(dir, "proto"))
+	if code != exitFailed {
+		t.Fatalf("lint exited with %d, want %d: %s", code, exitFailed, stderr)
+	}
+	if want := "api/api.proto:1:84: service name \"Api\" should end in \"Service\" (SERVICE_SUFFIX)\n"; stdout != want {
+		t.Errorf("lint output = %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "lint failed for 1 of 1 targets") {
+		t.Errorf("unexpected stderr: %s", stderr)
+	}
+}
+
+func TestLintReport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proto/api/api.proto": `syntax = "proto3"; package api; option go_package = "example.com/gen/api"; service Api {}`,
+	})
+	path := filepath.Join(dir, "lint.sarif")
+
+	if code, _, stderr := runCommand(t, "lint", "-report", path, "-proto_dir", filepath.Join(dir, "proto")); code != exitFailed {
+		t.Fatalf("lint exited with %d, want %d: %s", code, exitFailed, stderr)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"version": "2.1.0"`, `"ruleId": "SERVICE_SUFFIX"`, `"level": "warning"`, `"uri": "api/api.proto"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %s in the report:\n%s", want, data)
+		}
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	code, stdout, _ := runCommand(t, "doctor", "-json", "-plugin", "cpp", "-workspace", t.TempDir(), "-output", t.TempDir())
+	var rep commandReport
+	if err := json.Unmarshal([]byte(stdout), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(rep.Targets) != 1 || rep.Targets[0].Doctor == nil {
+		t.Fatalf("unexpected report: %s", stdout)
+	}
+	want := exitFailed
+	if rep.Targets[0].Doctor.OK() {
+		want = exitOK
+	}
+	if code != want || rep.OK != (code == exitOK) {
+		t.Errorf("exit %d with ok=%v, want exit %d", code, rep.OK, want)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no command", nil, "Usage: protoc-go"},
+		{"unknown command", []string{"build"}, `unknown command "build"`},
+		{"unknown flag", []string{"list", "-nope"}, "flag provided but not defined"},
+		{"no config", []string{"list", "-config", "missing.yaml"}, "read config"},
+		{"breaking without baseline", []string{"breaking", "-proto_dir", "proto"}, "breaking requires -against"},
+		{"unknown breaking level", []string{"breaking", "-against", "main", "-level", "SOURCE"}, `unknown breaking level "SOURCE"`},
+		{"unknown backend", []string{"compile", "-backend", "buf", "-proto_dir", "proto"}, `unknown backend "buf"`},
+		{"unknown report format", []string{"compile", "-report", "out.html", "-report_format", "html", "-proto_dir", "proto"}, `unknown report format "html"`},
+		{"lint report format", []string{"lint", "-report", "lint.json", "-report_format", "json", "-proto_dir", "proto"}, "lint reports are written as sarif"},
+		{"report of list", []string{"list", "-report", "list.json", "-proto_dir", "proto"}, "-report is only supported by compile, lint and breaking"},
+		{"flags without sources", []string{"list", "-config", "x.yaml", "-output", "gen"}, "require -proto_dir or -root"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCommand(t, tt.args...)
+			if code != exitUsage || !strings.Contains(stderr, tt.want) {
+				t.Errorf("exit %d, stderr %q; want exit %d with %q", code, stderr, exitUsage, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"protoc", "-I", "my dir", "--go_out=paths=source_relative:gen", "it's"})
+	want := `protoc -I 'my dir' --go_out=paths=source_relative:gen 'it'\''s'`
+	if got != want {
+		t.Errorf("shellJoin = %s, want %s", got, want)
+	}
+}
@@ -4,24 +4,61 @@ package protoc
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // compilerImpl is the internal implementation of the compiler.
 type compilerImpl struct {
-	protoDir     string
-	workspaceDir string
-	outputDir    string
-	plugins      []string
-	goOpts       []string
-	goGrpcOpts   []string
-	verbose      bool
-	ctx          context.Context
+	protoDir       string
+	workspaceDir   string
+	protoFS        fs.FS
+	protoFSRoot    string
+	outputDir      string
+	importPaths    []string
+	roots          []string
+	excludes       []string
+	plugins        []string
+	goOpts         []string
+	goGrpcOpts     []string
+	goPackageMap   map[string]string
+	goPkgPrefix    string
+	goPkgCheck     bool
+	goFormat       bool
+	fixImports     bool
+	headers        []headerRule
+	buildTags      []buildTagRule
+	postProcessors []PostProcessor
+	generated      []string // files written by the current run, for post-processing
+	descPkgDir     string
+	descPkgName    string
+	generators     map[string]GeneratorFunc
+	backend        Backend
+	goMappings     []string // M options resolved by resolveGoPackages
+	pluginOpts     map[string][]string
+	pluginOut      map[string]string
+	pluginPaths    map[string]string
+	strategies     map[string]Strategy
+	lintRules      []string
+	lintExcept     []string
+	breakingLevel  BreakingLevel
+	protocVer      string
+	protocSource   string
+	protocCache    string
+	protocBin      string         // protoc binary found by findProtoc
+	result         *CompileResult // Collects details for CompileWithResult; may be nil
+	wellKnownTypes bool
+	wellKnownDir   string // bundled well-known types set up by setupWellKnownTypes
+	verbose        bool
+	ctx            context.Context
 
 	mu sync.Mutex
 }
@@ -41,23 +78,40 @@ func (c *compilerImpl) compile() (string, error) {
 		return "", err
 	}
 
-	// Find all .proto files in the proto directory
-	files, err := c.findProtoFiles()
-	if err != nil {
-		return "", fmt.Errorf("find proto files: %w", err)
+	// Check the protoc version if one is required
+	if err := c.checkProtocVersion(); err != nil {
+		return "", err
 	}
 
-	if len(files) == 0 {
-		return "", fmt.Errorf("no .proto files found in %s", c.protoDir)
+	// Find all .proto files in the proto directory, or the files
+	// reachable from the roots
+	files, err := c.collectFiles()
+	if err != nil {
+		return "", err
 	}
 
-	// Create output directory
-	if err := os.MkdirAll(c.outputDir, 0755); err != nil {
-		return "", fmt.Errorf("create output directory: %w", err)
+	return c.compileFiles(files, files)
+}
+
+// compileFiles runs protoc on the given files of a validated compiler. all
+// is the whole set of files, of which files may be a subset when only the
+// files affected by a change are recompiled; the descriptor package always
+// covers all of them.
+func (c *compilerImpl) compileFiles(files, all []string) (string, error) {
+	// Create output directories
+	for _, dir := range c.outputDirs() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("create output directory: %w", err)
+		}
 	}
 
-	// Build and execute protoc command
-	cmd := c.buildCommand(files)
+	c.recordFiles(files)
+	if err := c.resolveGoPackages(files); err != nil {
+		return "", err
+	}
+	if err := c.validateGoPackages(files); err != nil {
+		return "", err
+	}
 
 	if c.verbose {
 		fmt.Printf("Found %d .proto files:\n", len(files))
@@ -65,25 +119,125 @@ func (c *compilerImpl) compile() (string, error) {
 			relPath, _ := filepath.Rel(c.workspaceDir, file)
 			fmt.Printf("  - %s\n", relPath)
 		}
-		fmt.Printf("Executing: %s\n", strings.Join(cmd.Args, " "))
 	}
 
-	// Execute command
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return string(output), fmt.Errorf("protoc execution failed: %w", err)
+	c.generated = nil
+
+	// Build and execute protoc commands, usually a single one
+	var combined strings.Builder
+	for _, inv := range c.invocations(files) {
+		external, inProcess := c.splitGenerators(inv.plugins)
+		if !c.usesProtoc() {
+			// The backend only compiles; all plugins are run here
+			external, inProcess = nil, inv.plugins
+		}
+		if c.usesProtoc() && (len(external) > 0 || len(inProcess) == 0) {
+			cmd := c.buildCommand(external, inv.files)
+			args := cmd.Args
+
+			// Collect the output of protoc in staging directories to learn
+			// which files it generated
+			var stage *outputStage
+			if c.postProcessing() {
+				var err error
+				if stage, err = c.newOutputStage(external); err != nil {
+					return combined.String(), err
+				}
+				cmd = c.protocCommand(external, inv.files, stage.outputDir)
+			}
+
+			if c.verbose {
+				fmt.Printf("Executing: %s\n", strings.Join(cmd.Args, " "))
+			}
+
+			// Execute command
+			start := time.Now()
+			output, err := cmd.CombinedOutput()
+			combined.Write(output)
+			c.recordCommand(args, external, inv.files, start, err)
+			if err == nil && stage != nil {
+				err = stage.commit()
+			}
+			if stage != nil {
+				stage.cleanup()
+			}
+			if err != nil {
+				return combined.String(), fmt.Errorf("protoc execution failed: %w", err)
+			}
+
+			if c.verbose && len(output) > 0 {
+				fmt.Printf("protoc output: %s\n", output)
+			}
+		}
+
+		// Plugins implemented in Go run after protoc, so their insertion
+		// points can target files of the other plugins
+		if len(inProcess) > 0 || !c.usesProtoc() {
+			start := time.Now()
+			err := c.runPlugins(inProcess, inv.files)
+			c.recordCommand(nil, inProcess, inv.files, start, err)
+			if err != nil {
+				return combined.String(), err
+			}
+		}
+	}
+
+	if err := c.postProcess(); err != nil {
+		return combined.String(), err
+	}
+
+	if err := c.writeDescriptorPackage(all); err != nil {
+		return combined.String(), err
 	}
 
-	if c.verbose && len(output) > 0 {
-		fmt.Printf("protoc output: %s\n", output)
+	return combined.String(), nil
+}
+
+// invocation is a single protoc run: a set of plugins applied to a set of
+// files.
+type invocation struct {
+	plugins []string
+	files   []string
+}
+
+// invocations splits the compilation into protoc runs. Plugins using
+// StrategyAll share one run over all files; plugins using
+// StrategyDirectory share one run per directory.
+func (c *compilerImpl) invocations(files []string) []invocation {
+	var all, perDir []string
+	for _, plugin := range c.plugins {
+		if c.strategies[plugin] == StrategyDirectory {
+			perDir = append(perDir, plugin)
+		} else {
+			all = append(all, plugin)
+		}
 	}
 
-	return string(output), nil
+	var invs []invocation
+	if len(all) > 0 || len(perDir) == 0 {
+		invs = append(invs, invocation{plugins: all, files: files})
+	}
+	if len(perDir) > 0 {
+		groups := make(map[string][]string)
+		var dirs []string
+		for _, file := range files {
+			dir := filepath.Dir(file)
+			if _, ok := groups[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			groups[dir] = append(groups[dir], file)
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			invs = append(invs, invocation{plugins: perDir, files: groups[dir]})
+		}
+	}
+	return invs
 }
 
 // validate checks the compiler configuration.
 func (c *compilerImpl) validate() error {
-	if c.protoDir == "" {
+	if c.protoDir == "" && len(c.roots) == 0 {
 		return fmt.Errorf("proto directory not specified")
 	}
 
@@ -95,6 +249,36 @@ func (c *compilerImpl) validate() error {
 		return fmt.Errorf("output directory not specified")
 	}
 
+	if err := c.validateHeaders(); err != nil {
+		return err
+	}
+
+	if err := c.validateDescriptorPackage(); err != nil {
+		return err
+	}
+
+	return c.validateSources()
+}
+
+// validateSources checks the proto and workspace directories, which is all
+// that operations reading the sources without generating code need.
+func (c *compilerImpl) validateSources() error {
+	if c.protoDir == "" && len(c.roots) == 0 {
+		return fmt.Errorf("proto directory not specified")
+	}
+
+	if c.workspaceDir == "" {
+		return fmt.Errorf("workspace directory not specified")
+	}
+
+	// Check if workspace directory exists
+	if c.protoDir == "" {
+		if _, err := os.Stat(c.workspaceDir); os.IsNotExist(err) {
+			return fmt.Errorf("workspace directory does not exist: %s", c.workspaceDir)
+		}
+		return nil
+	}
+
 	// Check if proto directory exists
 	if _, err := os.Stat(c.protoDir); os.IsNotExist(err) {
 		return fmt.Errorf("proto directory does not exist: %s", c.protoDir)
@@ -115,7 +299,88 @@ func (c *compilerImpl) validate() error {
 	return nil
 }
 
-// findProtoFiles recursively finds all .proto files in the proto directory.
+// collectFiles returns the files to compile: every .proto file in the proto
+// directory, or, when roots are set, the roots and the workspace files they
+// import.
+func (c *compilerImpl) collectFiles() ([]string, error) {
+	if len(c.roots) > 0 {
+		return c.rootClosure()
+	}
+
+	files, err := c.findProtoFiles()
+	if err != nil {
+		return nil, fmt.Errorf("find proto files: %w", err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .proto files found in %s", c.protoDir)
+	}
+
+	return files, nil
+}
+
+// resolveRoots returns the absolute paths of the root files. A root is
+// looked up relative to the workspace directory first and then as a
+// filesystem path; either way it must be inside the workspace.
+func (c *compilerImpl) resolveRoots() ([]string, error) {
+	var files []string
+	for _, root := range c.roots {
+		candidates := []string{root}
+		if !filepath.IsAbs(root) {
+			candidates = []string{filepath.Join(c.workspaceDir, filepath.FromSlash(root)), root}
+		}
+
+		path := ""
+		for _, candidate := range candidates {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				path = candidate
+				break
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("root file not found: %s", root)
+		}
+
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("resolve root file: %w", err)
+		}
+		if !c.inWorkspace(absPath) {
+			return nil, fmt.Errorf("root file %s must be within workspace directory %s",
+				root, c.workspaceDir)
+		}
+		files = append(files, absPath)
+	}
+
+	return files, nil
+}
+
+// rootClosure resolves the roots and returns them together with every file
+// they import, directly or transitively, that lives in the workspace. Files
+// found on other include paths are left for protoc to load as imports.
+func (c *compilerImpl) rootClosure() ([]string, error) {
+	roots, err := c.resolveRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := c.buildGraph(c.ctx, roots)
+	if err != nil {
+		return nil, fmt.Errorf("resolve imports: %w", err)
+	}
+
+	var files []string
+	for _, name := range graph.Closure(graph.Files...) {
+		if node := graph.Nodes[name]; !node.External {
+			files = append(files, node.Path)
+		}
+	}
+
+	return files, nil
+}
+
+// findProtoFiles recursively finds all .proto files in the proto directory,
+// walking the file system set with WithProtoFS if any.
 func (c *compilerImpl) findProtoFiles() ([]string, error) {
 	var files []string
 
@@ -124,16 +389,34 @@ func (c *compilerImpl) findProtoFiles() ([]string, error) {
 		return nil, fmt.Errorf("resolve proto directory: %w", err)
 	}
 
-	err = filepath.Walk(absProtoDir, func(path string, info os.FileInfo, err error) error {
+	// Walk the proto directory, or the proto file system with paths
+	// mapped to its copy in the workspace
+	fsys, root, base := os.DirFS(absProtoDir), ".", absProtoDir
+	if c.protoFS != nil {
+		fsys, root = c.protoFS, path.Clean(c.protoFSRoot)
+		if base, err = filepath.Abs(c.workspaceDir); err != nil {
+			return nil, fmt.Errorf("resolve workspace directory: %w", err)
+		}
+	}
+
+	err = fs.WalkDir(fsys, root, func(name string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		if c.excluded(path) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
 			return nil
 		}
 
-		if strings.HasSuffix(strings.ToLower(path), ".proto") {
+		if strings.HasSuffix(strings.ToLower(name), ".proto") {
 			files = append(files, path)
 		}
 
@@ -147,8 +430,14 @@ func (c *compilerImpl) findProtoFiles() ([]string, error) {
 	return files, nil
 }
 
-// buildCommand constructs the protoc command with the found files.
-func (c *compilerImpl) buildCommand(files []string) *exec.Cmd {
+// buildCommand constructs the protoc command running plugins on files.
+func (c *compilerImpl) buildCommand(plugins, files []string) *exec.Cmd {
+	return c.protocCommand(plugins, files, c.pluginOutputDir)
+}
+
+// protocCommand constructs the protoc command running plugins on files,
+// writing the output of each plugin to outputDir(plugin).
+func (c *compilerImpl) protocCommand(plugins, files []string, outputDir func(plugin string) string) *exec.Cmd {
 	args := []string{}
 
 	// Add workspace directory as single -I parameter
@@ -156,21 +445,27 @@ func (c *compilerImpl) buildCommand(files []string) *exec.Cmd {
 	workspacePath := filepath.ToSlash(c.workspaceDir)
 	args = append(args, "-I", workspacePath)
 
-	// Add plugin outputs
-	for _, plugin := range c.plugins {
-		switch plugin {
-		case "go":
-			outputPath := filepath.ToSlash(c.outputDir)
-			args = append(args, "--go_out="+buildPluginOpts("", c.goOpts, outputPath))
-		case "go-grpc":
-			outputPath := filepath.ToSlash(c.outputDir)
-			args = append(args, "--go-grpc_out="+buildPluginOpts("", c.goGrpcOpts, outputPath))
-		default:
-			outputPath := filepath.ToSlash(c.outputDir)
-			args = append(args, fmt.Sprintf("--%s_out=%s", plugin, outputPath))
+	// Add extra import paths after the workspace so workspace files win
+	for _, dir := range c.importPaths {
+		args = append(args, "-I", filepath.ToSlash(dir))
+	}
+	for _, dir := range c.wellKnownIncludes() {
+		args = append(args, "-I", filepath.ToSlash(dir))
+	}
+
+	// Add plugin executables that are not looked up in PATH
+	for _, plugin := range plugins {
+		if path, ok := c.pluginPaths[plugin]; ok && path != "" {
+			args = append(args, fmt.Sprintf("--plugin=protoc-gen-%s=%s", plugin, filepath.ToSlash(path)))
 		}
 	}
 
+	// Add plugin outputs
+	for _, plugin := range plugins {
+		outputPath := filepath.ToSlash(outputDir(plugin))
+		args = append(args, fmt.Sprintf("--%s_out=%s", plugin, buildPluginOpts("", c.pluginParameter(plugin), outputPath)))
+	}
+
 	// Add all proto files with paths relative to workspace directory
 	// Use forward slashes for better cross-platform compatibility
 	for _, file := range files {
@@ -190,46 +485,154 @@ func (c *compilerImpl) buildCommand(files []string) *exec.Cmd {
 		}
 	}
 
-	return exec.CommandContext(c.ctx, "protoc", args...)
+	return exec.CommandContext(c.ctx, c.protocExecutable(), args...)
 }
 
-// checkProtocAvailable checks if protoc is available in the system PATH.
-func (c *compilerImpl) checkProtocAvailable() error {
-	// Try to find protoc in PATH
-	_, err := exec.LookPath("protoc")
+// pluginParameter returns the options passed to a plugin.
+func (c *compilerImpl) pluginParameter(plugin string) []string {
+	switch plugin {
+	case "go", "go-grpc":
+		return c.goPluginOpts(plugin)
+	}
+	return c.pluginOpts[plugin]
+}
+
+// excluded reports whether file matches one of the exclude patterns.
+func (c *compilerImpl) excluded(file string) bool {
+	if len(c.excludes) == 0 {
+		return false
+	}
+
+	name, err := c.importName(file)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range c.excludes {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginOutputDir returns the output directory of a plugin.
+func (c *compilerImpl) pluginOutputDir(plugin string) string {
+	if dir, ok := c.pluginOut[plugin]; ok && dir != "" {
+		return dir
+	}
+	return c.outputDir
+}
+
+// outputDirs returns the distinct output directories of all plugins.
+func (c *compilerImpl) outputDirs() []string {
+	var dirs []string
+	for _, plugin := range c.plugins {
+		dirs = append(dirs, c.pluginOutputDir(plugin))
+	}
+	if len(dirs) == 0 {
+		dirs = append(dirs, c.outputDir)
+	}
+	return uniqueStrings(dirs)
+}
+
+// checkProtocVersion checks that protoc has the required version, if any.
+func (c *compilerImpl) checkProtocVersion() error {
+	if c.protocVer == "" || !c.usesProtoc() {
+		return nil
+	}
+
+	version, err := protocVersion(c.ctx, c.protocExecutable())
 	if err != nil {
-		// Provide helpful error message based on the operating system
-		var platformHint string
-		switch runtime.GOOS {
-		case "windows":
-			platformHint = "\n\nTo install protoc on Windows:\n" +
-				"1. Download protoc from: https://github.com/protocolbuffers/protobuf/releases\n" +
-				"2. Extract the zip file\n" +
-				"3. Add the 'bin' directory to your PATH environment variable\n" +
-				"4. Restart your terminal or IDE"
-		case "darwin":
-			platformHint = "\n\nTo install protoc on macOS:\n" +
-				"1. Using Homebrew: brew install protobuf\n" +
-				"2. Or download from: https://github.com/protocolbuffers/protobuf/releases"
-		case "linux":
-			platformHint = "\n\nTo install protoc on Linux:\n" +
-				"1. Using apt: sudo apt-get install protobuf-compiler\n" +
-				"2. Using yum: sudo yum install protobuf-compiler\n" +
-				"3. Or download from: https://github.com/protocolbuffers/protobuf/releases"
-		default:
-			platformHint = "\n\nPlease install protoc from: https://github.com/protocolbuffers/protobuf/releases"
-		}
-
-		return fmt.Errorf("protoc not found in PATH. Please ensure protoc is installed and added to your PATH environment variable.%s", platformHint)
+		return err
+	}
+
+	if !versionMatches(version, c.protocVer) {
+		return fmt.Errorf("protoc version %s does not match required version %s", version, c.protocVer)
 	}
 
 	if c.verbose {
-		fmt.Println("✓ protoc found in PATH")
+		fmt.Printf("✓ protoc version %s\n", version)
 	}
 
 	return nil
 }
 
+// protocVersion runs "protoc --version" and returns the version number,
+// e.g. "28.3" for "libprotoc 28.3".
+func protocVersion(ctx context.Context, protoc string) (string, error) {
+	output, err := exec.CommandContext(ctx, protoc, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("get protoc version: %w", err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("get protoc version: unexpected output %q", output)
+	}
+	return fields[len(fields)-1], nil
+}
+
+// versionMatches reports whether version equals want or starts with it at a
+// component boundary.
+func versionMatches(version, want string) bool {
+	want = strings.TrimPrefix(want, "v")
+	return version == want || strings.HasPrefix(version, want+".")
+}
+
+// checkProtocAvailable checks if protoc is available in the system PATH, or
+// can be installed from the protoc source, and sets up the bundled
+// well-known types if protoc lacks them.
+func (c *compilerImpl) checkProtocAvailable() error {
+	if !c.usesProtoc() {
+		return nil
+	}
+
+	// Try to find protoc in PATH, or install it
+	protoc, err := c.findProtoc()
+	if err != nil {
+		if c.protocSource != "" {
+			return err
+		}
+		return fmt.Errorf("protoc not found in PATH. Please ensure protoc is installed and added to your PATH environment variable.%s", protocInstallHint())
+	}
+
+	if c.verbose {
+		fmt.Printf("✓ protoc found: %s\n", protoc)
+	}
+
+	return c.setupWellKnownTypes()
+}
+
+// protocInstallHint returns installation instructions for protoc on the
+// current operating system.
+func protocInstallHint() string {
+	// Provide helpful error message based on the operating system
+	var platformHint string
+	switch runtime.GOOS {
+	case "windows":
+		platformHint = "\n\nTo install protoc on Windows:\n" +
+			"1. Download protoc from: https://github.com/protocolbuffers/protobuf/releases\n" +
+			"2. Extract the zip file\n" +
+			"3. Add the 'bin' directory to your PATH environment variable\n" +
+			"4. Restart your terminal or IDE"
+	case "darwin":
+		platformHint = "\n\nTo install protoc on macOS:\n" +
+			"1. Using Homebrew: brew install protobuf\n" +
+			"2. Or download from: https://github.com/protocolbuffers/protobuf/releases"
+	case "linux":
+		platformHint = "\n\nTo install protoc on Linux:\n" +
+			"1. Using apt: sudo apt-get install protobuf-compiler\n" +
+			"2. Using yum: sudo yum install protobuf-compiler\n" +
+			"3. Or download from: https://github.com/protocolbuffers/protobuf/releases"
+	default:
+		platformHint = "\n\nPlease install protoc from: https://github.com/protocolbuffers/protobuf/releases"
+	}
+
+	return platformHint
+}
+
 // buildPluginOpts builds the plugin options string.
 func buildPluginOpts(prefix string, options []string, outputDir string) string {
 	var opts []string
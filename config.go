@@ -0,0 +1,675 @@
+package protoc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is the conventional name of a configuration file.
+const DefaultConfigFile = "protoc-go.yaml"
+
+// Config is a configuration file loaded with LoadConfig.
+//
+// A configuration file is YAML or JSON. Relative paths are resolved against
+// the directory containing the file. Settings at the top level apply to
+// every target and may be overridden per target; import_paths and excludes
+// are added to the top-level lists instead of replacing them.
+//
+//	version: v1
+//	workspace: proto
+//	import_paths: [third_party]
+//	protoc_version: "28"
+//	protoc_source: mirror/protoc
+//	well_known_types: true
+//	backend: protoc
+//	excludes: ["internal"]
+//	output: gen
+//	go_package_prefix: example.com/project/gen
+//	go_package_validation: true
+//	go_package_map:
+//	  vendor/money.proto: example.com/money
+//	plugins:
+//	  - name: go
+//	    opt: paths=source_relative
+//	  - name: go-grpc
+//	    out: gen/grpc
+//	    opt: [paths=source_relative, require_unimplemented_servers=false]
+//	  - name: validate
+//	    path: bin/protoc-gen-validate
+//	    strategy: directory
+//	lint:
+//	  except: [SERVICE_SUFFIX]
+//	targets:
+//	  - name: api
+//	    proto_dir: proto/api
+//	  - name: admin
+//	    roots: [admin/admin.proto]
+//	    output: gen/admin
+//	    depends_on: [api]
+//
+// A file without targets describes a single target named "default" using
+// proto_dir or roots at the top level. depends_on names targets that must
+// be built first; see Config.Project.
+type Config struct {
+	Path    string    // Path the configuration was loaded from
+	Targets []*Target // Compile targets in file order
+}
+
+// Target is a named compile target of a configuration.
+type Target struct {
+	Name      string
+	Compiler  *Compiler
+	DependsOn []string // Targets built before this one
+}
+
+// Target returns the target with the given name.
+func (cfg *Config) Target(name string) (*Target, bool) {
+	for _, target := range cfg.Targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return nil, false
+}
+
+// Compiler returns the compiler of a configuration with a single target.
+func (cfg *Config) Compiler() (*Compiler, error) {
+	if len(cfg.Targets) != 1 {
+		return nil, fmt.Errorf("%s: configuration has %d targets, select one by name", cfg.Path, len(cfg.Targets))
+	}
+	return cfg.Targets[0].Compiler, nil
+}
+
+// Project returns a Project building all targets of the configuration in
+// dependency order.
+func (cfg *Config) Project() *Project {
+	p := NewProject()
+	for _, target := range cfg.Targets {
+		p.AddTarget(target.Name, target.Compiler, target.DependsOn...)
+	}
+	return p
+}
+
+// ConfigError is an invalid value in a configuration file, reported with
+// the position it was found at.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// configPlugin is a plugin entry of a configuration file.
+type configPlugin struct {
+	name     string
+	out      string
+	opts     []string
+	path     string
+	strategy Strategy
+	node     *yaml.Node
+}
+
+// configTarget holds the settings of a target, or the top-level defaults.
+type configTarget struct {
+	name          string
+	workspace     string
+	protoDir      string
+	roots         []string
+	importPaths   []string
+	excludes      []string
+	output        string
+	plugins       []configPlugin
+	pluginsSet    bool
+	protocVersion string
+	protocSource  string
+	wellKnown     bool
+	backend       Backend
+	goPkgPrefix   string
+	goPackageMap  map[string]string
+	goPkgCheck    bool
+	lintRules     []string
+	lintExcept    []string
+	dependsOn     []string
+	dependsOnNode *yaml.Node
+	node          *yaml.Node
+}
+
+// configDecoder turns a YAML node tree into configuration values,
+// recording the file for error positions.
+type configDecoder struct {
+	file string
+	dir  string
+}
+
+func (d *configDecoder) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	return &ConfigError{File: d.file, Line: node.Line, Column: node.Column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// yamlErrorLine extracts the line number from yaml.v3 syntax errors.
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// LoadConfig reads a YAML or JSON configuration file and returns the
+// compilers it describes. Errors in the file are reported as *ConfigError
+// with the line they occur on.
+func LoadConfig(path string) (*Config, error) {
+	d, root, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := d.decode(root)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{Path: path}
+	for _, t := range targets {
+		cfg.Targets = append(cfg.Targets, &Target{Name: t.name, Compiler: t.compiler(), DependsOn: t.dependsOn})
+	}
+	return cfg, nil
+}
+
+// readConfigFile parses a YAML or JSON file and returns its root node with
+// a decoder resolving paths against the file's directory.
+func readConfigFile(path string) (*configDecoder, *yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read config: %w", err)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve config path: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, nil, &ConfigError{File: path, Line: line, Msg: m[2]}
+		}
+		return nil, nil, &ConfigError{File: path, Line: 1, Msg: err.Error()}
+	}
+
+	d := &configDecoder{file: path, dir: filepath.Dir(absPath)}
+	if len(doc.Content) == 0 {
+		return nil, nil, &ConfigError{File: path, Line: 1, Msg: "empty configuration"}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, d.errorf(doc.Content[0], "configuration must be a mapping")
+	}
+
+	return d, doc.Content[0], nil
+}
+
+// decode validates the root mapping and returns the resolved targets.
+func (d *configDecoder) decode(root *yaml.Node) ([]*configTarget, error) {
+	defaults := &configTarget{node: root}
+	var targetsNode *yaml.Node
+	err := d.fields(root, "configuration", func(key string, value *yaml.Node) (bool, error) {
+		switch key {
+		case "version":
+			version, err := d.str(value)
+			if err == nil && version != "v1" {
+				err = d.errorf(value, "unsupported version %q, expected \"v1\"", version)
+			}
+			return true, err
+		case "targets":
+			if value.Kind != yaml.SequenceNode {
+				return true, d.errorf(value, "targets must be a list")
+			}
+			targetsNode = value
+			return true, nil
+		}
+		return d.targetField(defaults, key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*configTarget
+	if targetsNode == nil {
+		defaults.name = "default"
+		targets = append(targets, defaults)
+	} else {
+		if defaults.protoDir != "" || len(defaults.roots) > 0 {
+			return nil, d.errorf(root, "proto_dir and roots must be set per target when targets are listed")
+		}
+		seen := make(map[string]bool)
+		for _, node := range targetsNode.Content {
+			t, err := d.target(defaults, node)
+			if err != nil {
+				return nil, err
+			}
+			if seen[t.name] {
+				return nil, d.errorf(node, "duplicate target name %q", t.name)
+			}
+			seen[t.name] = true
+			targets = append(targets, t)
+		}
+		if len(targets) == 0 {
+			return nil, d.errorf(targetsNode, "targets must not be empty")
+		}
+	}
+
+	for _, t := range targets {
+		if err := d.check(t); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.checkDependencies(targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// checkDependencies verifies that depends_on names existing targets
+// without forming a cycle.
+func (d *configDecoder) checkDependencies(targets []*configTarget) error {
+	byName := make(map[string]*configTarget, len(targets))
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		byName[t.name] = t
+		names = append(names, t.name)
+	}
+	for _, t := range targets {
+		for _, dep := range t.dependsOn {
+			if _, ok := byName[dep]; !ok {
+				return d.errorf(t.dependsOnNode, "target %q depends on unknown target %q", t.name, dep)
+			}
+		}
+	}
+
+	deps := func(name string) []string { return byName[name].dependsOn }
+	cycles := findCycles(names, deps)
+	if len(cycles) > 0 {
+		path := cyclePath(cycles[0], deps)
+		t := byName[path[0]]
+		return d.errorf(t.dependsOnNode, "target dependency cycle: %s", strings.Join(path, " -> "))
+	}
+	return nil
+}
+
+// target decodes a target entry on top of the top-level defaults.
+func (d *configDecoder) target(defaults *configTarget, node *yaml.Node) (*configTarget, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, d.errorf(node, "target must be a mapping")
+	}
+
+	t := *defaults
+	t.node = node
+	t.importPaths = append([]string(nil), defaults.importPaths...)
+	t.excludes = append([]string(nil), defaults.excludes...)
+	err := d.fields(node, "target", func(key string, value *yaml.Node) (bool, error) {
+		switch key {
+		case "name":
+			name, err := d.str(value)
+			t.name = name
+			return true, err
+		case "depends_on":
+			deps, err := d.strs(value)
+			t.dependsOn = deps
+			t.dependsOnNode = value
+			return true, err
+		}
+		return d.targetField(&t, key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.name == "" {
+		return nil, d.errorf(node, "target is missing required field \"name\"")
+	}
+	return &t, nil
+}
+
+// targetField decodes a setting shared by the top level and targets.
+func (d *configDecoder) targetField(t *configTarget, key string, value *yaml.Node) (bool, error) {
+	var err error
+	switch key {
+	case "workspace":
+		t.workspace, err = d.path(value)
+	case "proto_dir":
+		t.protoDir, err = d.path(value)
+	case "roots":
+		t.roots, err = d.strs(value)
+	case "import_paths":
+		var dirs []string
+		dirs, err = d.paths(value)
+		t.importPaths = append(t.importPaths, dirs...)
+	case "excludes":
+		var patterns []string
+		patterns, err = d.strs(value)
+		t.excludes = append(t.excludes, patterns...)
+	case "output":
+		t.output, err = d.path(value)
+	case "protoc_version":
+		t.protocVersion, err = d.str(value)
+	case "protoc_source":
+		t.protocSource, err = d.protocSource(value)
+	case "well_known_types":
+		t.wellKnown, err = d.boolean(value)
+	case "backend":
+		t.backend, err = d.backend(value)
+	case "plugins":
+		t.plugins, err = d.plugins(value)
+		t.pluginsSet = true
+	case "go_package_prefix":
+		t.goPkgPrefix, err = d.str(value)
+	case "go_package_map":
+		t.goPackageMap, err = d.strMap(value)
+	case "go_package_validation":
+		t.goPkgCheck, err = d.boolean(value)
+	case "lint":
+		err = d.lint(t, value)
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// lint decodes the lint rule selection.
+func (d *configDecoder) lint(t *configTarget, node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return d.errorf(node, "lint must be a mapping")
+	}
+	return d.fields(node, "lint", func(key string, value *yaml.Node) (bool, error) {
+		var rules *[]string
+		switch key {
+		case "use":
+			rules = &t.lintRules
+		case "except":
+			rules = &t.lintExcept
+		default:
+			return false, nil
+		}
+		names, err := d.strs(value)
+		if err != nil {
+			return true, err
+		}
+		for _, name := range names {
+			if !isLintRule(name) {
+				return true, d.errorf(value, "unknown lint rule %q", name)
+			}
+		}
+		*rules = names
+		return true, nil
+	})
+}
+
+// plugins decodes a list of plugin entries.
+func (d *configDecoder) plugins(node *yaml.Node) ([]configPlugin, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, d.errorf(node, "plugins must be a list")
+	}
+
+	var plugins []configPlugin
+	seen := make(map[string]bool)
+	for _, entry := range node.Content {
+		if entry.Kind != yaml.MappingNode {
+			return nil, d.errorf(entry, "plugin must be a mapping")
+		}
+		plugin := configPlugin{node: entry}
+		err := d.fields(entry, "plugin", func(key string, value *yaml.Node) (bool, error) {
+			var err error
+			switch key {
+			case "name":
+				plugin.name, err = d.str(value)
+			case "out":
+				plugin.out, err = d.path(value)
+			case "opt":
+				plugin.opts, err = d.strs(value)
+			case "path":
+				plugin.path, err = d.path(value)
+			case "strategy":
+				plugin.strategy, err = d.strategy(value)
+			default:
+				return false, nil
+			}
+			return true, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if plugin.name == "" {
+			return nil, d.errorf(entry, "plugin is missing required field \"name\"")
+		}
+		if seen[plugin.name] {
+			return nil, d.errorf(entry, "duplicate plugin %q", plugin.name)
+		}
+		seen[plugin.name] = true
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// check verifies that a resolved target has everything a Compiler needs.
+func (d *configDecoder) check(t *configTarget) error {
+	if t.workspace == "" {
+		return d.errorf(t.node, "target %q is missing required field \"workspace\"", t.name)
+	}
+	if t.protoDir == "" && len(t.roots) == 0 {
+		return d.errorf(t.node, "target %q needs \"proto_dir\" or \"roots\"", t.name)
+	}
+	if t.output == "" {
+		if !t.pluginsSet || len(t.plugins) == 0 {
+			return d.errorf(t.node, "target %q is missing required field \"output\"", t.name)
+		}
+		for _, plugin := range t.plugins {
+			if plugin.out == "" {
+				return d.errorf(plugin.node, "plugin %q needs \"out\" when target %q has no \"output\"", plugin.name, t.name)
+			}
+		}
+	}
+	return nil
+}
+
+// compiler builds the Compiler for a resolved target.
+func (t *configTarget) compiler() *Compiler {
+	c := NewCompiler().
+		WithProtoWorkSpace(t.workspace).
+		WithImportPaths(t.importPaths...).
+		WithExcludes(t.excludes...).
+		WithProtocVersion(t.protocVersion).
+		WithProtocSource(t.protocSource).
+		WithWellKnownTypes(t.wellKnown).
+		WithLintRules(t.lintRules...).
+		WithLintExcept(t.lintExcept...).
+		WithGoPackagePrefix(t.goPkgPrefix).
+		WithGoPackageMapping(t.goPackageMap).
+		WithGoPackageValidation(t.goPkgCheck)
+	if t.protoDir != "" {
+		c.WithProtoDir(t.protoDir)
+	}
+	if len(t.roots) > 0 {
+		c.WithRoots(t.roots...)
+	}
+	if t.backend != nil {
+		c.WithBackend(t.backend)
+	}
+
+	output := t.output
+	if t.pluginsSet {
+		var names []string
+		for _, plugin := range t.plugins {
+			names = append(names, plugin.name)
+			if plugin.opts != nil {
+				c.WithPluginOpts(plugin.name, plugin.opts...)
+			}
+			if plugin.path != "" {
+				c.WithPluginPath(plugin.name, plugin.path)
+			}
+			if plugin.strategy != "" {
+				c.WithPluginStrategy(plugin.name, plugin.strategy)
+			}
+			if plugin.out != "" {
+				c.WithPluginOutputDir(plugin.name, plugin.out)
+				if output == "" {
+					output = plugin.out
+				}
+			}
+		}
+		c.WithPlugins(names...)
+	}
+	return c.WithOutputDir(output)
+}
+
+// fields calls fn for each key of a mapping node and reports unknown keys,
+// for which fn returns false.
+func (d *configDecoder) fields(node *yaml.Node, what string, fn func(key string, value *yaml.Node) (bool, error)) error {
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, value := node.Content[i], node.Content[i+1]
+		key := keyNode.Value
+		if seen[key] {
+			return d.errorf(keyNode, "duplicate field %q in %s", key, what)
+		}
+		seen[key] = true
+		known, err := fn(key, value)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return d.errorf(keyNode, "unknown field %q in %s", key, what)
+		}
+	}
+	return nil
+}
+
+// str decodes a scalar as a string.
+func (d *configDecoder) str(node *yaml.Node) (string, error) {
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		return "", d.errorf(node, "expected a string")
+	}
+	return node.Value, nil
+}
+
+// boolean decodes a scalar as a bool.
+func (d *configDecoder) boolean(node *yaml.Node) (bool, error) {
+	var b bool
+	if node.Kind != yaml.ScalarNode || node.Decode(&b) != nil {
+		return false, d.errorf(node, "expected true or false")
+	}
+	return b, nil
+}
+
+// strs decodes a list of strings; a single string is accepted as a list of
+// one.
+func (d *configDecoder) strs(node *yaml.Node) ([]string, error) {
+	if node.Kind == yaml.ScalarNode {
+		s, err := d.str(node)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, d.errorf(node, "expected a string or a list of strings")
+	}
+	values := make([]string, 0, len(node.Content))
+	for _, item := range node.Content {
+		s, err := d.str(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// strMap decodes a mapping of strings to strings.
+func (d *configDecoder) strMap(node *yaml.Node) (map[string]string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, d.errorf(node, "expected a mapping")
+	}
+	values := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, err := d.str(node.Content[i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.str(node.Content[i+1])
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// protocSource decodes a protoc source: a URL, or a path resolved against
+// the configuration directory.
+func (d *configDecoder) protocSource(node *yaml.Node) (string, error) {
+	s, err := d.str(node)
+	if err != nil || s == "" || strings.Contains(s, "://") {
+		return s, err
+	}
+	return d.resolve(s), nil
+}
+
+// backend decodes a backend name.
+func (d *configDecoder) backend(node *yaml.Node) (Backend, error) {
+	name, err := d.str(node)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := ParseBackend(name)
+	if err != nil {
+		return nil, d.errorf(node, "unknown backend %q, expected protoc or go", name)
+	}
+	return backend, nil
+}
+
+// strategy decodes a plugin strategy.
+func (d *configDecoder) strategy(node *yaml.Node) (Strategy, error) {
+	s, err := d.str(node)
+	if err != nil {
+		return "", err
+	}
+	switch strategy := Strategy(s); strategy {
+	case StrategyAll, StrategyDirectory:
+		return strategy, nil
+	}
+	return "", d.errorf(node, "unknown strategy %q, expected \"all\" or \"directory\"", s)
+}
+
+// path decodes a path and resolves it against the configuration directory.
+func (d *configDecoder) path(node *yaml.Node) (string, error) {
+	s, err := d.str(node)
+	if err != nil || s == "" {
+		return s, err
+	}
+	return d.resolve(s), nil
+}
+
+// paths decodes a list of paths.
+func (d *configDecoder) paths(node *yaml.Node) ([]string, error) {
+	values, err := d.strs(node)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		values[i] = d.resolve(v)
+	}
+	return values, nil
+}
+
+func (d *configDecoder) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(d.dir, filepath.FromSlash(path))
+}
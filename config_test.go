@@ -0,0 +1,230 @@
+package protoc_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, filepath.Join(tmpDir, "proto"), map[string]string{
+		"api/api.proto":             `syntax = "proto3"; package api; import "model/user.proto";`,
+		"api/internal/hidden.proto": `syntax = "proto3"; package api.internal;`,
+		"model/user.proto":          `syntax = "proto3"; package model; option go_package = "example.com/gen/model";`,
+		"admin/admin.proto":         `syntax = "proto3"; package admin; option go_package = "example.com/gen/admin"; import "model/user.proto";`,
+	})
+
+	path := writeConfig(t, tmpDir, protoc.DefaultConfigFile, `version: v1
+workspace: proto
+excludes: ["api/internal"]
+output: gen
+go_package_prefix: example.com/gen
+plugins:
+  - name: go
+    opt: paths=source_relative
+  - name: go-grpc
+    out: gen/grpc
+    opt: [paths=source_relative, require_unimplemented_servers=false]
+targets:
+  - name: api
+    proto_dir: proto/api
+  - name: admin
+    roots: [admin/admin.proto]
+    output: gen/admin
+    plugins:
+      - name: go
+`)
+
+	cfg, err := protoc.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(cfg.Targets) != 2 || cfg.Targets[0].Name != "api" || cfg.Targets[1].Name != "admin" {
+		t.Fatalf("unexpected targets: %+v", cfg.Targets)
+	}
+	if _, err := cfg.Compiler(); err == nil {
+		t.Error("Compiler should fail for a configuration with several targets")
+	}
+
+	api, ok := cfg.Target("api")
+	if !ok {
+		t.Fatal("target api not found")
+	}
+	graph, err := api.Compiler.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if want := []string{"api/api.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("api files = %v, want %v (excludes not applied?)", graph.Files, want)
+	}
+	plan, err := api.Compiler.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if args := strings.Join(plan.Commands[0].Args, " "); !strings.Contains(args, "Mapi/api.proto=example.com/gen/api") {
+		t.Errorf("go_package_prefix not applied: %s", args)
+	}
+
+	admin, _ := cfg.Target("admin")
+	graph, err = admin.Compiler.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if want := []string{"admin/admin.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("admin files = %v, want %v", graph.Files, want)
+	}
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping compilation")
+	}
+	if _, err := exec.LookPath("protoc-gen-go"); err != nil {
+		t.Skip("protoc-gen-go not available, skipping compilation")
+	}
+	if _, err := admin.Compiler.Compile(); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	for _, name := range []string{"admin/admin.pb.go", "model/user.pb.go"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, "gen", "admin", filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected %s to be generated: %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, filepath.Join(tmpDir, "proto"), map[string]string{
+		"api/api.proto": `syntax = "proto3"; package api;`,
+	})
+
+	path := writeConfig(t, tmpDir, "protoc-go.json", `{
+  "workspace": "proto",
+  "proto_dir": "proto/api",
+  "output": "gen",
+  "protoc_version": 28.3
+}`)
+
+	cfg, err := protoc.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	compiler, err := cfg.Compiler()
+	if err != nil {
+		t.Fatalf("Compiler failed: %v", err)
+	}
+	if cfg.Targets[0].Name != "default" {
+		t.Errorf("single target should be named default, got %q", cfg.Targets[0].Name)
+	}
+	if _, err := compiler.Graph(context.Background()); err != nil {
+		t.Errorf("Graph failed: %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unknown field",
+			content: "workspace: proto\nproto_dir: proto\noutput: gen\noutptu: gen\n",
+			want:    `:4:1: unknown field "outptu" in configuration`,
+		},
+		{
+			name:    "wrong type",
+			content: "workspace: proto\nproto_dir: proto\noutput: gen\nplugins: go\n",
+			want:    ":4:10: plugins must be a list",
+		},
+		{
+			name:    "missing workspace",
+			content: "proto_dir: proto\noutput: gen\n",
+			want:    `:1:1: target "default" is missing required field "workspace"`,
+		},
+		{
+			name:    "missing output",
+			content: "workspace: proto\nproto_dir: proto\nplugins:\n  - name: go\n    out: gen\n  - name: go-grpc\n",
+			want:    `:6:5: plugin "go-grpc" needs "out"`,
+		},
+		{
+			name:    "plugin without name",
+			content: "workspace: proto\nproto_dir: proto\noutput: gen\nplugins:\n  - out: gen\n",
+			want:    `:5:5: plugin is missing required field "name"`,
+		},
+		{
+			name:    "duplicate target",
+			content: "workspace: proto\noutput: gen\ntargets:\n  - name: a\n    proto_dir: a\n  - name: a\n    proto_dir: b\n",
+			want:    `:6:5: duplicate target name "a"`,
+		},
+		{
+			name:    "target without sources",
+			content: "workspace: proto\noutput: gen\ntargets:\n  - name: a\n",
+			want:    `:4:5: target "a" needs "proto_dir" or "roots"`,
+		},
+		{
+			name:    "unknown dependency",
+			content: "workspace: proto\noutput: gen\ntargets:\n  - name: a\n    proto_dir: a\n    depends_on: [b]\n",
+			want:    `:6:17: target "a" depends on unknown target "b"`,
+		},
+		{
+			name:    "dependency cycle",
+			content: "workspace: proto\noutput: gen\ntargets:\n  - name: a\n    proto_dir: a\n    depends_on: [b]\n  - name: b\n    proto_dir: b\n    depends_on: a\n",
+			want:    `:6:17: target dependency cycle: a -> b -> a`,
+		},
+		{
+			name:    "dependency cycle order",
+			content: "workspace: proto\noutput: gen\ntargets:\n  - name: a\n    proto_dir: a\n    depends_on: [c]\n  - name: b\n    proto_dir: b\n    depends_on: [a]\n  - name: c\n    proto_dir: c\n    depends_on: [b]\n",
+			want:    `:6:17: target dependency cycle: a -> c -> b -> a`,
+		},
+		{
+			name:    "unknown lint rule",
+			content: "workspace: proto\nproto_dir: proto\noutput: gen\nlint:\n  except: [SERVICE_SUFIX]\n",
+			want:    `:5:11: unknown lint rule "SERVICE_SUFIX"`,
+		},
+		{
+			name:    "unknown backend",
+			content: "workspace: proto\nproto_dir: proto\noutput: gen\nbackend: buf\n",
+			want:    `:4:10: unknown backend "buf", expected protoc or go`,
+		},
+		{
+			name:    "bad version",
+			content: "version: v2\n",
+			want:    `:1:10: unsupported version "v2"`,
+		},
+		{
+			name:    "syntax error",
+			content: "workspace: proto\noutput: gen\n  proto_dir: proto\n",
+			want:    ":3: mapping values are not allowed in this context",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, t.TempDir(), protoc.DefaultConfigFile, tt.content)
+			_, err := protoc.LoadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got: %v", tt.want, err)
+			}
+			var cfgErr *protoc.ConfigError
+			if !errors.As(err, &cfgErr) || cfgErr.Line == 0 {
+				t.Errorf("expected *ConfigError with a line number, got %T", err)
+			}
+		})
+	}
+}
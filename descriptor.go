@@ -0,0 +1,141 @@
+package protoc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// descriptorSet compiles files with the backend and returns their
+// descriptors, with source locations. With includeImports the set also
+// contains every imported file, dependencies first.
+func (c *compilerImpl) descriptorSet(files []string, includeImports bool) (*descriptorpb.FileDescriptorSet, error) {
+	names, err := c.importNames(files)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setupWellKnownTypes(); err != nil {
+		return nil, err
+	}
+	backend := c.backend
+	switch backend.(type) {
+	case nil, ProtocBackend, *ProtocBackend:
+		// The binary set on the backend, or protoc from PATH or the
+		// protoc source
+		backend = ProtocBackend{Path: c.protocExecutable()}
+	}
+	set, err := backend.Descriptors(c.ctx, c.includeDirs(), names)
+	if err != nil || includeImports {
+		return set, err
+	}
+
+	compiled := make(map[string]bool)
+	for _, name := range names {
+		compiled[name] = true
+	}
+	var own []*descriptorpb.FileDescriptorProto
+	for _, file := range set.GetFile() {
+		if compiled[file.GetName()] {
+			own = append(own, file)
+		}
+	}
+	set.File = own
+	return set, nil
+}
+
+// readDescriptorSet reads a binary FileDescriptorSet, as written by
+// protoc --descriptor_set_out.
+func readDescriptorSet(path string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read descriptor set: %w", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("parse descriptor set %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// sourceLocations maps descriptor paths of a file to their source spans.
+type sourceLocations map[string][]int32
+
+// locations indexes the source code info of a file.
+func locations(file *descriptorpb.FileDescriptorProto) sourceLocations {
+	locs := make(sourceLocations)
+	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+		key := pathKey(loc.GetPath())
+		if _, ok := locs[key]; !ok {
+			locs[key] = loc.GetSpan()
+		}
+	}
+	return locs
+}
+
+// position returns the 1-based line and column of the element at path, or
+// zeros if the file has no source info for it.
+func (l sourceLocations) position(path []int32) (int, int) {
+	if span, ok := l[pathKey(path)]; ok && len(span) >= 2 {
+		return int(span[0]) + 1, int(span[1]) + 1
+	}
+	return 0, 0
+}
+
+// sourceComments maps descriptor paths of a file to the comments of the
+// element: its leading comments, or its trailing ones if it has none.
+func sourceComments(file *descriptorpb.FileDescriptorProto) map[string]string {
+	comments := make(map[string]string)
+	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+		text := loc.GetLeadingComments()
+		if strings.TrimSpace(text) == "" {
+			text = loc.GetTrailingComments()
+		}
+		key := pathKey(loc.GetPath())
+		if _, ok := comments[key]; !ok && strings.TrimSpace(text) != "" {
+			comments[key] = cleanComment(text)
+		}
+	}
+	return comments
+}
+
+// cleanComment removes the space protoc keeps after the comment markers
+// and the surrounding blank lines.
+func cleanComment(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimPrefix(line, " "), " \t\r")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+func pathKey(path []int32) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = strconv.Itoa(int(p))
+	}
+	return strings.Join(parts, ".")
+}
+
+// Field numbers of descriptor.proto used in source code info paths.
+const (
+	fileDescriptorPackage     = 2
+	fileDescriptorMessageType = 4
+	fileDescriptorEnumType    = 5
+	fileDescriptorService     = 6
+	messageDescriptorField    = 2
+	messageDescriptorNested   = 3
+	messageDescriptorEnumType = 4
+	enumDescriptorValue       = 2
+	serviceDescriptorMethod   = 2
+	descriptorName            = 1
+)
+
+// appendPath returns a copy of path extended with elems, so paths of
+// siblings never share a backing array.
+func appendPath(path []int32, elems ...int32) []int32 {
+	return append(append(make([]int32, 0, len(path)+len(elems)), path...), elems...)
+}
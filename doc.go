@@ -74,18 +74,74 @@
 //	func NewCompiler() *Compiler
 //	func (c *Compiler) WithProtoDir(dir string) *Compiler
 //	func (c *Compiler) WithProtoWorkSpace(dir string) *Compiler
+//	func (c *Compiler) WithProtoFS(fsys fs.FS, root string) *Compiler
+//	func Dir(dir string) DirFS
+//	func (c *Compiler) WithImportPaths(dirs ...string) *Compiler
+//	func (c *Compiler) WithRoots(files ...string) *Compiler
+//	func (c *Compiler) WithExcludes(patterns ...string) *Compiler
 //	func (c *Compiler) WithOutputDir(dir string) *Compiler
+//	func (c *Compiler) WithPluginOutputDir(plugin, dir string) *Compiler
 //	func (c *Compiler) WithPlugins(plugins ...string) *Compiler
 //	func (c *Compiler) WithGoOpts(opts ...string) *Compiler
 //	func (c *Compiler) WithGoGrpcOpts(opts ...string) *Compiler
+//	func (c *Compiler) WithGoPackageMapping(mapping map[string]string) *Compiler
+//	func (c *Compiler) WithGoPackagePrefix(modulePath string) *Compiler
+//	func (c *Compiler) WithGoPackageValidation(enabled bool) *Compiler
+//	func (c *Compiler) WithGoFormat(enabled bool) *Compiler
+//	func (c *Compiler) WithFixImports(enabled bool) *Compiler
+//	func (c *Compiler) WithHeader(pattern, header string) *Compiler
+//	func (c *Compiler) WithBuildTags(pattern, expr string) *Compiler
+//	func (c *Compiler) WithPostProcessor(p PostProcessor) *Compiler
+//	func (c *Compiler) WithDescriptorPackage(dir, name string) *Compiler
+//	func (c *Compiler) WithPluginOpts(plugin string, opts ...string) *Compiler
+//	func (c *Compiler) WithPluginPath(plugin, path string) *Compiler
+//	func (c *Compiler) WithPluginStrategy(plugin string, strategy Strategy) *Compiler
+//	func (c *Compiler) WithGenerator(plugin string, gen GeneratorFunc) *Compiler
+//	func (c *Compiler) WithBackend(backend Backend) *Compiler
+//	func (c *Compiler) WithProtocVersion(version string) *Compiler
+//	func (c *Compiler) WithProtocSource(source string) *Compiler
+//	func (c *Compiler) WithProtocCacheDir(dir string) *Compiler
+//	func (c *Compiler) WithWellKnownTypes(enabled bool) *Compiler
 //	func (c *Compiler) WithVerbose(verbose bool) *Compiler
 //	func (c *Compiler) WithContext(ctx context.Context) *Compiler
+//	func (c *Compiler) WithPollInterval(d time.Duration) *Compiler
+//	func (c *Compiler) WithDebounce(d time.Duration) *Compiler
+//	func (c *Compiler) WithLintRules(rules ...string) *Compiler
+//	func (c *Compiler) WithLintExcept(rules ...string) *Compiler
+//	func (c *Compiler) WithBreakingLevel(level BreakingLevel) *Compiler
 //	func (c *Compiler) Compile() (string, error)
+//	func (c *Compiler) Graph(ctx context.Context) (*Graph, error)
+//	func (c *Compiler) Files(ctx context.Context) ([]string, error)
+//	func (c *Compiler) Plan(ctx context.Context) (*Plan, error)
+//	func (c *Compiler) Check(ctx context.Context) error
+//	func (c *Compiler) Clean(ctx context.Context) ([]string, error)
+//	func (c *Compiler) Watch(ctx context.Context, onResult func(Result)) error
+//	func (c *Compiler) Doctor(ctx context.Context) *DoctorReport
+//	func (c *Compiler) Lint(ctx context.Context) ([]Diagnostic, error)
+//	func (c *Compiler) Breaking(ctx context.Context, baseline string) ([]Diagnostic, error)
 //
 // ## Simple Functions
 //
 //	func Compile(protoDir, workspaceDir, outputDir string) (string, error)
 //	func MustCompile(protoDir, workspaceDir, outputDir string) string
+//	func TemplateGenerator(dir string) GeneratorFunc
+//
+// ## Configuration Files
+//
+//	func LoadConfig(path string) (*Config, error)
+//	func (cfg *Config) Compiler() (*Compiler, error)
+//	func (cfg *Config) Target(name string) (*Target, bool)
+//	func (cfg *Config) Project() *Project
+//	func LoadBufConfig(genPath string) (*Config, error)
+//
+// ## Projects
+//
+//	func NewProject() *Project
+//	func (p *Project) AddTarget(name string, compiler *Compiler, dependsOn ...string) *Project
+//	func (p *Project) WithParallelism(n int) *Project
+//	func (p *Project) Compiler(name string) (*Compiler, bool)
+//	func (p *Project) Order(names ...string) ([]string, error)
+//	func (p *Project) Build(ctx context.Context, names ...string) (*ProjectResult, error)
 //
 // # Examples
 //
@@ -120,6 +176,52 @@
 //
 //	output, err := compiler.Compile()
 //
+// Files without a go_package option, common in third-party protos, get
+// their Go import path from WithGoPackageMapping or, with
+// WithGoPackagePrefix, from their location below the include path; both
+// are passed to the go and go-grpc plugins as M options. With
+// WithGoPackageValidation(true), the go_package of each file is checked
+// against the output directory and the paths or module option before
+// protoc runs, reporting files generated into the wrong Go package and
+// colliding packages.
+//
+// WithGenerator implements a plugin with a Go function, called in-process
+// with the CodeGeneratorRequest that protoc would send to a plugin binary,
+// so small generators need no protoc-gen-* executable. The built-in
+// template plugin, or TemplateGenerator, renders text/template files over
+// the compiled descriptors, with templated output paths:
+//
+//	compiler := protoc.NewCompiler().
+//	    WithPlugins("go", "template").
+//	    WithPluginOpts("template", "dir=templates")
+//
+// WithBackend(GoBackend{}) compiles the files in Go instead of running
+// protoc, and runs the plugins by sending them a CodeGeneratorRequest, so
+// builds need no protoc installation.
+//
+// WithProtocSource names a directory, or file:// URL, of protoc release
+// archives. When protoc is not in PATH, or is not the version pinned with
+// WithProtocVersion, the newest archive for the platform matching
+// WithProtocVersion is verified against its SHA-256 checksum and
+// extracted, with the well-known types, into a cache directory once.
+// WithWellKnownTypes supplies the well-known types from a copy embedded in
+// the package when protoc is installed without them.
+//
+// WithGoFormat runs go/format on the .go files a successful run generated,
+// and WithFixImports also removes unused imports and sorts the rest. Files
+// that cannot be formatted are reported in the error of Compile.
+//
+// WithHeader prepends a license header template to the generated files
+// matching a pattern, and WithBuildTags adds a //go:build constraint to
+// matching Go files. Neither is duplicated when a file is processed again.
+// Other rewrites implement PostProcessor and are added with
+// WithPostProcessor; they run, in parallel across files, before the
+// headers and formatting.
+//
+// WithDescriptorPackage writes a Go package embedding the compiled .proto
+// files and their descriptor set, with a Files function returning them as
+// a *protoregistry.Files, so a program can serve its own schemas.
+//
 // ## Using Context for Timeout
 //
 //	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -133,6 +235,118 @@
 //
 //	output, err := compiler.Compile()
 //
+// ## Using a Configuration File
+//
+// LoadConfig reads a YAML or JSON file (conventionally protoc-go.yaml)
+// describing one or more targets, so the builder chain does not have to be
+// written by hand:
+//
+//	cfg, err := protoc.LoadConfig("protoc-go.yaml")
+//	if err != nil {
+//	    log.Fatal(err) // e.g. protoc-go.yaml:12:3: unknown field "outptu" in target
+//	}
+//	for _, target := range cfg.Targets {
+//	    if _, err := target.Compiler.Compile(); err != nil {
+//	        log.Fatalf("%s: %v", target.Name, err)
+//	    }
+//	}
+//
+// See Config for the file format. LoadBufConfig reads an existing
+// buf.gen.yaml, together with the buf.yaml or buf.work.yaml next to it,
+// into the same Config.
+//
+// ## Watching for Changes
+//
+// Watch compiles once and then recompiles the changed files and their
+// importers whenever the sources change, until ctx is cancelled:
+//
+//	err := compiler.Watch(ctx, func(r protoc.Result) {
+//	    if r.Err != nil {
+//	        log.Printf("compile failed: %v", r.Err)
+//	    }
+//	})
+//
+// ## Building Several Targets
+//
+// A Project builds named targets in dependency order, in parallel where
+// they are independent, and reports the outcome of each:
+//
+//	result, err := protoc.NewProject().
+//	    AddTarget("model", modelCompiler).
+//	    AddTarget("api", apiCompiler, "model").
+//	    Build(ctx)
+//	fmt.Print(result)
+//
+// ## Build Reports
+//
+// CompileWithResult, and ProjectResult.Results for a Project, return the
+// commands run, the status of every file and the diagnostics protoc
+// reported. WriteReport writes them as JSON, JUnit XML or SARIF for CI
+// systems:
+//
+//	result, err := compiler.CompileWithResult(ctx)
+//	protoc.WriteReport(f, protoc.ReportJUnit, result)
+//
+// WriteSARIF writes diagnostics, such as the findings of Lint, as a SARIF
+// 2.1.0 log for code scanning tools, with rule metadata and locations
+// relative to the workspace; ReportSARIF does the same for compile results.
+//
+// ## Compiling From Entry Points
+//
+// WithRoots compiles a few entry point files plus everything they import
+// from within the workspace, instead of every file in the proto directory:
+//
+//	compiler := protoc.NewCompiler().
+//	    WithProtoWorkSpace("./proto").
+//	    WithOutputDir("./generated").
+//	    WithRoots("api/user_service.proto", "api/order_service.proto")
+//
+//	output, err := compiler.Compile()
+//
+// ## Reading Sources From a File System
+//
+// WithProtoFS reads the files from an fs.FS, such as an embed.FS, whose
+// root is the workspace. Unless it is a DirFS, such as one from Dir, it is
+// copied to a temporary workspace for protoc, removed when the operation
+// returns:
+//
+//	//go:embed proto
+//	var protos embed.FS
+//
+//	sub, _ := fs.Sub(protos, "proto")
+//	compiler := protoc.NewCompiler().
+//	    WithProtoFS(sub, "api").
+//	    WithOutputDir("./generated")
+//
+// ## Analyzing Imports
+//
+// Graph parses the import statements of the discovered files without
+// running protoc or any plugin:
+//
+//	graph, err := protoc.NewCompiler().
+//	    WithProtoDir("./proto/act7110").
+//	    WithProtoWorkSpace("./proto").
+//	    WithImportPaths("./third_party").
+//	    Graph(ctx)
+//
+//	for _, cycle := range graph.Cycles {
+//	    fmt.Println("import cycle:", cycle)
+//	}
+//	for _, imp := range graph.Unresolved {
+//	    fmt.Printf("%s:%d: cannot resolve %s\n", imp.File, imp.Line, imp.Import)
+//	}
+//	order, err := graph.TopologicalOrder()
+//
+// The graph can be exported for documentation and review in Graphviz DOT,
+// Mermaid or JSON format, optionally collapsed by proto package:
+//
+//	opts := protoc.ExportOptions{
+//	    CollapsePackages:  true,
+//	    HighlightCycles:   true,
+//	    HighlightExternal: true,
+//	}
+//	err = graph.WriteDOT(os.Stdout, opts)
+//
 // # Error Handling
 //
 // The package returns descriptive error messages for common issues:
@@ -144,8 +358,41 @@
 //   - "workspace directory does not exist"
 //   - "proto directory must be within workspace directory"
 //   - "no .proto files found in [directory]"
+//   - "protoc version [version] does not match required version [version]"
 //   - "protoc execution failed: [error]"
 //
+// ## Diagnosing the Toolchain
+//
+// Doctor reports protoc and plugin versions, PATH and include path
+// problems, unwritable output directories and known version
+// incompatibilities:
+//
+//	report := compiler.Doctor(ctx)
+//	report.WriteText(os.Stdout)
+//
+// ## Linting
+//
+// Lint checks package and go_package placement, enum zero values and
+// field, service and RPC naming without running protoc. Findings use the
+// file:line:column layout of protoc errors and can be silenced with a
+// "protoc-go:lint:ignore RULE" comment:
+//
+//	diags, err := compiler.WithLintExcept(protoc.LintServiceSuffix).Lint(ctx)
+//
+// ## Detecting Breaking Changes
+//
+// Breaking compares the files with a baseline descriptor set or an older
+// copy of the workspace, such as a git worktree, at the WIRE, WIRE_JSON or
+// FILE level:
+//
+//	diags, err := compiler.WithBreakingLevel(protoc.BreakingWire).Breaking(ctx, "/tmp/main")
+//
+// # Command-Line Tool
+//
+// The cmd/protoc-go command runs the compile, check, clean, list, plan,
+// doctor, lint and breaking operations from the shell, reading targets from a configuration file or
+// flags, with a -json output mode for scripts and -report for build reports.
+//
 // # Notes
 //
 //   - This package is particularly useful on Windows where protoc doesn't support
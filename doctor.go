@@ -0,0 +1,477 @@
+package protoc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DoctorStatus is the outcome of a single Doctor check.
+type DoctorStatus string
+
+const (
+	// DoctorOK means the check passed.
+	DoctorOK DoctorStatus = "ok"
+	// DoctorWarning means the setup works but may cause problems.
+	DoctorWarning DoctorStatus = "warning"
+	// DoctorError means compilation will fail until the problem is fixed.
+	DoctorError DoctorStatus = "error"
+)
+
+// DoctorCheck is a single finding of Doctor.
+type DoctorCheck struct {
+	Name    string       `json:"name"`
+	Status  DoctorStatus `json:"status"`
+	Message string       `json:"message"`
+	Hint    string       `json:"hint,omitempty"`
+}
+
+// DoctorReport is the result of Doctor.
+type DoctorReport struct {
+	Checks []DoctorCheck `json:"checks"`
+}
+
+// OK reports whether no check failed with DoctorError.
+func (r *DoctorReport) OK() bool {
+	for _, check := range r.Checks {
+		if check.Status == DoctorError {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes the report with one line per check, followed by the
+// hint when there is one.
+func (r *DoctorReport) WriteText(w io.Writer) error {
+	width := 0
+	for _, check := range r.Checks {
+		if len(check.Name) > width {
+			width = len(check.Name)
+		}
+	}
+
+	var sb strings.Builder
+	for _, check := range r.Checks {
+		mark := "✓"
+		switch check.Status {
+		case DoctorWarning:
+			mark = "!"
+		case DoctorError:
+			mark = "✗"
+		}
+		fmt.Fprintf(&sb, "%s %-*s  %s\n", mark, width, check.Name, check.Message)
+		for _, line := range strings.Split(check.Hint, "\n") {
+			if line != "" {
+				fmt.Fprintf(&sb, "  %-*s  %s\n", width, "", line)
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteJSON writes the report as an indented JSON document with "ok" and
+// "checks" members.
+func (r *DoctorReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		OK     bool          `json:"ok"`
+		Checks []DoctorCheck `json:"checks"`
+	}{r.OK(), r.Checks})
+}
+
+// builtinPlugins are the generators compiled into protoc itself.
+var builtinPlugins = map[string]bool{
+	"cpp": true, "csharp": true, "java": true, "kotlin": true, "objc": true,
+	"php": true, "pyi": true, "python": true, "ruby": true, "rust": true,
+}
+
+// pluginInstallHints are the install commands of well-known plugins.
+var pluginInstallHints = map[string]string{
+	"go":      "go install google.golang.org/protobuf/cmd/protoc-gen-go@latest",
+	"go-grpc": "go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@latest",
+}
+
+// versionPattern finds a version number in --version output.
+var versionPattern = regexp.MustCompile(`v?(\d+\.\d+(?:\.\d+)?(?:-[0-9A-Za-z.]+)?)`)
+
+// Doctor diagnoses the toolchain this compiler needs: protoc and its
+// version, each plugin and its version, whether GOPATH/bin is on PATH, the
+// include paths, write access to the output directories and known
+// incompatibilities between tool versions and options. It never fails;
+// problems are reported as checks with DoctorWarning or DoctorError. It
+// installs nothing: a protoc still to be installed from the protoc source
+// is reported as not installed.
+func (c *Compiler) Doctor(ctx context.Context) *DoctorReport {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	if cleanup, err := impl.openProtoFS(); err == nil {
+		defer cleanup()
+	}
+
+	d := &doctor{c: impl, versions: make(map[string]string)}
+	d.checkProtoc()
+	for _, plugin := range impl.plugins {
+		d.checkPlugin(plugin)
+	}
+	d.checkGoBin()
+	d.checkIncludes()
+	d.checkOutputs()
+	d.checkCompatibility()
+	return &DoctorReport{Checks: d.checks}
+}
+
+// doctor collects the checks of a Doctor run.
+type doctor struct {
+	c        *compilerImpl
+	checks   []DoctorCheck
+	versions map[string]string // tool versions found, by "protoc" or plugin name
+}
+
+func (d *doctor) add(name string, status DoctorStatus, hint, format string, args ...interface{}) {
+	d.checks = append(d.checks, DoctorCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// checkProtoc reports the protoc path and version.
+func (d *doctor) checkProtoc() {
+	if !d.c.usesProtoc() {
+		d.add("protoc", DoctorOK, "", "not needed by the backend")
+		return
+	}
+	// Doctor only looks: a protoc from the protoc source is installed by
+	// the first compilation, not here
+	_, explicit := d.c.backendProtoc()
+	installs := d.c.protocSource != "" && !explicit
+	installHint := fmt.Sprintf("it is installed from %s on the first compilation", d.c.protocSource)
+	path, err := exec.LookPath(d.c.protocExecutable())
+	if err != nil {
+		if installs {
+			d.add("protoc", DoctorWarning, installHint, "not installed")
+			return
+		}
+		d.add("protoc", DoctorError, strings.TrimSpace(protocInstallHint()), "not found in PATH")
+		return
+	}
+
+	version, err := protocVersion(d.c.ctx, path)
+	if err != nil {
+		d.add("protoc", DoctorError, "", "%s: %v", path, err)
+		return
+	}
+	d.versions["protoc"] = version
+
+	if d.c.protocVer != "" && !versionMatches(version, d.c.protocVer) {
+		if installs {
+			d.add("protoc", DoctorWarning, installHint, "%s is not installed, %s has version %s", d.c.protocVer, path, version)
+			return
+		}
+		d.add("protoc", DoctorError, "", "%s has version %s, but %s is required", path, version, d.c.protocVer)
+		return
+	}
+	d.add("protoc", DoctorOK, "", "%s (%s)", path, version)
+}
+
+// checkPlugin reports whether a plugin can be found and its version.
+func (d *doctor) checkPlugin(plugin string) {
+	name := "plugin " + plugin
+	if _, ok := d.c.generator(plugin); ok {
+		d.add(name, DoctorOK, "", "runs in-process")
+		return
+	}
+	if builtinPlugins[plugin] {
+		if _, ok := d.c.pluginPaths[plugin]; !ok && !d.c.usesProtoc() {
+			d.add(name, DoctorError, "use the protoc backend", "built into protoc, which the backend does not use")
+			return
+		}
+		if _, ok := d.c.pluginPaths[plugin]; !ok {
+			d.add(name, DoctorOK, "", "built into protoc")
+			return
+		}
+	}
+
+	path, ok := d.c.pluginPaths[plugin]
+	if ok && path != "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			d.add(name, DoctorError, "", "%s: %v", path, err)
+			return
+		}
+		if info.IsDir() {
+			d.add(name, DoctorError, "", "%s is a directory", path)
+			return
+		}
+	} else {
+		executable := "protoc-gen-" + plugin
+		var err error
+		if path, err = exec.LookPath(executable); err != nil {
+			hint := pluginInstallHints[plugin]
+			if gobin := goBinDir(); gobin != "" && !onPath(gobin) {
+				if _, err := exec.LookPath(filepath.Join(gobin, executable)); err == nil {
+					hint = fmt.Sprintf("%s is installed in %s, which is not in PATH", executable, gobin)
+				}
+			}
+			d.add(name, DoctorError, hint, "%s not found in PATH", executable)
+			return
+		}
+	}
+
+	version := pluginVersion(d.c.ctx, path)
+	if version == "" {
+		d.add(name, DoctorOK, "", "%s (version unknown)", path)
+		return
+	}
+	d.versions[plugin] = version
+	d.add(name, DoctorOK, "", "%s (%s)", path, version)
+}
+
+// pluginVersion runs "<plugin> --version" and returns the version it
+// prints, or "" if it prints none. Plugins that do not support the flag
+// read an empty request from stdin and exit.
+func pluginVersion(ctx context.Context, path string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if m := versionPattern.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// checkGoBin reports whether the directory "go install" writes to is on
+// PATH, which Go plugins need to be found by protoc.
+func (d *doctor) checkGoBin() {
+	gobin := goBinDir()
+	if gobin == "" {
+		d.add("GOPATH/bin", DoctorWarning, "", "cannot determine the Go binary directory")
+		return
+	}
+	if !onPath(gobin) {
+		d.add("GOPATH/bin", DoctorWarning,
+			fmt.Sprintf("add %s to PATH so plugins installed with \"go install\" are found", gobin),
+			"%s is not in PATH", gobin)
+		return
+	}
+	d.add("GOPATH/bin", DoctorOK, "", "%s is in PATH", gobin)
+}
+
+// goBinDir returns the directory "go install" writes binaries to.
+func goBinDir() string {
+	if gobin := os.Getenv("GOBIN"); gobin != "" {
+		return gobin
+	}
+	gopath := build.Default.GOPATH
+	if list := filepath.SplitList(gopath); len(list) > 0 && list[0] != "" {
+		return filepath.Join(list[0], "bin")
+	}
+	return ""
+}
+
+// onPath reports whether dir is one of the PATH entries.
+func onPath(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, entry := range filepath.SplitList(os.Getenv("PATH")) {
+		if entry != "" && filepath.Clean(entry) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIncludes reports problems with the workspace, the import paths and
+// the well-known types shipped with protoc.
+func (d *doctor) checkIncludes() {
+	dirs := append([]string{d.c.workspaceDir}, d.c.importPaths...)
+	for i, dir := range dirs {
+		name := "include " + dir
+		if i == 0 {
+			name = "workspace"
+			if dir == "" {
+				d.add(name, DoctorError, "", "workspace directory not specified")
+				continue
+			}
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			d.add(name, DoctorError, "", "%s does not exist", dir)
+			continue
+		}
+		if !info.IsDir() {
+			d.add(name, DoctorError, "", "%s is not a directory", dir)
+			continue
+		}
+		if i > 0 && d.c.workspaceDir != "" {
+			if nested, parent := nestedDirs(d.c.workspaceDir, dir); nested {
+				d.add(name, DoctorWarning,
+					"files below both directories get two import names, which causes \"already defined\" errors",
+					"%s overlaps with the workspace %s", dir, parent)
+				continue
+			}
+		}
+		d.add(name, DoctorOK, "", "%s", dir)
+	}
+
+	protoc, err := exec.LookPath(d.c.protocExecutable())
+	if err != nil {
+		return
+	}
+	wkt := protocIncludeDirs(protoc)
+	if len(wkt) == 0 && d.c.wellKnownTypes {
+		d.add("well-known types", DoctorOK, "", "bundled copy, unless the workspace or an import path has them")
+		return
+	}
+	if len(wkt) == 0 {
+		d.add("well-known types", DoctorWarning,
+			"install the include directory shipped with protoc next to its bin directory, add it with WithImportPaths, or use WithWellKnownTypes",
+			"google/protobuf/*.proto not found next to protoc")
+		return
+	}
+	d.add("well-known types", DoctorOK, "", "%s", strings.Join(wkt, ", "))
+}
+
+// nestedDirs reports whether one of the directories contains the other,
+// and returns the containing one.
+func nestedDirs(a, b string) (bool, string) {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil || absA == absB {
+		return false, ""
+	}
+	if belowAny(absB, []string{absA}) {
+		return true, a
+	}
+	if belowAny(absA, []string{absB}) {
+		return true, b
+	}
+	return false, ""
+}
+
+// checkOutputs reports whether the output directories can be written.
+func (d *doctor) checkOutputs() {
+	if d.c.outputDir == "" && len(d.c.pluginOut) == 0 {
+		d.add("output", DoctorWarning, "", "output directory not specified")
+		return
+	}
+
+	for _, dir := range d.c.outputDirs() {
+		if dir == "" {
+			continue
+		}
+		name := "output " + dir
+
+		// Compile creates missing output directories, so check the
+		// nearest existing parent instead.
+		existing := dir
+		for {
+			if _, err := os.Stat(existing); err == nil {
+				break
+			}
+			parent := filepath.Dir(existing)
+			if parent == existing {
+				break
+			}
+			existing = parent
+		}
+
+		info, err := os.Stat(existing)
+		if err != nil || !info.IsDir() {
+			d.add(name, DoctorError, "", "%s is not a directory", existing)
+			continue
+		}
+		f, err := os.CreateTemp(existing, ".protoc-go-doctor-*")
+		if err != nil {
+			d.add(name, DoctorError, "", "%s is not writable: %v", existing, err)
+			continue
+		}
+		f.Close()
+		os.Remove(f.Name())
+
+		if existing != dir {
+			d.add(name, DoctorOK, "", "will be created in %s", existing)
+		} else {
+			d.add(name, DoctorOK, "", "writable")
+		}
+	}
+}
+
+// checkCompatibility reports tool versions and options known not to work
+// together.
+func (d *doctor) checkCompatibility() {
+	found := false
+
+	// proto3 optional fields need protoc 3.15; 3.12 to 3.14 only accept
+	// them behind an experimental flag.
+	if version, ok := d.versions["protoc"]; ok {
+		if release, ok := protocRelease(version); ok && release < 15 {
+			found = true
+			d.add("compatibility", DoctorWarning, "upgrade protoc to 3.15 or later",
+				"protoc %s does not support proto3 optional fields", version)
+		}
+	}
+
+	// protoc-gen-go from google.golang.org/protobuf (1.20 and later)
+	// removed the gRPC support of the legacy github.com/golang/protobuf
+	// generator.
+	for _, opt := range d.c.goOpts {
+		if strings.TrimSpace(opt) != "plugins=grpc" {
+			continue
+		}
+		if version, ok := d.versions["go"]; ok && versionAtLeast(version, 1, 20) {
+			found = true
+			d.add("compatibility", DoctorError, "remove plugins=grpc and add the go-grpc plugin",
+				"protoc-gen-go %s does not support the plugins=grpc option", version)
+		}
+	}
+
+	if !found {
+		d.add("compatibility", DoctorOK, "", "no known incompatibilities")
+	}
+}
+
+// protocRelease returns the release number of a protoc version, which is
+// the minor version for the 3.x series ("3.21.12" is release 21) and the
+// major version from 21 on ("28.3" is release 28).
+func protocRelease(version string) (int, bool) {
+	parts := strings.Split(version, ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	if major != 3 {
+		return major, true
+	}
+	if len(parts) < 2 {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	return minor, err == nil
+}
+
+// versionAtLeast reports whether version is major.minor or later.
+func versionAtLeast(version string, major, minor int) bool {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, err1 := strconv.Atoi(parts[0])
+	gotMinor, err2 := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
@@ -0,0 +1,132 @@
+package protoc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// writePlugin writes an executable plugin stub that prints version on
+// --version.
+func writePlugin(t *testing.T, dir, name, version string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin stubs are shell scripts")
+	}
+	path := filepath.Join(dir, name)
+	script := "#!/bin/sh\necho '" + version + "'\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func findCheck(report *protoc.DoctorReport, name string) (protoc.DoctorCheck, bool) {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+	return protoc.DoctorCheck{}, false
+}
+
+func TestDoctor(t *testing.T) {
+	tmpDir := t.TempDir()
+	bin := filepath.Join(tmpDir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	goPlugin := writePlugin(t, bin, "protoc-gen-go", "protoc-gen-go v1.34.2")
+	proto := filepath.Join(tmpDir, "proto")
+	if err := os.Mkdir(proto, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	report := protoc.NewCompiler().
+		WithProtoWorkSpace(proto).
+		WithImportPaths(filepath.Join(tmpDir, "missing"), filepath.Join(proto, "vendor")).
+		WithOutputDir(filepath.Join(tmpDir, "gen", "go")).
+		WithPlugins("go", "java", "lint").
+		WithPluginPath("go", goPlugin).
+		WithPluginPath("lint", filepath.Join(bin, "protoc-gen-lint")).
+		WithGoOpts("plugins=grpc").
+		Doctor(context.Background())
+
+	tests := []struct {
+		name   string
+		status protoc.DoctorStatus
+		want   string
+	}{
+		{"plugin go", protoc.DoctorOK, "(1.34.2)"},
+		{"plugin java", protoc.DoctorOK, "built into protoc"},
+		{"plugin lint", protoc.DoctorError, "protoc-gen-lint"},
+		{"workspace", protoc.DoctorOK, proto},
+		{"include " + filepath.Join(tmpDir, "missing"), protoc.DoctorError, "does not exist"},
+		{"output " + filepath.Join(tmpDir, "gen", "go"), protoc.DoctorOK, "will be created in " + tmpDir},
+		{"compatibility", protoc.DoctorError, "does not support the plugins=grpc option"},
+	}
+	for _, tt := range tests {
+		check, ok := findCheck(report, tt.name)
+		if !ok {
+			t.Errorf("check %q not reported", tt.name)
+			continue
+		}
+		if check.Status != tt.status || !strings.Contains(check.Message, tt.want) {
+			t.Errorf("%s: got %s %q, want %s containing %q", tt.name, check.Status, check.Message, tt.status, tt.want)
+		}
+	}
+	if report.OK() {
+		t.Error("report with errors should not be OK")
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "✗ plugin lint") {
+		t.Errorf("unexpected text report:\n%s", text.String())
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		OK     bool                 `json:"ok"`
+		Checks []protoc.DoctorCheck `json:"checks"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded.OK || len(decoded.Checks) != len(report.Checks) {
+		t.Errorf("unexpected JSON report: %s", buf.String())
+	}
+}
+
+func TestDoctorGoBin(t *testing.T) {
+	tmpDir := t.TempDir()
+	gobin := filepath.Join(tmpDir, "gobin")
+	if err := os.Mkdir(gobin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writePlugin(t, gobin, "protoc-gen-go", "protoc-gen-go v1.34.2")
+	t.Setenv("GOBIN", gobin)
+	t.Setenv("PATH", filepath.Join(tmpDir, "empty"))
+
+	report := protoc.NewCompiler().Doctor(context.Background())
+	check, _ := findCheck(report, "GOPATH/bin")
+	if check.Status != protoc.DoctorWarning || !strings.Contains(check.Message, "not in PATH") {
+		t.Errorf("GOPATH/bin: got %s %q", check.Status, check.Message)
+	}
+	check, _ = findCheck(report, "plugin go")
+	if check.Status != protoc.DoctorError || !strings.Contains(check.Hint, "installed in "+gobin) {
+		t.Errorf("plugin go: got %s %q, hint %q", check.Status, check.Message, check.Hint)
+	}
+}
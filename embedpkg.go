@@ -0,0 +1,170 @@
+package protoc
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	gotoken "go/token"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Names of the files WithDescriptorPackage writes to its directory.
+const (
+	descriptorPackageFile   = "descriptors.go"
+	descriptorPackageSet    = "descriptors.binpb"
+	descriptorPackageSource = "proto"
+)
+
+// WithDescriptorPackage writes, after a successful compilation, a Go
+// package to dir that lets a program serve its own schemas at runtime.
+// The package embeds the compiled .proto files below proto/ in its Sources
+// variable and their descriptor set, including imports and source info,
+// and has a Files function returning them as a *protoregistry.Files. The
+// package name defaults to the directory name. The proto directory in dir
+// is replaced on every run, so files of removed sources do not linger.
+func (c *Compiler) WithDescriptorPackage(dir, name string) *Compiler {
+	c.descPkgDir = dir
+	c.descPkgName = name
+	return c
+}
+
+// validateDescriptorPackage checks the package name.
+func (c *compilerImpl) validateDescriptorPackage() error {
+	if c.descPkgDir == "" || c.descPkgName == "" {
+		return nil
+	}
+	if !gotoken.IsIdentifier(c.descPkgName) {
+		return fmt.Errorf("invalid descriptor package name %q", c.descPkgName)
+	}
+	return nil
+}
+
+// writeDescriptorPackage writes the package set with WithDescriptorPackage
+// for the compiled files.
+func (c *compilerImpl) writeDescriptorPackage(files []string) error {
+	if c.descPkgDir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(c.descPkgDir)
+	if err != nil {
+		return fmt.Errorf("resolve descriptor package directory: %w", err)
+	}
+	name := c.descPkgName
+	if name == "" {
+		name = guessPackageName(filepath.Base(dir))
+		if !gotoken.IsIdentifier(name) {
+			return fmt.Errorf("cannot derive a package name from %s, set one with WithDescriptorPackage", dir)
+		}
+	}
+
+	set, err := c.descriptorSet(files, true)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("marshal descriptor set: %w", err)
+	}
+	names, err := c.importNames(files)
+	if err != nil {
+		return err
+	}
+
+	sources := filepath.Join(dir, descriptorPackageSource)
+	if err := os.RemoveAll(sources); err != nil {
+		return fmt.Errorf("write descriptor package: %w", err)
+	}
+	for i, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("write descriptor package: %w", err)
+		}
+		dest := filepath.Join(sources, filepath.FromSlash(names[i]))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("write descriptor package: %w", err)
+		}
+		if err := os.WriteFile(dest, content, 0644); err != nil {
+			return fmt.Errorf("write descriptor package: %w", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, descriptorPackageSet), data, 0644); err != nil {
+		return fmt.Errorf("write descriptor package: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := descriptorPackageTemplate.Execute(&buf, map[string]string{
+		"Package": name,
+		"Set":     descriptorPackageSet,
+		"Sources": descriptorPackageSource,
+	}); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("format descriptor package: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, descriptorPackageFile), src, 0644); err != nil {
+		return fmt.Errorf("write descriptor package: %w", err)
+	}
+	if c.verbose {
+		fmt.Printf("Wrote descriptor package %s with %d files to %s\n", name, len(files), dir)
+	}
+	return nil
+}
+
+var descriptorPackageTemplate = template.Must(template.New("").Parse(`// Code generated by protoc-go. DO NOT EDIT.
+
+// Package {{.Package}} embeds compiled .proto files and their descriptors.
+package {{.Package}}
+
+import (
+	"embed"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Sources holds the compiled .proto files below {{.Sources}}/, by their
+// import path.
+//
+//go:embed {{.Sources}}
+var Sources embed.FS
+
+//go:embed {{.Set}}
+var descriptorSet []byte
+
+// DescriptorSet returns the serialized FileDescriptorSet of the compiled
+// files and everything they import, with source info.
+func DescriptorSet() []byte {
+	return append([]byte(nil), descriptorSet...)
+}
+
+var files struct {
+	once  sync.Once
+	files *protoregistry.Files
+}
+
+// Files returns the registry of the compiled files and everything they
+// import. It is built on first use and shared by all callers.
+func Files() *protoregistry.Files {
+	files.once.Do(func() {
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(descriptorSet, set); err != nil {
+			panic("{{.Package}}: invalid descriptor set: " + err.Error())
+		}
+		registry, err := protodesc.NewFiles(set)
+		if err != nil {
+			panic("{{.Package}}: invalid descriptor set: " + err.Error())
+		}
+		files.files = registry
+	})
+	return files.files
+}
+`))
@@ -0,0 +1,106 @@
+package protoc_test
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestDescriptorPackage(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	schema := filepath.Join(tmpDir, "api-schema")
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto": `syntax = "proto3";
+package a;
+import "b/b.proto";
+import "google/protobuf/timestamp.proto";
+message A { b.B b = 1; google.protobuf.Timestamp at = 2; }
+`,
+		"b/b.proto": `syntax = "proto3"; package b; message B {}`,
+	})
+	// A source removed since the last run
+	writeProtos(t, filepath.Join(schema, "proto"), map[string]string{"old.proto": `syntax = "proto3";`})
+
+	none := func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		return &pluginpb.CodeGeneratorResponse{}, nil
+	}
+	_, err := protoc.NewCompiler().
+		WithProtoDir(workspace).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(filepath.Join(tmpDir, "gen")).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins("none").
+		WithGenerator("none", none).
+		WithDescriptorPackage(schema, "").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	src, err := os.ReadFile(filepath.Join(schema, "descriptors.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "descriptors.go", src, 0)
+	if err != nil {
+		t.Fatalf("generated code does not parse: %v", err)
+	}
+	if file.Name.Name != "api_schema" {
+		t.Errorf("package = %s, want api_schema", file.Name.Name)
+	}
+	for _, want := range []string{"//go:embed proto\n", "//go:embed descriptors.binpb\n", "func Files() *protoregistry.Files"} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("expected %q in the generated code", want)
+		}
+	}
+
+	for _, name := range []string{"a/a.proto", "b/b.proto"} {
+		if _, err := os.Stat(filepath.Join(schema, "proto", filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected the source %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(schema, "proto", "old.proto")); !os.IsNotExist(err) {
+		t.Errorf("expected the stale source to be removed, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(schema, "descriptors.binpb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("descriptor set is not self-contained: %v", err)
+	}
+	if _, err := files.FindDescriptorByName("a.A"); err != nil {
+		t.Errorf("expected a.A in the descriptors: %v", err)
+	}
+}
+
+func TestDescriptorPackageInvalidName(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{"a.proto": `syntax = "proto3";`})
+	_, err := protoc.NewCompiler().
+		WithProtoDir(tmpDir).
+		WithProtoWorkSpace(tmpDir).
+		WithOutputDir(tmpDir).
+		WithDescriptorPackage(tmpDir, "my-schema").
+		Compile()
+	if err == nil || !strings.Contains(err.Error(), `invalid descriptor package name "my-schema"`) {
+		t.Errorf("error = %v, want invalid package name", err)
+	}
+}
@@ -0,0 +1,177 @@
+package protoc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// GeneratorFunc generates code in-process. It receives the same
+// CodeGeneratorRequest a protoc plugin reads from stdin and returns the
+// response a plugin would write to stdout.
+type GeneratorFunc func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error)
+
+// WithGenerator implements a plugin with a Go function instead of a
+// protoc-gen-<plugin> executable. The plugin must also be selected with
+// WithPlugins. The compiler runs protoc to produce the descriptors of the
+// files and their imports, builds the request with the options set by
+// WithPluginOpts as its parameter, calls gen and writes the files of the
+// response, including insertion points, to the output directory of the
+// plugin. An error in the response is returned by Compile.
+func (c *Compiler) WithGenerator(plugin string, gen GeneratorFunc) *Compiler {
+	if c.generators == nil {
+		c.generators = make(map[string]GeneratorFunc)
+	}
+	c.generators[plugin] = gen
+	return c
+}
+
+// splitGenerators separates the plugins protoc runs from the ones
+// implemented in-process.
+func (c *compilerImpl) splitGenerators(plugins []string) (external, inProcess []string) {
+	for _, plugin := range plugins {
+		if _, ok := c.generator(plugin); ok {
+			inProcess = append(inProcess, plugin)
+		} else {
+			external = append(external, plugin)
+		}
+	}
+	return external, inProcess
+}
+
+// generator returns the in-process implementation of a plugin: one set
+// with WithGenerator or, unless it has an executable set with
+// WithPluginPath, the built-in template generator.
+func (c *compilerImpl) generator(plugin string) (GeneratorFunc, bool) {
+	if gen, ok := c.generators[plugin]; ok {
+		return gen, true
+	}
+	if _, ok := c.pluginPaths[plugin]; !ok && plugin == templatePlugin {
+		return templatePluginGenerator, true
+	}
+	return nil, false
+}
+
+// generatorRequest builds the request for the files to generate from a
+// descriptor set containing them and their imports, dependencies first.
+func generatorRequest(set *descriptorpb.FileDescriptorSet, names []string, parameter string) *pluginpb.CodeGeneratorRequest {
+	req := &pluginpb.CodeGeneratorRequest{FileToGenerate: names}
+	if parameter != "" {
+		req.Parameter = proto.String(parameter)
+	}
+	generate := make(map[string]bool)
+	for _, name := range names {
+		generate[name] = true
+	}
+	for _, file := range set.GetFile() {
+		// Each request gets its own copy, so generators cannot affect
+		// each other.
+		file = proto.Clone(file).(*descriptorpb.FileDescriptorProto)
+		req.ProtoFile = append(req.ProtoFile, file)
+		if generate[file.GetName()] {
+			req.SourceFileDescriptors = append(req.SourceFileDescriptors, file)
+		}
+	}
+	return req
+}
+
+// writeResponse writes the files of a plugin response to the output
+// directory of the plugin, as protoc would.
+func (c *compilerImpl) writeResponse(plugin string, resp *pluginpb.CodeGeneratorResponse) error {
+	if resp.Error != nil {
+		return fmt.Errorf("plugin %s failed: %s", plugin, resp.GetError())
+	}
+
+	outDir := c.pluginOutputDir(plugin)
+	contents := make(map[string][]byte)
+	var order []string
+	last := ""
+	for _, file := range resp.GetFile() {
+		name := file.GetName()
+		if name == "" {
+			// A file without a name continues the previous one
+			if last == "" {
+				return fmt.Errorf("plugin %s generated a file without a name", plugin)
+			}
+			contents[last] = append(contents[last], file.GetContent()...)
+			continue
+		}
+		clean := path.Clean(name)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("plugin %s generated an invalid file name %q", plugin, name)
+		}
+
+		if point := file.GetInsertionPoint(); point != "" {
+			content, ok := contents[clean]
+			if !ok {
+				var err error
+				if content, err = os.ReadFile(filepath.Join(outDir, filepath.FromSlash(clean))); err != nil {
+					return fmt.Errorf("plugin %s: insertion point %q in %s: %w", plugin, point, clean, err)
+				}
+				order = append(order, clean)
+			}
+			if content, ok = insertAtPoint(content, point, file.GetContent()); !ok {
+				return fmt.Errorf("plugin %s: insertion point %q not found in %s", plugin, point, clean)
+			}
+			contents[clean] = content
+			last = ""
+			continue
+		}
+
+		if _, ok := contents[clean]; ok {
+			return fmt.Errorf("plugin %s generated %s more than once", plugin, clean)
+		}
+		contents[clean] = []byte(file.GetContent())
+		order = append(order, clean)
+		last = clean
+	}
+
+	for _, name := range order {
+		file := filepath.Join(outDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("create output directory: %w", err)
+		}
+		if err := os.WriteFile(file, contents[name], 0644); err != nil {
+			return fmt.Errorf("write generated file: %w", err)
+		}
+		c.generated = append(c.generated, file)
+	}
+	return nil
+}
+
+// insertAtPoint inserts text before the line containing the insertion
+// point marker, indented like the marker.
+func insertAtPoint(content []byte, point, text string) ([]byte, bool) {
+	marker := []byte("@@protoc_insertion_point(" + point + ")")
+	i := bytes.Index(content, marker)
+	if i < 0 {
+		return nil, false
+	}
+	start := bytes.LastIndexByte(content[:i], '\n') + 1
+	line := content[start:i]
+	indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+
+	var buf bytes.Buffer
+	buf.Write(content[:start])
+	for _, l := range strings.SplitAfter(text, "\n") {
+		if l == "" {
+			continue
+		}
+		if l != "\n" {
+			buf.Write(indent)
+		}
+		buf.WriteString(l)
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		buf.WriteByte('\n')
+	}
+	buf.Write(content[start:])
+	return buf.Bytes(), true
+}
@@ -0,0 +1,141 @@
+package protoc_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestGenerator(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping generator test")
+	}
+
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto": `syntax = "proto3"; package a; import "b/b.proto"; message A { b.B b = 1; } message C {}`,
+		"b/b.proto": `syntax = "proto3"; package b; message B {}`,
+	})
+	stub := writeStubPlugin(t, tmpDir, "stub", map[string]string{
+		"stub.txt": "begin\n  // @@protoc_insertion_point(names)\nend\n",
+	})
+
+	var req *pluginpb.CodeGeneratorRequest
+	list := func(r *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		req = r
+		resp := &pluginpb.CodeGeneratorResponse{}
+		for _, file := range r.GetProtoFile() {
+			for _, msg := range file.GetMessageType() {
+				resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+					Name:           proto.String("stub.txt"),
+					InsertionPoint: proto.String("names"),
+					Content:        proto.String(file.GetPackage() + "." + msg.GetName()),
+				})
+			}
+		}
+		resp.File = append(resp.File,
+			&pluginpb.CodeGeneratorResponse_File{Name: proto.String("out/params.txt"), Content: proto.String(r.GetParameter())},
+			&pluginpb.CodeGeneratorResponse_File{Content: proto.String("\n")},
+		)
+		return resp, nil
+	}
+
+	_, err := protoc.NewCompiler().
+		WithProtoDir(filepath.Join(workspace, "a")).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(gen).
+		WithPlugins("stub", "list").
+		WithPluginPath("stub", stub).
+		WithGenerator("list", list).
+		WithPluginOpts("list", "x=1", "y").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if got := req.GetFileToGenerate(); len(got) != 1 || got[0] != "a/a.proto" {
+		t.Errorf("FileToGenerate = %v, want [a/a.proto]", got)
+	}
+	var names []string
+	for _, file := range req.GetProtoFile() {
+		names = append(names, file.GetName())
+	}
+	if strings.Join(names, " ") != "b/b.proto a/a.proto" {
+		t.Errorf("ProtoFile = %v, want dependencies first", names)
+	}
+	if len(req.GetSourceFileDescriptors()) != 1 {
+		t.Errorf("expected one source file descriptor, got %d", len(req.GetSourceFileDescriptors()))
+	}
+
+	want := map[string]string{
+		"stub.txt":       "begin\n  b.B\n  a.A\n  a.C\n  // @@protoc_insertion_point(names)\nend\n",
+		"out/params.txt": "x=1,y\n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(gen, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping generator test")
+	}
+
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	writeProtos(t, workspace, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+
+	tests := []struct {
+		name string
+		gen  protoc.GeneratorFunc
+		want string
+	}{
+		{"error", func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+			return nil, errors.New("boom")
+		}, "plugin test: boom"},
+		{"response error", func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+			return &pluginpb.CodeGeneratorResponse{Error: proto.String("bad option")}, nil
+		}, "plugin test failed: bad option"},
+		{"escaping name", func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+			return &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+				{Name: proto.String("../x.txt"), Content: proto.String("x")},
+			}}, nil
+		}, `invalid file name "../x.txt"`},
+		{"missing insertion point", func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+			return &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+				{Name: proto.String("x.txt"), Content: proto.String("x\n")},
+				{Name: proto.String("x.txt"), InsertionPoint: proto.String("p"), Content: proto.String("y")},
+			}}, nil
+		}, `insertion point "p" not found in x.txt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := protoc.NewCompiler().
+				WithProtoDir(workspace).
+				WithProtoWorkSpace(workspace).
+				WithOutputDir(filepath.Join(tmpDir, "gen")).
+				WithPlugins("test").
+				WithGenerator("test", tt.gen).
+				Compile()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
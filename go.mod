@@ -1,3 +1,12 @@
 module github.com/dongrv/protoc-go
 
 go 1.21.13
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/bufbuild/protocompile v0.14.1
+	google.golang.org/protobuf v1.36.5
+)
+
+require golang.org/x/sync v0.8.0 // indirect
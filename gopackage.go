@@ -0,0 +1,313 @@
+package protoc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// resolveGoPackages computes the M options for the go and go-grpc plugins
+// from the explicit mapping and, with a prefix, from the location of every
+// file without a go_package option among files and their imports.
+func (c *compilerImpl) resolveGoPackages(files []string) error {
+	c.goMappings = nil
+	if len(c.goPackageMap) == 0 && c.goPkgPrefix == "" {
+		return nil
+	}
+
+	mapping := make(map[string]string, len(c.goPackageMap))
+	for file, importPath := range c.goPackageMap {
+		mapping[path.Clean(strings.ReplaceAll(file, "\\", "/"))] = importPath
+	}
+
+	if c.goPkgPrefix != "" {
+		graph, err := c.buildGraph(c.ctx, files)
+		if err != nil {
+			return fmt.Errorf("resolve imports: %w", err)
+		}
+		for name, node := range graph.Nodes {
+			if _, ok := mapping[name]; ok {
+				continue
+			}
+			parsed, err := parseProtoFile(node.Path)
+			if err != nil {
+				return fmt.Errorf("parse %s: %w", name, err)
+			}
+			if _, ok := parsed.option("go_package"); ok {
+				continue
+			}
+			mapping[name] = inferGoPackage(c.goPkgPrefix, name)
+		}
+	}
+
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.goMappings = append(c.goMappings, "M"+name+"="+mapping[name])
+	}
+	return nil
+}
+
+// inferGoPackage returns the import path of a file below modulePath,
+// matching its directory.
+func inferGoPackage(modulePath, name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return modulePath
+	}
+	return modulePath + "/" + dir
+}
+
+// goPluginOpts returns the options of the go or go-grpc plugin, followed
+// by the resolved M options.
+func (c *compilerImpl) goPluginOpts(plugin string) []string {
+	opts := c.goOpts
+	if plugin == "go-grpc" {
+		opts = c.goGrpcOpts
+	}
+	if len(c.goMappings) == 0 {
+		return opts
+	}
+	return append(append([]string(nil), opts...), c.goMappings...)
+}
+
+// Go package validation rules.
+const (
+	goPackageMissing   = "GO_PACKAGE_MISSING"
+	goPackageModule    = "GO_PACKAGE_MODULE"
+	goPackageLocation  = "GO_PACKAGE_LOCATION"
+	goPackageCollision = "GO_PACKAGE_COLLISION"
+)
+
+// goOutput is where a Go plugin writes the code of a file.
+type goOutput struct {
+	name       string // Source file
+	importPath string
+	pkgName    string
+	dir        string // Absolute output directory
+	file       string // Absolute output file
+}
+
+// goPackageProblems checks, for the go and go-grpc plugins, that every file
+// has a Go import path, that the directory its code is generated into is
+// the package it declares when that directory belongs to a Go module, and
+// that no two files generate conflicting packages or the same output file.
+// resolveGoPackages must have been called for files.
+func (c *compilerImpl) goPackageProblems(files []string) ([]Diagnostic, error) {
+	var diags []Diagnostic
+	seen := make(map[string]bool)
+	report := func(name string, parsed *protoFile, rule, format string, args ...interface{}) {
+		pos := goPackagePos(parsed)
+		d := Diagnostic{File: name, Line: pos.line, Column: pos.col, Rule: rule, Message: fmt.Sprintf(format, args...)}
+		if key := d.String(); !seen[key] {
+			seen[key] = true
+			diags = append(diags, d)
+		}
+	}
+
+	parsed := make(map[string]*protoFile, len(files))
+	modules := make(map[string]goModule)
+	for _, plugin := range c.plugins {
+		var suffix string
+		switch plugin {
+		case "go":
+			suffix = ".pb.go"
+		case "go-grpc":
+			suffix = "_grpc.pb.go"
+		default:
+			continue
+		}
+		opts := c.goPluginOpts(plugin)
+		outDir, err := filepath.Abs(c.pluginOutputDir(plugin))
+		if err != nil {
+			return nil, err
+		}
+
+		byDir := make(map[string]goOutput)
+		byImport := make(map[string]goOutput)
+		byFile := make(map[string]goOutput)
+		for _, file := range files {
+			name, err := c.importName(file)
+			if err != nil {
+				return nil, err
+			}
+			p, ok := parsed[name]
+			if !ok {
+				if p, err = parseProtoFile(file); err != nil {
+					return nil, fmt.Errorf("parse %s: %w", name, err)
+				}
+				parsed[name] = p
+			}
+			// protoc-gen-go-grpc skips files without services
+			if plugin == "go-grpc" && len(p.services) == 0 {
+				continue
+			}
+
+			goPackage, _ := p.option("go_package")
+			goPackage = mappedGoPackage(name, goPackage, opts)
+			importPath, pkgName := splitGoPackage(goPackage)
+			if importPath == "" {
+				report(name, p, goPackageMissing, "no go_package option or M mapping, the Go import path cannot be determined")
+				continue
+			}
+			rel, ok := goOutputPath(name, goPackage, opts, suffix)
+			if !ok {
+				report(name, p, goPackageModule, "go_package %q is outside the module prefix %q of the %s plugin", importPath, goModuleOpt(opts), plugin)
+				continue
+			}
+
+			out := goOutput{name: name, importPath: importPath, pkgName: pkgName, file: filepath.Join(outDir, filepath.FromSlash(rel))}
+			out.dir = filepath.Dir(out.file)
+
+			mod, ok := modules[out.dir]
+			if !ok {
+				mod = findGoModule(out.dir)
+				modules[out.dir] = mod
+			}
+			if actual, ok := mod.importPath(out.dir); ok && actual != importPath {
+				report(name, p, goPackageLocation, "generated into %s, which is Go package %q, but go_package is %q", out.dir, actual, importPath)
+			}
+
+			if other, ok := byFile[out.file]; ok {
+				report(name, p, goPackageCollision, "generates %s, which %s also generates", out.file, other.name)
+			} else {
+				byFile[out.file] = out
+			}
+			if other, ok := byDir[out.dir]; ok && other.pkgName != out.pkgName {
+				report(name, p, goPackageCollision, "Go package %s conflicts with package %s of %s in %s", out.pkgName, other.pkgName, other.name, out.dir)
+			} else if !ok {
+				byDir[out.dir] = out
+			}
+			if other, ok := byImport[importPath]; ok && other.dir != out.dir {
+				report(name, p, goPackageCollision, "go_package %q is generated into %s, but into %s for %s", importPath, out.dir, other.dir, other.name)
+			} else if !ok {
+				byImport[importPath] = out
+			}
+		}
+	}
+
+	sortDiagnostics(diags)
+	return diags, nil
+}
+
+// validateGoPackages returns the problems found by goPackageProblems as
+// an error.
+func (c *compilerImpl) validateGoPackages(files []string) error {
+	if !c.goPkgCheck {
+		return nil
+	}
+	diags, err := c.goPackageProblems(files)
+	if err != nil {
+		return err
+	}
+	if c.result != nil {
+		c.result.Diagnostics = append(c.result.Diagnostics, diags...)
+	}
+	return diagnosticsError(diags)
+}
+
+// diagnosticsError joins diagnostics into an error, one per line.
+func diagnosticsError(diags []Diagnostic) error {
+	errs := make([]error, len(diags))
+	for i, d := range diags {
+		errs[i] = errors.New(d.String())
+	}
+	return errors.Join(errs...)
+}
+
+// mappedGoPackage applies an M option for name to its go_package.
+func mappedGoPackage(name, goPackage string, opts []string) string {
+	for _, opt := range opts {
+		if key, value, _ := strings.Cut(opt, "="); strings.HasPrefix(key, "M") && key[1:] == name {
+			goPackage = value
+		}
+	}
+	return goPackage
+}
+
+// splitGoPackage splits a go_package value into the import path and the
+// package name, which defaults to the last element of the import path like
+// protoc-gen-go does.
+func splitGoPackage(goPackage string) (string, string) {
+	importPath, name, ok := strings.Cut(goPackage, ";")
+	if ok && name != "" {
+		return importPath, name
+	}
+	base := path.Base(importPath)
+	var sb strings.Builder
+	for i, r := range base {
+		switch {
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) && i > 0:
+			sb.WriteRune(r)
+		case unicode.IsDigit(r):
+			sb.WriteString("_")
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return importPath, sb.String()
+}
+
+// goModuleOpt returns the module= option of a plugin.
+func goModuleOpt(opts []string) string {
+	module := ""
+	for _, opt := range opts {
+		if key, value, _ := strings.Cut(opt, "="); key == "module" {
+			module = value
+		}
+	}
+	return module
+}
+
+// goModule is the Go module enclosing a directory.
+type goModule struct {
+	dir  string // Directory containing go.mod; empty if none was found
+	path string // Module path
+}
+
+// findGoModule looks for the go.mod file of dir or its closest parent.
+// The directory does not need to exist.
+func findGoModule(dir string) goModule {
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if rest, ok := strings.CutPrefix(line, "module"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+					rest, _, _ = strings.Cut(rest, "//")
+					return goModule{dir: dir, path: strings.Trim(strings.TrimSpace(rest), `"`)}
+				}
+			}
+			return goModule{}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return goModule{}
+		}
+		dir = parent
+	}
+}
+
+// importPath returns the Go import path of a directory in the module.
+func (m goModule) importPath(dir string) (string, bool) {
+	if m.dir == "" || m.path == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(m.dir, dir)
+	if err != nil {
+		return "", false
+	}
+	if rel == "." {
+		return m.path, true
+	}
+	return m.path + "/" + filepath.ToSlash(rel), true
+}
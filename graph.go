@@ -0,0 +1,481 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ImportKind describes how a .proto file imports another.
+type ImportKind int
+
+const (
+	// ImportDefault is a plain "import" statement.
+	ImportDefault ImportKind = iota
+	// ImportPublic is an "import public" statement.
+	ImportPublic
+	// ImportWeak is an "import weak" statement.
+	ImportWeak
+)
+
+// String returns the keyword used for the import kind.
+func (k ImportKind) String() string {
+	switch k {
+	case ImportPublic:
+		return "public"
+	case ImportWeak:
+		return "weak"
+	default:
+		return "default"
+	}
+}
+
+// Import is a single import statement of a file in the graph.
+type Import struct {
+	Path     string     // Import path as written in the source
+	Kind     ImportKind // Plain, public or weak import
+	Line     int        // Line of the import statement
+	Resolved bool       // Whether Path was found on the include paths
+}
+
+// GraphNode is a .proto file in the import graph.
+type GraphNode struct {
+	Name     string   // Path relative to its include directory, using forward slashes
+	Path     string   // Absolute filesystem path
+	Package  string   // Declared proto package
+	External bool     // Resolved outside the workspace directory
+	Imports  []Import // Import statements in source order
+}
+
+// UnresolvedImport is an import that could not be found on any include path.
+type UnresolvedImport struct {
+	File   string `json:"file"`   // Name of the importing file
+	Import string `json:"import"` // Import path that could not be resolved
+	Line   int    `json:"line"`   // Line of the import statement
+}
+
+// Graph is the import dependency graph of a set of .proto files.
+type Graph struct {
+	// Nodes holds every file reached from the discovered files, keyed by name.
+	Nodes map[string]*GraphNode
+	// Files lists the names of the discovered files the graph was built from.
+	Files []string
+	// Cycles lists import cycles. Each cycle is reported once, as the names
+	// of the files involved in sorted order; the error of TopologicalOrder
+	// shows the imports along the first one.
+	Cycles [][]string
+	// Unresolved lists imports that could not be found on any include path.
+	Unresolved []UnresolvedImport
+}
+
+// Dependencies returns the names of the files directly imported by name.
+func (g *Graph) Dependencies(name string) []string {
+	node, ok := g.Nodes[name]
+	if !ok {
+		return nil
+	}
+	var deps []string
+	for _, imp := range node.Imports {
+		if imp.Resolved {
+			deps = append(deps, imp.Path)
+		}
+	}
+	return deps
+}
+
+// Dependents returns the names of the files that directly import name.
+func (g *Graph) Dependents(name string) []string {
+	var dependents []string
+	for _, node := range g.Nodes {
+		for _, imp := range node.Imports {
+			if imp.Resolved && imp.Path == name {
+				dependents = append(dependents, node.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Closure returns the given files together with everything they import,
+// directly or transitively, in sorted order.
+func (g *Graph) Closure(names ...string) []string {
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		if _, ok := g.Nodes[name]; !ok {
+			return
+		}
+		seen[name] = true
+		for _, dep := range g.Dependencies(name) {
+			visit(dep)
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+
+	closure := make([]string, 0, len(seen))
+	for name := range seen {
+		closure = append(closure, name)
+	}
+	sort.Strings(closure)
+	return closure
+}
+
+// TopologicalOrder returns all files in the graph ordered so that every file
+// comes after the files it imports. Ties are broken by name, so the order is
+// deterministic. An error is returned if the graph contains a cycle.
+func (g *Graph) TopologicalOrder() ([]string, error) {
+	if len(g.Cycles) > 0 {
+		return nil, fmt.Errorf("import cycle: %s", strings.Join(cyclePath(g.Cycles[0], g.Dependencies), " -> "))
+	}
+
+	pending := make(map[string]int, len(g.Nodes))
+	for name := range g.Nodes {
+		pending[name] = len(uniqueStrings(g.Dependencies(name)))
+	}
+
+	var order []string
+	for len(pending) > 0 {
+		var ready []string
+		for name, n := range pending {
+			if n == 0 {
+				ready = append(ready, name)
+			}
+		}
+		sort.Strings(ready)
+		for _, name := range ready {
+			delete(pending, name)
+			order = append(order, name)
+			for _, dependent := range g.Dependents(name) {
+				if _, ok := pending[dependent]; ok {
+					pending[dependent]--
+				}
+			}
+		}
+	}
+	return order, nil
+}
+
+// uniqueStrings returns values without duplicates, preserving order.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// findCycles returns the groups of files that import each other in a cycle.
+func (g *Graph) findCycles() [][]string {
+	names := make([]string, 0, len(g.Nodes))
+	for name := range g.Nodes {
+		names = append(names, name)
+	}
+	return findCycles(names, g.Dependencies)
+}
+
+// findCycles returns the strongly connected components of the directed
+// graph given by names and deps that form cycles, using Tarjan's algorithm.
+// Each component is sorted, and components are sorted by their first name.
+func findCycles(names []string, deps func(string) []string) [][]string {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var cycles [][]string
+	next := 0
+
+	var connect func(name string)
+	connect = func(name string) {
+		index[name] = next
+		low[name] = next
+		next++
+		stack = append(stack, name)
+		onStack[name] = true
+
+		selfLoop := false
+		for _, dep := range deps(name) {
+			if dep == name {
+				selfLoop = true
+			}
+			if _, visited := index[dep]; !visited {
+				connect(dep)
+				if low[dep] < low[name] {
+					low[name] = low[dep]
+				}
+			} else if onStack[dep] && index[dep] < low[name] {
+				low[name] = index[dep]
+			}
+		}
+
+		if low[name] != index[name] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == name {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, name := range names {
+		if _, visited := index[name]; !visited {
+			connect(name)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// cyclePath returns the shortest cycle through the first name of a
+// component found by findCycles, following deps, as the names along it
+// starting and ending with that name.
+func cyclePath(component []string, deps func(string) []string) []string {
+	members := make(map[string]bool, len(component))
+	for _, name := range component {
+		members[name] = true
+	}
+	start := component[0]
+	prev := make(map[string]string)
+	queue := []string{start}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dep := range deps(name) {
+			if dep == start {
+				path := []string{start}
+				for n := name; n != start; n = prev[n] {
+					path = append(path, n)
+				}
+				path = append(path, start)
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			if _, seen := prev[dep]; members[dep] && !seen {
+				prev[dep] = name
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return component
+}
+
+// Graph parses the import statements of every discovered .proto file, or of
+// the roots set with WithRoots, and returns the resulting dependency graph.
+// Imports are resolved against the workspace directory, the extra import
+// paths, protoc's own include directory and the bundled well-known types
+// enabled with WithWellKnownTypes, and imported files are followed
+// transitively. No plugins are run and protoc itself is not required.
+func (c *Compiler) Graph(ctx context.Context) (*Graph, error) {
+	if c.protoDir == "" && len(c.roots) == 0 && c.protoFS == nil {
+		return nil, fmt.Errorf("proto directory not specified")
+	}
+	if c.workspaceDir == "" && c.protoFS == nil {
+		return nil, fmt.Errorf("workspace directory not specified")
+	}
+
+	impl := c.newImpl()
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return impl.graph(ctx)
+}
+
+// graph builds the import graph of the discovered files, or of the roots
+// when they are set.
+func (c *compilerImpl) graph(ctx context.Context) (*Graph, error) {
+	if err := c.validateSources(); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	var err error
+	if len(c.roots) > 0 {
+		files, err = c.resolveRoots()
+	} else {
+		files, err = c.findProtoFiles()
+		if err != nil {
+			err = fmt.Errorf("find proto files: %w", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.buildGraph(ctx, files)
+}
+
+// buildGraph parses files and everything they import into a Graph.
+func (c *compilerImpl) buildGraph(ctx context.Context, files []string) (*Graph, error) {
+	if err := c.setupWellKnownTypes(); err != nil {
+		return nil, err
+	}
+	g := &Graph{Nodes: make(map[string]*GraphNode)}
+	includes := c.includePaths()
+
+	var queue []*GraphNode
+	for _, file := range files {
+		name, err := c.importName(file)
+		if err != nil {
+			return nil, err
+		}
+		g.Files = append(g.Files, name)
+		if _, ok := g.Nodes[name]; ok {
+			continue
+		}
+		node := &GraphNode{Name: name, Path: file, External: !c.inWorkspace(file)}
+		g.Nodes[name] = node
+		queue = append(queue, node)
+	}
+	sort.Strings(g.Files)
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		node := queue[0]
+		queue = queue[1:]
+
+		parsed, err := parseProtoFile(node.Path)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", node.Name, err)
+		}
+		node.Package = parsed.pkg
+
+		for _, imp := range parsed.imports {
+			entry := Import{Path: imp.path, Kind: imp.kind, Line: imp.pos.line}
+			path := resolveImport(includes, imp.path)
+			if path == "" {
+				g.Unresolved = append(g.Unresolved, UnresolvedImport{
+					File:   node.Name,
+					Import: imp.path,
+					Line:   imp.pos.line,
+				})
+			} else {
+				entry.Resolved = true
+				if _, ok := g.Nodes[imp.path]; !ok {
+					dep := &GraphNode{Name: imp.path, Path: path, External: !c.inWorkspace(path)}
+					g.Nodes[imp.path] = dep
+					queue = append(queue, dep)
+				}
+			}
+			node.Imports = append(node.Imports, entry)
+		}
+	}
+
+	sort.Slice(g.Unresolved, func(i, j int) bool {
+		a, b := g.Unresolved[i], g.Unresolved[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	g.Cycles = g.findCycles()
+	return g, nil
+}
+
+// importName returns the name protoc uses for a file under the workspace:
+// its path relative to the workspace directory with forward slashes.
+func (c *compilerImpl) importName(file string) (string, error) {
+	absWorkspace, err := filepath.Abs(c.workspaceDir)
+	if err != nil {
+		return "", fmt.Errorf("resolve workspace directory: %w", err)
+	}
+	relPath, err := filepath.Rel(absWorkspace, file)
+	if err != nil {
+		return "", fmt.Errorf("cannot get relative path for %s: %w", file, err)
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// inWorkspace reports whether path is inside the workspace directory.
+func (c *compilerImpl) inWorkspace(path string) bool {
+	absWorkspace, err := filepath.Abs(c.workspaceDir)
+	if err != nil {
+		return false
+	}
+	relPath, err := filepath.Rel(absWorkspace, path)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// includePaths returns the directories imports are resolved against, in the
+// order protoc searches them.
+func (c *compilerImpl) includePaths() []string {
+	dirs := []string{c.workspaceDir}
+	dirs = append(dirs, c.importPaths...)
+	dirs = append(dirs, protocIncludeDirs(c.protocExecutable())...)
+	dirs = append(dirs, c.wellKnownIncludes()...)
+
+	var abs []string
+	for _, dir := range dirs {
+		if path, err := filepath.Abs(dir); err == nil {
+			abs = append(abs, path)
+		}
+	}
+	return abs
+}
+
+// resolveImport returns the filesystem path of the import, or "" if it is
+// not found in any of the include directories.
+func resolveImport(includes []string, importPath string) string {
+	for _, dir := range includes {
+		path := filepath.Join(dir, filepath.FromSlash(importPath))
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// protocIncludeDirs returns the include directories the protoc binary adds
+// on its own: "include" next to the binary and "../include" relative to it,
+// when they contain the well-known types.
+func protocIncludeDirs(protoc string) []string {
+	path, err := exec.LookPath(protoc)
+	if err != nil {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	binDir := filepath.Dir(path)
+	var dirs []string
+	for _, dir := range []string{filepath.Join(binDir, "include"), filepath.Join(binDir, "..", "include")} {
+		marker := filepath.Join(dir, "google", "protobuf", "descriptor.proto")
+		if _, err := os.Stat(marker); err == nil {
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+	return dirs
+}
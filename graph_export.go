@@ -0,0 +1,265 @@
+package protoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExportOptions controls how a Graph is rendered by WriteDOT, WriteMermaid
+// and WriteJSON.
+type ExportOptions struct {
+	// CollapsePackages merges all files of the same proto package into a
+	// single node. Files without a package are grouped under "(default)".
+	CollapsePackages bool
+	// HighlightCycles marks nodes and imports that take part in a cycle.
+	HighlightCycles bool
+	// HighlightExternal marks nodes resolved outside the workspace.
+	HighlightExternal bool
+}
+
+// defaultPackageNode is the collapsed node for files without a package.
+const defaultPackageNode = "(default)"
+
+// exportNode is a node of the graph as rendered by the exporters.
+type exportNode struct {
+	ID       string   `json:"id"`
+	Package  string   `json:"package,omitempty"`
+	Files    []string `json:"files,omitempty"`
+	External bool     `json:"external,omitempty"`
+	Cycle    bool     `json:"cycle,omitempty"`
+}
+
+// exportEdge is an import between two rendered nodes.
+type exportEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Kind  string `json:"kind"`
+	Cycle bool   `json:"cycle,omitempty"`
+}
+
+// exportView is the graph reduced to what the exporters render.
+type exportView struct {
+	Nodes      []*exportNode      `json:"nodes"`
+	Edges      []*exportEdge      `json:"edges"`
+	Cycles     [][]string         `json:"cycles,omitempty"`
+	Unresolved []UnresolvedImport `json:"unresolved,omitempty"`
+}
+
+// view builds the rendered form of the graph for the given options.
+func (g *Graph) view(opts ExportOptions) *exportView {
+	nodeID := func(node *GraphNode) string {
+		if !opts.CollapsePackages {
+			return node.Name
+		}
+		if node.Package == "" {
+			return defaultPackageNode
+		}
+		return node.Package
+	}
+
+	nodes := make(map[string]*exportNode)
+	allExternal := make(map[string]bool)
+	for _, node := range g.Nodes {
+		id := nodeID(node)
+		n, ok := nodes[id]
+		if !ok {
+			n = &exportNode{ID: id, Package: node.Package}
+			nodes[id] = n
+			allExternal[id] = true
+		}
+		n.Files = append(n.Files, node.Name)
+		allExternal[id] = allExternal[id] && node.External
+	}
+
+	edges := make(map[[2]string]*exportEdge)
+	deps := make(map[string][]string)
+	for _, node := range g.Nodes {
+		from := nodeID(node)
+		for _, imp := range node.Imports {
+			target, ok := g.Nodes[imp.Path]
+			if !imp.Resolved || !ok {
+				continue
+			}
+			to := nodeID(target)
+			if opts.CollapsePackages && from == to {
+				continue
+			}
+			key := [2]string{from, to}
+			if edge, ok := edges[key]; ok {
+				// A plain import outranks public and weak ones between
+				// the same nodes.
+				if imp.Kind == ImportDefault {
+					edge.Kind = imp.Kind.String()
+				}
+				continue
+			}
+			edges[key] = &exportEdge{From: from, To: to, Kind: imp.Kind.String()}
+			deps[from] = append(deps[from], to)
+		}
+	}
+
+	view := &exportView{Unresolved: g.Unresolved}
+	ids := make([]string, 0, len(nodes))
+	for id, n := range nodes {
+		sort.Strings(n.Files)
+		if !opts.CollapsePackages {
+			n.Files = nil
+		}
+		n.External = opts.HighlightExternal && allExternal[id]
+		ids = append(ids, id)
+		view.Nodes = append(view.Nodes, n)
+	}
+	sort.Slice(view.Nodes, func(i, j int) bool { return view.Nodes[i].ID < view.Nodes[j].ID })
+
+	for _, edge := range edges {
+		view.Edges = append(view.Edges, edge)
+	}
+	sort.Slice(view.Edges, func(i, j int) bool {
+		a, b := view.Edges[i], view.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+
+	// Cycles are computed on the rendered graph, so that collapsed
+	// packages report package-level cycles.
+	view.Cycles = findCycles(ids, func(id string) []string { return deps[id] })
+	if opts.HighlightCycles {
+		component := make(map[string]int)
+		for i, cycle := range view.Cycles {
+			for _, id := range cycle {
+				component[id] = i
+				nodes[id].Cycle = true
+			}
+		}
+		for _, edge := range view.Edges {
+			from, okFrom := component[edge.From]
+			to, okTo := component[edge.To]
+			edge.Cycle = okFrom && okTo && from == to
+		}
+	}
+
+	return view
+}
+
+// WriteDOT writes the graph in Graphviz DOT format.
+func (g *Graph) WriteDOT(w io.Writer, opts ExportOptions) error {
+	view := g.view(opts)
+
+	var sb strings.Builder
+	sb.WriteString("digraph imports {\n")
+	sb.WriteString("  rankdir=LR;\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, n := range view.Nodes {
+		attrs := []string{"label=" + dotQuote(n.ID)}
+		if opts.CollapsePackages {
+			attrs = append(attrs, "tooltip="+dotQuote(strings.Join(n.Files, "\n")))
+		}
+		if n.External {
+			attrs = append(attrs, "style=dashed", "color=gray40", "fontcolor=gray40")
+		}
+		if n.Cycle {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+		fmt.Fprintf(&sb, "  %s [%s];\n", dotQuote(n.ID), strings.Join(attrs, ", "))
+	}
+	for _, e := range view.Edges {
+		var attrs []string
+		switch e.Kind {
+		case "public":
+			attrs = append(attrs, "style=bold")
+		case "weak":
+			attrs = append(attrs, "style=dashed")
+		}
+		if e.Cycle {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(&sb, "  %s -> %s", dotQuote(e.From), dotQuote(e.To))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&sb, " [%s]", strings.Join(attrs, ", "))
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// dotQuote returns s as a DOT double-quoted string.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart.
+func (g *Graph) WriteMermaid(w io.Writer, opts ExportOptions) error {
+	view := g.view(opts)
+
+	ids := make(map[string]string, len(view.Nodes))
+	var sb strings.Builder
+	sb.WriteString("graph LR\n")
+	var cycleNodes, externalNodes []string
+	for i, n := range view.Nodes {
+		id := "n" + strconv.Itoa(i)
+		ids[n.ID] = id
+		fmt.Fprintf(&sb, "  %s[\"%s\"]\n", id, mermaidEscape(n.ID))
+		if n.Cycle {
+			cycleNodes = append(cycleNodes, id)
+		}
+		if n.External {
+			externalNodes = append(externalNodes, id)
+		}
+	}
+
+	var cycleEdges []string
+	for i, e := range view.Edges {
+		arrow := "-->"
+		switch e.Kind {
+		case "public":
+			arrow = "==>"
+		case "weak":
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&sb, "  %s %s %s\n", ids[e.From], arrow, ids[e.To])
+		if e.Cycle {
+			cycleEdges = append(cycleEdges, strconv.Itoa(i))
+		}
+	}
+
+	if len(externalNodes) > 0 {
+		sb.WriteString("  classDef external fill:#eee,stroke:#999,stroke-dasharray:5 5\n")
+		fmt.Fprintf(&sb, "  class %s external\n", strings.Join(externalNodes, ","))
+	}
+	if len(cycleNodes) > 0 {
+		sb.WriteString("  classDef cycle stroke:#d00,stroke-width:2px\n")
+		fmt.Fprintf(&sb, "  class %s cycle\n", strings.Join(cycleNodes, ","))
+	}
+	if len(cycleEdges) > 0 {
+		fmt.Fprintf(&sb, "  linkStyle %s stroke:#d00\n", strings.Join(cycleEdges, ","))
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// mermaidEscape escapes characters that cannot appear in a quoted Mermaid
+// label.
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
+
+// WriteJSON writes the graph as an indented JSON document with "nodes",
+// "edges", "cycles" and "unresolved" members.
+func (g *Graph) WriteJSON(w io.Writer, opts ExportOptions) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g.view(opts))
+}
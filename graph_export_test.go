@@ -0,0 +1,160 @@
+package protoc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func exportTestGraph(t *testing.T) *protoc.Graph {
+	t.Helper()
+	tmpDir := t.TempDir()
+	workspaceDir := filepath.Join(tmpDir, "proto")
+	thirdPartyDir := filepath.Join(tmpDir, "third_party")
+
+	writeProtos(t, workspaceDir, map[string]string{
+		"shop/order.proto": `syntax = "proto3"; package shop;
+import "shop/item.proto"; import "billing/invoice.proto"; import weak "vendor/money.proto";`,
+		"shop/item.proto":       `syntax = "proto3"; package shop;`,
+		"billing/invoice.proto": `syntax = "proto3"; package billing; import public "billing/tax.proto";`,
+		"billing/tax.proto":     `syntax = "proto3"; package billing; import "shop/item.proto";`,
+	})
+	writeProtos(t, thirdPartyDir, map[string]string{
+		"vendor/money.proto": `syntax = "proto3"; package vendor;`,
+	})
+
+	graph, err := protoc.NewCompiler().
+		WithProtoDir(workspaceDir).
+		WithProtoWorkSpace(workspaceDir).
+		WithImportPaths(thirdPartyDir).
+		Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	return graph
+}
+
+func TestWriteDOT(t *testing.T) {
+	graph := exportTestGraph(t)
+
+	var buf bytes.Buffer
+	if err := graph.WriteDOT(&buf, protoc.ExportOptions{HighlightExternal: true}); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+
+	for _, want := range []string{
+		"digraph imports {",
+		`"shop/order.proto" -> "shop/item.proto";`,
+		`"shop/order.proto" -> "vendor/money.proto" [style=dashed];`,
+		`"billing/invoice.proto" -> "billing/tax.proto" [style=bold];`,
+		`"vendor/money.proto" [label="vendor/money.proto", style=dashed, color=gray40, fontcolor=gray40];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, "color=red") {
+		t.Errorf("DOT output should not highlight cycles:\n%s", dot)
+	}
+}
+
+func TestWriteDOTCollapsePackages(t *testing.T) {
+	graph := exportTestGraph(t)
+
+	var buf bytes.Buffer
+	opts := protoc.ExportOptions{CollapsePackages: true, HighlightCycles: true}
+	if err := graph.WriteDOT(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+
+	// shop imports billing and billing imports shop: a package-level cycle
+	for _, want := range []string{
+		`"shop" -> "billing" [color=red];`,
+		`"billing" -> "shop" [color=red];`,
+		`"shop" -> "vendor" [style=dashed];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, `"shop" -> "shop"`) {
+		t.Errorf("collapsed graph should not contain self edges:\n%s", dot)
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	graph := exportTestGraph(t)
+
+	var buf bytes.Buffer
+	opts := protoc.ExportOptions{CollapsePackages: true, HighlightCycles: true, HighlightExternal: true}
+	if err := graph.WriteMermaid(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	mermaid := buf.String()
+
+	// Nodes are numbered in sorted order: billing, shop, vendor
+	for _, want := range []string{
+		"graph LR\n",
+		`  n0["billing"]`,
+		"  n1 --> n0\n",
+		"  n1 -.-> n2\n",
+		"  class n2 external\n",
+		"  class n0,n1 cycle\n",
+		"  linkStyle 0,1 stroke:#d00\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Mermaid output missing %q:\n%s", want, mermaid)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	graph := exportTestGraph(t)
+
+	var buf bytes.Buffer
+	opts := protoc.ExportOptions{CollapsePackages: true, HighlightExternal: true}
+	if err := graph.WriteJSON(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Nodes []struct {
+			ID       string   `json:"id"`
+			Files    []string `json:"files"`
+			External bool     `json:"external"`
+			Cycle    bool     `json:"cycle"`
+		} `json:"nodes"`
+		Edges []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+			Kind string `json:"kind"`
+		} `json:"edges"`
+		Cycles [][]string `json:"cycles"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+
+	if len(doc.Nodes) != 3 || doc.Nodes[1].ID != "shop" || len(doc.Nodes[1].Files) != 2 {
+		t.Errorf("unexpected nodes: %+v", doc.Nodes)
+	}
+	if !doc.Nodes[2].External || doc.Nodes[0].External {
+		t.Errorf("only vendor should be external: %+v", doc.Nodes)
+	}
+	if doc.Nodes[0].Cycle {
+		t.Errorf("cycles should not be highlighted: %+v", doc.Nodes)
+	}
+	if len(doc.Edges) != 3 {
+		t.Errorf("unexpected edges: %+v", doc.Edges)
+	}
+	if len(doc.Cycles) != 1 || strings.Join(doc.Cycles[0], ",") != "billing,shop" {
+		t.Errorf("unexpected cycles: %v", doc.Cycles)
+	}
+}
@@ -0,0 +1,291 @@
+package protoc_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// writeProtos creates the given files, keyed by slash-separated path
+// relative to root.
+func writeProtos(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGraph(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspaceDir := filepath.Join(tmpDir, "proto")
+	protoDir := filepath.Join(workspaceDir, "shop")
+	thirdPartyDir := filepath.Join(tmpDir, "third_party")
+
+	writeProtos(t, workspaceDir, map[string]string{
+		"shop/order.proto": `syntax = "proto3";
+package shop;
+import public "shop/item.proto";
+import weak "vendor/money.proto";
+import "missing/nope.proto";
+message Order { repeated Item items = 1; }`,
+		"shop/item.proto": `syntax = "proto3";
+package shop;
+import "common/id.proto";
+message Item { common.Id id = 1; map<string, string> labels = 2; }`,
+		"common/id.proto": `syntax = "proto3";
+// Identifiers shared by all packages.
+package common;
+message Id { string value = 1; }`,
+	})
+	writeProtos(t, thirdPartyDir, map[string]string{
+		"vendor/money.proto": `syntax = "proto3";
+package vendor;
+message Money { int64 units = 1; }`,
+	})
+
+	graph, err := protoc.NewCompiler().
+		WithProtoDir(protoDir).
+		WithProtoWorkSpace(workspaceDir).
+		WithImportPaths(thirdPartyDir).
+		Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+
+	if want := []string{"shop/item.proto", "shop/order.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("Files = %v, want %v", graph.Files, want)
+	}
+	if len(graph.Nodes) != 4 {
+		t.Errorf("expected 4 nodes, got %d", len(graph.Nodes))
+	}
+
+	order := graph.Nodes["shop/order.proto"]
+	if order == nil || order.Package != "shop" {
+		t.Fatalf("unexpected order node: %+v", order)
+	}
+	if len(order.Imports) != 3 || order.Imports[0].Kind != protoc.ImportPublic || order.Imports[1].Kind != protoc.ImportWeak {
+		t.Errorf("unexpected imports: %+v", order.Imports)
+	}
+	if money := graph.Nodes["vendor/money.proto"]; money == nil || !money.External {
+		t.Errorf("vendor/money.proto should be an external node: %+v", money)
+	}
+	if graph.Nodes["common/id.proto"].External {
+		t.Error("common/id.proto is inside the workspace")
+	}
+
+	if len(graph.Unresolved) != 1 || graph.Unresolved[0].Import != "missing/nope.proto" || graph.Unresolved[0].Line != 5 {
+		t.Errorf("unexpected unresolved imports: %+v", graph.Unresolved)
+	}
+	if len(graph.Cycles) != 0 {
+		t.Errorf("unexpected cycles: %v", graph.Cycles)
+	}
+
+	topo, err := graph.TopologicalOrder()
+	if err != nil {
+		t.Fatalf("TopologicalOrder failed: %v", err)
+	}
+	want := []string{"common/id.proto", "vendor/money.proto", "shop/item.proto", "shop/order.proto"}
+	if !reflect.DeepEqual(topo, want) {
+		t.Errorf("TopologicalOrder = %v, want %v", topo, want)
+	}
+
+	closure := graph.Closure("shop/item.proto")
+	if want := []string{"common/id.proto", "shop/item.proto"}; !reflect.DeepEqual(closure, want) {
+		t.Errorf("Closure = %v, want %v", closure, want)
+	}
+	if dependents := graph.Dependents("common/id.proto"); !reflect.DeepEqual(dependents, []string{"shop/item.proto"}) {
+		t.Errorf("Dependents = %v", dependents)
+	}
+}
+
+func TestGraphCycles(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspaceDir := filepath.Join(tmpDir, "proto")
+	protoDir := filepath.Join(workspaceDir, "loop")
+
+	writeProtos(t, workspaceDir, map[string]string{
+		"loop/a.proto": `syntax = "proto3"; import "loop/c.proto";`,
+		"loop/b.proto": `syntax = "proto3"; import "loop/a.proto";`,
+		"loop/c.proto": `syntax = "proto3"; import "loop/b.proto";`,
+		"loop/d.proto": `syntax = "proto3"; import "loop/a.proto";`,
+	})
+
+	graph, err := protoc.NewCompiler().
+		WithProtoDir(protoDir).
+		WithProtoWorkSpace(workspaceDir).
+		Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+
+	want := [][]string{{"loop/a.proto", "loop/b.proto", "loop/c.proto"}}
+	if !reflect.DeepEqual(graph.Cycles, want) {
+		t.Errorf("Cycles = %v, want %v", graph.Cycles, want)
+	}
+	// The error follows the imports, unlike the sorted component
+	wantErr := "import cycle: loop/a.proto -> loop/c.proto -> loop/b.proto -> loop/a.proto"
+	if _, err := graph.TopologicalOrder(); err == nil || err.Error() != wantErr {
+		t.Errorf("error = %v, want %q", err, wantErr)
+	}
+}
+
+func TestGraphErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspaceDir := filepath.Join(tmpDir, "proto")
+	protoDir := filepath.Join(workspaceDir, "bad")
+
+	writeProtos(t, workspaceDir, map[string]string{
+		"bad/bad.proto": "syntax = \"proto3\";\nmessage {",
+	})
+
+	_, err := protoc.NewCompiler().WithProtoWorkSpace(workspaceDir).Graph(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "proto directory not specified") {
+		t.Errorf("expected missing proto directory error, got: %v", err)
+	}
+
+	_, err = protoc.NewCompiler().
+		WithProtoDir(protoDir).
+		WithProtoWorkSpace(workspaceDir).
+		Graph(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "bad.proto:2:9") {
+		t.Errorf("expected parse error with position, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = protoc.NewCompiler().
+		WithProtoDir(protoDir).
+		WithProtoWorkSpace(workspaceDir).
+		Graph(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestGraphQualifiedFieldTypes(t *testing.T) {
+	workspaceDir := t.TempDir()
+	writeProtos(t, workspaceDir, map[string]string{
+		"a/a.proto": `syntax = "proto3";
+package a;
+import "google/protobuf/timestamp.proto";
+message A {
+  .google.protobuf.Timestamp created = 1;
+  oneof when { .google.protobuf.Timestamp at = 2; }
+  map<string, .google.protobuf.Timestamp> times = 3;
+}`,
+	})
+
+	c := protoc.NewCompiler().WithProtoDir(workspaceDir).WithProtoWorkSpace(workspaceDir)
+	graph, err := c.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if imports := graph.Nodes["a/a.proto"].Imports; len(imports) != 1 || imports[0].Path != "google/protobuf/timestamp.proto" {
+		t.Errorf("Imports = %+v", imports)
+	}
+	diags, err := c.Lint(context.Background())
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	for _, d := range diags {
+		if d.Rule == "" {
+			t.Errorf("unexpected syntax error: %v", d)
+		}
+	}
+}
+
+func TestWithRoots(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspaceDir := filepath.Join(tmpDir, "proto")
+	outputDir := filepath.Join(tmpDir, "generated")
+
+	writeProtos(t, workspaceDir, map[string]string{
+		"api/user_service.proto": `syntax = "proto3";
+package api;
+option go_package = "example.com/gen/api";
+import "model/user.proto";
+service UserService { rpc Get(model.User) returns (model.User); }`,
+		"model/user.proto": `syntax = "proto3";
+package model;
+option go_package = "example.com/gen/model";
+message User { string id = 1; }`,
+		"model/unused.proto": `syntax = "proto3";
+package model;
+option go_package = "example.com/gen/model";
+message Unused { string id = 1; }`,
+	})
+
+	compiler := protoc.NewCompiler().
+		WithProtoWorkSpace(workspaceDir).
+		WithOutputDir(outputDir).
+		WithRoots("api/user_service.proto")
+
+	graph, err := compiler.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if want := []string{"api/user_service.proto"}; !reflect.DeepEqual(graph.Files, want) {
+		t.Errorf("Files = %v, want %v", graph.Files, want)
+	}
+	if closure := graph.Closure(graph.Files...); !reflect.DeepEqual(closure, []string{"api/user_service.proto", "model/user.proto"}) {
+		t.Errorf("unexpected closure: %v", closure)
+	}
+
+	// Roots given as filesystem paths resolve to the same files
+	graph, err = protoc.NewCompiler().
+		WithProtoWorkSpace(workspaceDir).
+		WithRoots(filepath.Join(workspaceDir, "model", "user.proto")).
+		Graph(context.Background())
+	if err != nil || !reflect.DeepEqual(graph.Files, []string{"model/user.proto"}) {
+		t.Errorf("unexpected graph for filesystem root: %v, %v", graph, err)
+	}
+
+	_, err = protoc.NewCompiler().
+		WithProtoWorkSpace(workspaceDir).
+		WithRoots("api/missing.proto").
+		Graph(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "root file not found") {
+		t.Errorf("expected root not found error, got: %v", err)
+	}
+
+	outside := filepath.Join(tmpDir, "outside.proto")
+	writeProtos(t, tmpDir, map[string]string{"outside.proto": `syntax = "proto3";`})
+	_, err = protoc.NewCompiler().
+		WithProtoWorkSpace(workspaceDir).
+		WithRoots(outside).
+		Graph(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "must be within workspace directory") {
+		t.Errorf("expected workspace error, got: %v", err)
+	}
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping compilation")
+	}
+	if _, err := exec.LookPath("protoc-gen-go"); err != nil {
+		t.Skip("protoc-gen-go not available, skipping compilation")
+	}
+
+	if _, err := compiler.WithGoOpts("paths=source_relative").Compile(); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	for _, name := range []string{"api/user_service.pb.go", "model/user.pb.go"} {
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected %s to be generated: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "model", "unused.pb.go")); !os.IsNotExist(err) {
+		t.Errorf("model/unused.pb.go should not be generated")
+	}
+}
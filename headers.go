@@ -0,0 +1,228 @@
+package protoc
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// HeaderData is the data a header template set with WithHeader is
+// executed with.
+type HeaderData struct {
+	Path string // Path of the generated file relative to its output directory, with forward slashes
+	Year int    // Current year
+}
+
+// headerRule is a header template for the files matching a pattern.
+type headerRule struct {
+	pattern string
+	text    string
+}
+
+// buildTagRule is a build constraint for the files matching a pattern.
+type buildTagRule struct {
+	pattern string
+	expr    string
+}
+
+// WithHeader prepends a header, such as a license notice, to the generated
+// files whose path relative to their output directory matches pattern.
+// Patterns use path.Match syntax; a pattern without a slash is matched
+// against the file name only, so "*.go" applies to every Go file.
+//
+// The header is a text/template executed with HeaderData, e.g.
+// "Copyright {{.Year}} Example Inc.". Each of its lines is turned into a
+// comment with the line comment syntax of the file, and a blank line
+// separates it from the generated code. Files already starting with the
+// header are left alone, and files of types without line comments, such as
+// JSON, are skipped. When several patterns match a file, the first one
+// set applies.
+func (c *Compiler) WithHeader(pattern, header string) *Compiler {
+	c.headers = append(c.headers, headerRule{pattern: pattern, text: header})
+	return c
+}
+
+// WithBuildTags adds a //go:build constraint, such as "linux && !race", to
+// the generated .go files whose path relative to their output directory
+// matches pattern, with the pattern syntax of WithHeader. A constraint the
+// file already has is replaced rather than duplicated. When several
+// patterns match a file, the first one set applies.
+func (c *Compiler) WithBuildTags(pattern, expr string) *Compiler {
+	c.buildTags = append(c.buildTags, buildTagRule{pattern: pattern, expr: expr})
+	return c
+}
+
+// validateHeaders checks the patterns, header templates and build
+// constraints, so mistakes are reported before protoc runs.
+func (c *compilerImpl) validateHeaders() error {
+	for _, rule := range c.headers {
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return fmt.Errorf("invalid header pattern %q: %w", rule.pattern, err)
+		}
+		if _, err := parseHeader(rule.text); err != nil {
+			return err
+		}
+	}
+	for _, rule := range c.buildTags {
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return fmt.Errorf("invalid build tag pattern %q: %w", rule.pattern, err)
+		}
+		if _, err := constraint.Parse("//go:build " + rule.expr); err != nil {
+			return fmt.Errorf("invalid build constraint %q: %w", rule.expr, err)
+		}
+	}
+	return nil
+}
+
+func parseHeader(text string) (*template.Template, error) {
+	tmpl, err := template.New("header").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse header template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// headerer adds the configured headers and build constraints to files.
+type headerer struct {
+	headers   []*template.Template
+	patterns  []string
+	buildTags []buildTagRule
+	year      int
+}
+
+// newHeaderer prepares the header templates of a validated compiler, or
+// returns nil if there is nothing to add.
+func (c *compilerImpl) newHeaderer() (*headerer, error) {
+	if len(c.headers) == 0 && len(c.buildTags) == 0 {
+		return nil, nil
+	}
+	h := &headerer{buildTags: c.buildTags, year: time.Now().Year()}
+	for _, rule := range c.headers {
+		tmpl, err := parseHeader(rule.text)
+		if err != nil {
+			return nil, err
+		}
+		h.headers = append(h.headers, tmpl)
+		h.patterns = append(h.patterns, rule.pattern)
+	}
+	return h, nil
+}
+
+// Process adds the header and build constraint matching the output path
+// name to content.
+func (h *headerer) Process(name string, content []byte) ([]byte, error) {
+	var header []byte
+	for i, pattern := range h.patterns {
+		if !matchOutputPath(pattern, name) {
+			continue
+		}
+		prefix := commentPrefix(name)
+		if prefix == "" {
+			break
+		}
+		var buf bytes.Buffer
+		if err := h.headers[i].Execute(&buf, HeaderData{Path: name, Year: h.year}); err != nil {
+			return nil, fmt.Errorf("execute header template: %w", err)
+		}
+		header = commentLines(prefix, buf.String())
+		break
+	}
+
+	rest := content
+	if header != nil {
+		rest = bytes.TrimPrefix(content, header)
+	}
+	if strings.HasSuffix(name, ".go") {
+		for _, rule := range h.buildTags {
+			if matchOutputPath(rule.pattern, name) {
+				expr, _ := constraint.Parse("//go:build " + rule.expr)
+				rest = setBuildConstraint(rest, "//go:build "+expr.String())
+				break
+			}
+		}
+	}
+	return append(header, rest...), nil
+}
+
+// matchOutputPath reports whether the output path name matches pattern,
+// comparing the file name only for patterns without a slash.
+func matchOutputPath(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// commentPrefix returns the line comment syntax of a file type, or "" if
+// it has none.
+func commentPrefix(name string) string {
+	switch path.Ext(name) {
+	case ".go", ".java", ".kt", ".swift", ".dart", ".ts", ".js", ".mjs", ".cjs",
+		".c", ".cc", ".cpp", ".h", ".hpp", ".cs", ".rs", ".scala", ".proto":
+		return "//"
+	case ".py", ".pyi", ".rb", ".rbs", ".sh", ".yaml", ".yml", ".toml":
+		return "#"
+	}
+	return ""
+}
+
+// commentLines turns text into line comments followed by a blank line.
+func commentLines(prefix, text string) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		buf.WriteString(prefix)
+		if line != "" {
+			buf.WriteString(" " + line)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// setBuildConstraint sets the //go:build line of a Go file. An existing one
+// in the comments before the package clause is replaced; otherwise the line
+// is added at the top, followed by a blank line as the go command requires.
+func setBuildConstraint(src []byte, line string) []byte {
+	lines := strings.SplitAfter(string(src), "\n")
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if constraint.IsGoBuild(trimmed) {
+			lines[i] = line + "\n"
+			return []byte(strings.Join(lines, ""))
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "//") {
+			break
+		}
+	}
+	return append([]byte(line+"\n\n"), src...)
+}
+
+// outputPath returns the path of a generated file relative to the output
+// directory containing it, with forward slashes.
+func (c *compilerImpl) outputPath(file string) string {
+	name := filepath.Base(file)
+	best := -1
+	for _, dir := range c.outputDirs() {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(absDir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(absDir) > best {
+			best = len(absDir)
+			name = filepath.ToSlash(rel)
+		}
+	}
+	return name
+}
@@ -0,0 +1,491 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Diagnostic is a problem found in a .proto file. Its String form uses the
+// file:line:column layout of protoc's own error messages, so editors and CI
+// tools can parse both the same way.
+type Diagnostic struct {
+	File     string   `json:"file"` // Path relative to the workspace
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Rule     string   `json:"rule,omitempty"` // Lint rule; empty for syntax errors
+	Message  string   `json:"message"`
+	Severity Severity `json:"severity,omitempty"` // Set for protoc diagnostics; empty for rule findings
+}
+
+// Severity is how serious a Diagnostic is.
+type Severity string
+
+const (
+	// SeverityError marks an error that failed the compilation.
+	SeverityError Severity = "error"
+	// SeverityWarning marks a protoc warning, which does not fail it.
+	SeverityWarning Severity = "warning"
+)
+
+// String returns the diagnostic as "file:line:column: message (RULE)",
+// with "warning: " before the message of a warning. The position is left
+// out if it is unknown, e.g. for a deleted file.
+func (d Diagnostic) String() string {
+	msg := d.Message
+	if d.Severity == SeverityWarning {
+		msg = "warning: " + msg
+	}
+	s := fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, msg)
+	if d.Line == 0 {
+		s = fmt.Sprintf("%s: %s", d.File, msg)
+	}
+	if d.Rule != "" {
+		s += " (" + d.Rule + ")"
+	}
+	return s
+}
+
+// sortDiagnostics sorts diagnostics by position and rule.
+func sortDiagnostics(diags []Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return a.Rule < b.Rule
+	})
+}
+
+// Lint rules.
+const (
+	// LintPackageDefined requires every file to declare a package.
+	LintPackageDefined = "PACKAGE_DEFINED"
+	// LintPackageDirectoryMatch requires the package to match the file's
+	// directory relative to the workspace, e.g. package acme.user.v1 in
+	// acme/user/v1.
+	LintPackageDirectoryMatch = "PACKAGE_DIRECTORY_MATCH"
+	// LintGoPackageDefined requires the go_package option.
+	LintGoPackageDefined = "GO_PACKAGE_DEFINED"
+	// LintGoPackageConsistent requires files of the same package to share
+	// the same go_package import path.
+	LintGoPackageConsistent = "GO_PACKAGE_CONSISTENT"
+	// LintEnumZeroValueSuffix requires the zero value of enums to end in
+	// _UNSPECIFIED.
+	LintEnumZeroValueSuffix = "ENUM_ZERO_VALUE_SUFFIX"
+	// LintFieldLowerSnakeCase requires field names in lower_snake_case.
+	LintFieldLowerSnakeCase = "FIELD_LOWER_SNAKE_CASE"
+	// LintServicePascalCase requires service names in PascalCase.
+	LintServicePascalCase = "SERVICE_PASCAL_CASE"
+	// LintServiceSuffix requires service names to end in Service.
+	LintServiceSuffix = "SERVICE_SUFFIX"
+	// LintRPCPascalCase requires RPC names in PascalCase.
+	LintRPCPascalCase = "RPC_PASCAL_CASE"
+	// LintRPCRequestResponseUnique requires every message to be used as
+	// the request or response of at most one RPC.
+	LintRPCRequestResponseUnique = "RPC_REQUEST_RESPONSE_UNIQUE"
+)
+
+// lintRules lists all rules in the order they run.
+var lintRules = []string{
+	LintPackageDefined,
+	LintPackageDirectoryMatch,
+	LintGoPackageDefined,
+	LintGoPackageConsistent,
+	LintEnumZeroValueSuffix,
+	LintFieldLowerSnakeCase,
+	LintServicePascalCase,
+	LintServiceSuffix,
+	LintRPCPascalCase,
+	LintRPCRequestResponseUnique,
+}
+
+// LintRules returns the names of all lint rules.
+func LintRules() []string {
+	return append([]string(nil), lintRules...)
+}
+
+// isLintRule reports whether name is a lint rule.
+func isLintRule(name string) bool {
+	return containsString(lintRules, name)
+}
+
+// Ignore comments. "protoc-go:lint:ignore RULE" on the line before a
+// declaration, or at the end of its line, silences RULE for that line;
+// "protoc-go:lint:ignore-file RULE" silences it for the whole file.
+// Several rules may be separated by commas or spaces; the rules end at the
+// first word that is not a rule, which starts the reason.
+const (
+	lintIgnore     = "protoc-go:lint:ignore"
+	lintIgnoreFile = "protoc-go:lint:ignore-file"
+)
+
+var (
+	lowerSnakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+	pascalCase     = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+)
+
+// Lint checks the discovered files, or the roots and the workspace files
+// they import, against the lint rules and returns the findings sorted by
+// position. All rules run unless selected with WithLintRules or disabled
+// with WithLintExcept. Files that fail to parse are reported as
+// diagnostics without a rule. No plugins are run and protoc is not
+// required.
+func (c *Compiler) Lint(ctx context.Context) ([]Diagnostic, error) {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if err := impl.validateSources(); err != nil {
+		return nil, err
+	}
+	return impl.lint()
+}
+
+// enabledLintRules returns the rules to run.
+func (c *compilerImpl) enabledLintRules() (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	use := c.lintRules
+	if len(use) == 0 {
+		use = lintRules
+	}
+	for _, rule := range use {
+		if !isLintRule(rule) {
+			return nil, fmt.Errorf("unknown lint rule %q", rule)
+		}
+		enabled[rule] = true
+	}
+	for _, rule := range c.lintExcept {
+		if !isLintRule(rule) {
+			return nil, fmt.Errorf("unknown lint rule %q", rule)
+		}
+		delete(enabled, rule)
+	}
+	return enabled, nil
+}
+
+// lintFile is a parsed file being linted.
+type lintFile struct {
+	name   string
+	parsed *protoFile
+}
+
+// lint runs the enabled rules over the compiled files.
+func (c *compilerImpl) lint() ([]Diagnostic, error) {
+	enabled, err := c.enabledLintRules()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := c.collectFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{enabled: enabled}
+	var files []*lintFile
+	for _, p := range paths {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, err := c.importName(p)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseProtoFile(p)
+		if err != nil {
+			if perr, ok := err.(*parseError); ok {
+				l.diags = append(l.diags, Diagnostic{File: name, Line: perr.pos.line, Column: perr.pos.col, Message: perr.msg})
+				continue
+			}
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		files = append(files, &lintFile{name: name, parsed: parsed})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	for _, f := range files {
+		l.file(f)
+	}
+	l.goPackages(files)
+	l.rpcTypes(files)
+
+	diags := l.diags[:0]
+	ignored := make(map[string]*lintIgnores)
+	for _, f := range files {
+		ignored[f.name] = parseLintIgnores(f.parsed)
+	}
+	for _, d := range l.diags {
+		if ig := ignored[d.File]; ig != nil && d.Rule != "" && ig.ignores(d.Rule, d.Line) {
+			continue
+		}
+		diags = append(diags, d)
+	}
+
+	sortDiagnostics(diags)
+	return diags, nil
+}
+
+// linter collects diagnostics from the enabled rules.
+type linter struct {
+	enabled map[string]bool
+	diags   []Diagnostic
+}
+
+func (l *linter) report(file string, pos position, rule, format string, args ...interface{}) {
+	if !l.enabled[rule] {
+		return
+	}
+	if pos.line == 0 {
+		pos = position{1, 1}
+	}
+	l.diags = append(l.diags, Diagnostic{File: file, Line: pos.line, Column: pos.col, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// file runs the rules that look at a single file.
+func (l *linter) file(f *lintFile) {
+	p := f.parsed
+	if p.pkg == "" {
+		l.report(f.name, position{}, LintPackageDefined, "file does not declare a package")
+	} else if dir, want := path.Dir(f.name), strings.ReplaceAll(p.pkg, ".", "/"); dir != want {
+		if dir == "." {
+			dir = "the workspace root"
+		}
+		l.report(f.name, p.pkgPos, LintPackageDirectoryMatch, "package %q should be in directory %q, not %s", p.pkg, want, dir)
+	}
+
+	if _, ok := p.option("go_package"); !ok {
+		l.report(f.name, p.pkgPos, LintGoPackageDefined, "option go_package is not set")
+	}
+
+	for _, msg := range p.messages {
+		l.message(f.name, msg)
+	}
+	for _, enum := range p.enums {
+		l.enum(f.name, enum)
+	}
+	for _, ext := range p.extends {
+		for _, field := range ext.fields {
+			l.field(f.name, field)
+		}
+		for _, msg := range ext.messages {
+			l.message(f.name, msg)
+		}
+	}
+
+	for _, svc := range p.services {
+		if !pascalCase.MatchString(svc.name) {
+			l.report(f.name, svc.pos, LintServicePascalCase, "service name %q should be PascalCase", svc.name)
+		}
+		if !strings.HasSuffix(svc.name, "Service") {
+			l.report(f.name, svc.pos, LintServiceSuffix, "service name %q should end in \"Service\"", svc.name)
+		}
+		for _, method := range svc.methods {
+			if !pascalCase.MatchString(method.name) {
+				l.report(f.name, method.pos, LintRPCPascalCase, "RPC name %q should be PascalCase", method.name)
+			}
+		}
+	}
+}
+
+// message runs the field and enum rules over a message and its nested
+// declarations.
+func (l *linter) message(file string, msg *protoMessage) {
+	if msg.isMapEntry {
+		return
+	}
+	for _, field := range msg.fields {
+		l.field(file, field)
+	}
+	for _, nested := range msg.messages {
+		l.message(file, nested)
+	}
+	for _, enum := range msg.enums {
+		l.enum(file, enum)
+	}
+	for _, ext := range msg.extends {
+		for _, field := range ext.fields {
+			l.field(file, field)
+		}
+	}
+}
+
+func (l *linter) field(file string, field *protoField) {
+	if !field.group && !lowerSnakeCase.MatchString(field.name) {
+		l.report(file, field.pos, LintFieldLowerSnakeCase, "field name %q should be lower_snake_case", field.name)
+	}
+}
+
+func (l *linter) enum(file string, enum *protoEnum) {
+	for _, value := range enum.values {
+		if value.number == 0 && !strings.HasSuffix(value.name, "_UNSPECIFIED") {
+			l.report(file, value.pos, LintEnumZeroValueSuffix, "enum zero value %q should end in \"_UNSPECIFIED\"", value.name)
+		}
+	}
+}
+
+// goPackages reports files whose go_package import path differs from the
+// other files of the same package.
+func (l *linter) goPackages(files []*lintFile) {
+	first := make(map[string]*lintFile)
+	for _, f := range files {
+		goPackage, ok := f.parsed.option("go_package")
+		if !ok || f.parsed.pkg == "" {
+			continue
+		}
+		importPath, _, _ := strings.Cut(goPackage, ";")
+		ref, seen := first[f.parsed.pkg]
+		if !seen {
+			first[f.parsed.pkg] = f
+			continue
+		}
+		refPackage, _ := ref.parsed.option("go_package")
+		refPath, _, _ := strings.Cut(refPackage, ";")
+		if importPath != refPath {
+			l.report(f.name, goPackagePos(f.parsed), LintGoPackageConsistent,
+				"go_package %q differs from %q in %s of the same package %s", importPath, refPath, ref.name, f.parsed.pkg)
+		}
+	}
+}
+
+// goPackagePos returns the position of the go_package option.
+func goPackagePos(f *protoFile) position {
+	for _, opt := range f.options {
+		if opt.name == "go_package" {
+			return opt.pos
+		}
+	}
+	return f.pkgPos
+}
+
+// rpcTypes reports messages used as the request or response of more than
+// one RPC. Only messages defined in the linted files are considered, so
+// shared types such as google.protobuf.Empty are allowed.
+func (l *linter) rpcTypes(files []*lintFile) {
+	known := make(map[string]bool)
+	var collect func(prefix string, msgs []*protoMessage)
+	collect = func(prefix string, msgs []*protoMessage) {
+		for _, msg := range msgs {
+			name := prefix + msg.name
+			known[name] = true
+			collect(name+".", msg.messages)
+		}
+	}
+	for _, f := range files {
+		prefix := ""
+		if f.parsed.pkg != "" {
+			prefix = f.parsed.pkg + "."
+		}
+		collect(prefix, f.parsed.messages)
+	}
+
+	firstUse := make(map[string]string)
+	for _, f := range files {
+		for _, svc := range f.parsed.services {
+			for _, method := range svc.methods {
+				rpc := svc.name + "." + method.name
+				for _, typ := range []string{method.input, method.output} {
+					name := resolveTypeName(typ, f.parsed.pkg, known)
+					if !known[name] {
+						continue
+					}
+					if other, ok := firstUse[name]; ok {
+						l.report(f.name, method.pos, LintRPCRequestResponseUnique,
+							"message %s is already used by RPC %s", name, other)
+						continue
+					}
+					firstUse[name] = rpc
+				}
+			}
+		}
+	}
+}
+
+// resolveTypeName resolves a type reference from a file in package pkg to
+// a fully qualified name without the leading dot, searching the enclosing
+// package scopes like protoc does.
+func resolveTypeName(name, pkg string, known map[string]bool) string {
+	if strings.HasPrefix(name, ".") {
+		return name[1:]
+	}
+	scope := pkg
+	for {
+		candidate := name
+		if scope != "" {
+			candidate = scope + "." + name
+		}
+		if known[candidate] {
+			return candidate
+		}
+		if scope == "" {
+			return name
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// lintIgnores are the ignore comments of a file.
+type lintIgnores struct {
+	file  map[string]bool
+	lines map[int]map[string]bool
+}
+
+// parseLintIgnores collects the ignore comments of a file. A comment
+// applies to the line it ends on and the line after it.
+func parseLintIgnores(f *protoFile) *lintIgnores {
+	ig := &lintIgnores{file: make(map[string]bool), lines: make(map[int]map[string]bool)}
+	for _, comment := range f.comments {
+		text := strings.TrimSpace(comment.text)
+		var rules string
+		fileWide := false
+		switch {
+		case strings.HasPrefix(text, lintIgnoreFile):
+			rules, fileWide = strings.TrimPrefix(text, lintIgnoreFile), true
+		case strings.HasPrefix(text, lintIgnore):
+			rules = strings.TrimPrefix(text, lintIgnore)
+		default:
+			continue
+		}
+
+		endLine := comment.pos.line + strings.Count(comment.text, "\n")
+		fields := strings.FieldsFunc(rules, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		for _, rule := range fields {
+			if !isLintRule(rule) {
+				break
+			}
+			if fileWide {
+				ig.file[rule] = true
+				continue
+			}
+			for _, line := range []int{endLine, endLine + 1} {
+				if ig.lines[line] == nil {
+					ig.lines[line] = make(map[string]bool)
+				}
+				ig.lines[line][rule] = true
+			}
+		}
+	}
+	return ig
+}
+
+// ignores reports whether rule is silenced on line.
+func (ig *lintIgnores) ignores(rule string, line int) bool {
+	return ig.file[rule] || ig.lines[line][rule]
+}
@@ -0,0 +1,142 @@
+package protoc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestLint(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"acme/user/v1/user.proto": `syntax = "proto3";
+package acme.user.v1;
+option go_package = "example.com/gen/acme/user/v1;userv1";
+
+enum Status {
+  ACTIVE = 0;
+  STATUS_DISABLED = 1;
+}
+
+message GetUserRequest {
+  string userId = 1;
+  map<string, string> labels = 2;
+}
+
+message User {
+  string display_name = 1;
+}
+
+service users {
+  rpc GetUser(GetUserRequest) returns (User);
+  rpc get_user_v2(.acme.user.v1.GetUserRequest) returns (User);
+}
+`,
+		"acme/user/v1/admin.proto": `syntax = "proto3";
+package acme.user.v1;
+option go_package = "example.com/gen/acme/admin";
+`,
+		"misplaced.proto": `syntax = "proto3";
+package acme.other;
+`,
+	})
+
+	diags, err := protoc.NewCompiler().
+		WithProtoDir(tmpDir).
+		WithProtoWorkSpace(tmpDir).
+		Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`acme/user/v1/user.proto:3:8: go_package "example.com/gen/acme/user/v1" differs from "example.com/gen/acme/admin" in acme/user/v1/admin.proto of the same package acme.user.v1 (GO_PACKAGE_CONSISTENT)`,
+		`acme/user/v1/user.proto:6:3: enum zero value "ACTIVE" should end in "_UNSPECIFIED" (ENUM_ZERO_VALUE_SUFFIX)`,
+		`acme/user/v1/user.proto:11:10: field name "userId" should be lower_snake_case (FIELD_LOWER_SNAKE_CASE)`,
+		`acme/user/v1/user.proto:19:9: service name "users" should be PascalCase (SERVICE_PASCAL_CASE)`,
+		`acme/user/v1/user.proto:19:9: service name "users" should end in "Service" (SERVICE_SUFFIX)`,
+		`acme/user/v1/user.proto:21:7: RPC name "get_user_v2" should be PascalCase (RPC_PASCAL_CASE)`,
+		`acme/user/v1/user.proto:21:7: message acme.user.v1.GetUserRequest is already used by RPC users.GetUser (RPC_REQUEST_RESPONSE_UNIQUE)`,
+		`acme/user/v1/user.proto:21:7: message acme.user.v1.User is already used by RPC users.GetUser (RPC_REQUEST_RESPONSE_UNIQUE)`,
+		`misplaced.proto:2:9: option go_package is not set (GO_PACKAGE_DEFINED)`,
+		`misplaced.proto:2:9: package "acme.other" should be in directory "acme/other", not the workspace root (PACKAGE_DIRECTORY_MATCH)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLintIgnoreComments(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"legacy.proto": `// protoc-go:lint:ignore-file PACKAGE_DIRECTORY_MATCH, GO_PACKAGE_DEFINED
+syntax = "proto3";
+package legacy;
+
+message Legacy {
+  // protoc-go:lint:ignore FIELD_LOWER_SNAKE_CASE kept for compatibility
+  string oldName = 1;
+  string newName = 2;
+  string otherName = 3; // protoc-go:lint:ignore FIELD_LOWER_SNAKE_CASE
+}
+
+message Empty {}
+
+// protoc-go:lint:ignore SERVICE_SUFFIX RPC_PASCAL_CASE legacy API
+service Legacies { rpc get(Legacy) returns (Empty); }
+`,
+	})
+
+	diags, err := protoc.NewCompiler().
+		WithProtoDir(tmpDir).
+		WithProtoWorkSpace(tmpDir).
+		Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Line != 8 || diags[0].Rule != protoc.LintFieldLowerSnakeCase {
+		t.Errorf("expected only newName to be reported, got %v", diags)
+	}
+}
+
+func TestLintRuleSelection(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"a.proto": `syntax = "proto3"; enum E { A = 0; } service s {}`,
+		"b.proto": `syntax = "proto3"; message M {`,
+	})
+	c := protoc.NewCompiler().WithProtoDir(tmpDir).WithProtoWorkSpace(tmpDir)
+
+	diags, err := c.WithLintRules(protoc.LintServiceSuffix, protoc.LintServicePascalCase, protoc.LintPackageDefined).
+		WithLintExcept(protoc.LintPackageDefined).
+		Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, d := range diags {
+		rules = append(rules, d.Rule)
+	}
+	// b.proto does not parse and is reported without a rule.
+	if want := "SERVICE_PASCAL_CASE SERVICE_SUFFIX "; strings.Join(rules, " ") != want {
+		t.Errorf("rules = %q, want %q (%v)", strings.Join(rules, " "), want, diags)
+	}
+	if last := diags[len(diags)-1]; last.File != "b.proto" || last.Message == "" {
+		t.Errorf("expected a syntax error for b.proto, got %v", last)
+	}
+
+	_, err = protoc.NewCompiler().
+		WithProtoDir(tmpDir).
+		WithProtoWorkSpace(tmpDir).
+		WithLintExcept("NO_SUCH_RULE").
+		Lint(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `unknown lint rule "NO_SUCH_RULE"`) {
+		t.Errorf("expected unknown rule error, got: %v", err)
+	}
+}
@@ -0,0 +1,706 @@
+package protoc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// position is a 1-based line and column within a .proto source file.
+type position struct {
+	line int
+	col  int
+}
+
+// protoFile is the parsed form of a single .proto source file. It covers the
+// parts this package needs to reason about sources without invoking
+// protoc: the package, imports and options, and the declarations parsed in
+// parser_decl.go.
+type protoFile struct {
+	pkg      string
+	pkgPos   position
+	imports  []protoImport
+	options  []protoOption
+	messages []*protoMessage
+	enums    []*protoEnum
+	services []*protoService
+	extends  []*protoExtend
+	comments []protoComment
+}
+
+// protoImport is an import statement.
+type protoImport struct {
+	path string
+	kind ImportKind
+	pos  position
+}
+
+// protoOption is an option assignment. Values keep their literal form, with
+// string literals unquoted and aggregate values kept as raw text.
+type protoOption struct {
+	name  string
+	value string
+	pos   position
+}
+
+// protoComment is a single comment with its starting position.
+type protoComment struct {
+	text string
+	pos  position
+}
+
+// option returns the value of the named option and whether it was set.
+func (f *protoFile) option(name string) (string, bool) {
+	return findOption(f.options, name)
+}
+
+func findOption(options []protoOption, name string) (string, bool) {
+	for _, opt := range options {
+		if opt.name == name {
+			return opt.value, true
+		}
+	}
+	return "", false
+}
+
+// parseError is a syntax error in a .proto file. Its message uses the same
+// file:line:column layout protoc uses for its own diagnostics.
+type parseError struct {
+	file string
+	pos  position
+	msg  string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.file, e.pos.line, e.pos.col, e.msg)
+}
+
+// parseProtoFile reads and parses the .proto file at path.
+func parseProtoFile(path string) (*protoFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProto(path, data)
+}
+
+// parseProto parses .proto source. The path is only used for error messages.
+func parseProto(path string, data []byte) (*protoFile, error) {
+	lex := &lexer{file: path, src: string(data), line: 1, col: 1}
+	toks, err := lex.tokenize()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{file: path, toks: toks}
+	f := &protoFile{comments: lex.comments}
+	if err := p.parseFile(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifier, symbol or number text; decoded value for strings
+	pos  position
+}
+
+type lexer struct {
+	file     string
+	src      string
+	off      int
+	line     int
+	col      int
+	comments []protoComment
+}
+
+func (l *lexer) errorf(pos position, format string, args ...interface{}) error {
+	return &parseError{file: l.file, pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *lexer) advance() byte {
+	ch := l.src[l.off]
+	l.off++
+	if ch == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return ch
+}
+
+func (l *lexer) tokenize() ([]token, error) {
+	var toks []token
+	for {
+		// Skip whitespace and collect comments.
+		for l.off < len(l.src) {
+			ch := l.peek(0)
+			if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == '\f' || ch == '\v' {
+				l.advance()
+				continue
+			}
+			if ch == '/' && (l.peek(1) == '/' || l.peek(1) == '*') {
+				pos := position{l.line, l.col}
+				text, err := l.readComment()
+				if err != nil {
+					return nil, err
+				}
+				l.comments = append(l.comments, protoComment{text: text, pos: pos})
+				continue
+			}
+			break
+		}
+
+		pos := position{l.line, l.col}
+		if l.off >= len(l.src) {
+			toks = append(toks, token{kind: tokEOF, pos: pos})
+			return toks, nil
+		}
+
+		tok, err := l.next(pos)
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func (l *lexer) readComment() (string, error) {
+	start := position{l.line, l.col}
+	l.advance()
+	if l.advance() == '/' {
+		begin := l.off
+		for l.off < len(l.src) && l.peek(0) != '\n' {
+			l.advance()
+		}
+		return strings.TrimSpace(l.src[begin:l.off]), nil
+	}
+	begin := l.off
+	for l.off < len(l.src) {
+		if l.peek(0) == '*' && l.peek(1) == '/' {
+			text := l.src[begin:l.off]
+			l.advance()
+			l.advance()
+			return strings.TrimSpace(text), nil
+		}
+		l.advance()
+	}
+	return "", l.errorf(start, "unterminated block comment")
+}
+
+func isLetter(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func (l *lexer) next(pos position) (token, error) {
+	ch := l.peek(0)
+	switch {
+	case isLetter(ch):
+		begin := l.off
+		l.advance()
+		for l.off < len(l.src) && (isLetter(l.peek(0)) || isDigit(l.peek(0))) {
+			l.advance()
+		}
+		return token{kind: tokIdent, text: l.src[begin:l.off], pos: pos}, nil
+
+	case isDigit(ch) || (ch == '.' && isDigit(l.peek(1))):
+		begin := l.off
+		for l.off < len(l.src) {
+			c := l.peek(0)
+			if isLetter(c) || isDigit(c) || c == '.' {
+				l.advance()
+				continue
+			}
+			if (c == '+' || c == '-') && (l.src[l.off-1] == 'e' || l.src[l.off-1] == 'E') &&
+				!strings.HasPrefix(strings.ToLower(l.src[begin:l.off]), "0x") {
+				l.advance()
+				continue
+			}
+			break
+		}
+		return token{kind: tokNumber, text: l.src[begin:l.off], pos: pos}, nil
+
+	case ch == '"' || ch == '\'':
+		return l.readString(pos)
+	}
+
+	l.advance()
+	return token{kind: tokSymbol, text: string(ch), pos: pos}, nil
+}
+
+func (l *lexer) readString(pos position) (token, error) {
+	quote := l.advance()
+	var sb strings.Builder
+	for {
+		if l.off >= len(l.src) || l.peek(0) == '\n' {
+			return token{}, l.errorf(pos, "unterminated string literal")
+		}
+		ch := l.advance()
+		if ch == quote {
+			break
+		}
+		if ch != '\\' {
+			sb.WriteByte(ch)
+			continue
+		}
+		if l.off >= len(l.src) {
+			return token{}, l.errorf(pos, "unterminated string literal")
+		}
+		esc := l.advance()
+		switch esc {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(esc)
+		case 'x', 'X':
+			n := 0
+			val := 0
+			for n < 2 && isHexDigit(l.peek(0)) {
+				val = val*16 + hexValue(l.advance())
+				n++
+			}
+			if n == 0 {
+				return token{}, l.errorf(pos, "invalid hex escape in string literal")
+			}
+			sb.WriteByte(byte(val))
+		case 'u', 'U':
+			size := 4
+			if esc == 'U' {
+				size = 8
+			}
+			val := 0
+			for i := 0; i < size; i++ {
+				if !isHexDigit(l.peek(0)) {
+					return token{}, l.errorf(pos, "invalid unicode escape in string literal")
+				}
+				val = val*16 + hexValue(l.advance())
+			}
+			var buf [utf8.UTFMax]byte
+			n := utf8.EncodeRune(buf[:], rune(val))
+			sb.Write(buf[:n])
+		default:
+			if esc >= '0' && esc <= '7' {
+				val := int(esc - '0')
+				for i := 0; i < 2 && l.peek(0) >= '0' && l.peek(0) <= '7'; i++ {
+					val = val*8 + int(l.advance()-'0')
+				}
+				sb.WriteByte(byte(val))
+				continue
+			}
+			return token{}, l.errorf(pos, "invalid escape sequence \\%c in string literal", esc)
+		}
+	}
+	return token{kind: tokString, text: sb.String(), pos: pos}, nil
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func hexValue(ch byte) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	default:
+		return int(ch-'A') + 10
+	}
+}
+
+type parser struct {
+	file string
+	toks []token
+	pos  int
+}
+
+func (p *parser) cur() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(pos position, format string, args ...interface{}) error {
+	return &parseError{file: p.file, pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) unexpected(expected string) error {
+	tok := p.cur()
+	if tok.kind == tokEOF {
+		return p.errorf(tok.pos, "unexpected end of file, expected %s", expected)
+	}
+	return p.errorf(tok.pos, "unexpected %q, expected %s", tok.text, expected)
+}
+
+func (p *parser) isSymbol(sym string) bool {
+	tok := p.cur()
+	return tok.kind == tokSymbol && tok.text == sym
+}
+
+func (p *parser) isKeyword(word string) bool {
+	tok := p.cur()
+	return tok.kind == tokIdent && tok.text == word
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.isSymbol(sym) {
+		return p.unexpected(fmt.Sprintf("%q", sym))
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectIdent(what string) (token, error) {
+	if p.cur().kind != tokIdent {
+		return token{}, p.unexpected(what)
+	}
+	return p.next(), nil
+}
+
+// startsName reports whether tok can begin a (possibly qualified) name.
+func startsName(tok token) bool {
+	return tok.kind == tokIdent || (tok.kind == tokSymbol && tok.text == ".")
+}
+
+// expectName reads a possibly qualified name such as "foo.Bar" or
+// ".foo.Bar". Whitespace and comments between the parts are allowed, as
+// protoc allows them.
+func (p *parser) expectName(what string) (token, error) {
+	tok := p.cur()
+	var sb strings.Builder
+	if p.isSymbol(".") {
+		p.next()
+		sb.WriteString(".")
+	}
+	for {
+		ident, err := p.expectIdent(what)
+		if err != nil {
+			return token{}, err
+		}
+		sb.WriteString(ident.text)
+		if !p.isSymbol(".") {
+			break
+		}
+		p.next()
+		sb.WriteString(".")
+	}
+	tok.kind = tokIdent
+	tok.text = sb.String()
+	return tok, nil
+}
+
+// expectString reads one or more adjacent string literals, which protobuf
+// concatenates.
+func (p *parser) expectString(what string) (token, error) {
+	if p.cur().kind != tokString {
+		return token{}, p.unexpected(what)
+	}
+	tok := p.next()
+	for p.cur().kind == tokString {
+		tok.text += p.next().text
+	}
+	return tok, nil
+}
+
+func (p *parser) expectInt(what string) (int, position, error) {
+	pos := p.cur().pos
+	neg := false
+	if p.isSymbol("-") {
+		p.next()
+		neg = true
+	}
+	if p.cur().kind != tokNumber {
+		return 0, pos, p.unexpected(what)
+	}
+	tok := p.next()
+	val, err := strconv.ParseInt(tok.text, 0, 64)
+	if err != nil {
+		return 0, pos, p.errorf(tok.pos, "invalid integer %q", tok.text)
+	}
+	if neg {
+		val = -val
+	}
+	return int(val), pos, nil
+}
+
+func (p *parser) skipEmpty() bool {
+	if p.isSymbol(";") {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseFile(f *protoFile) error {
+	for p.cur().kind != tokEOF {
+		if p.skipEmpty() {
+			continue
+		}
+		tok := p.cur()
+		if tok.kind != tokIdent {
+			return p.unexpected("top-level declaration")
+		}
+		var err error
+		switch tok.text {
+		case "syntax", "edition":
+			err = p.parseSyntax()
+		case "package":
+			err = p.parsePackage(f)
+		case "import":
+			err = p.parseImport(f)
+		case "option":
+			var opt protoOption
+			opt, err = p.parseOptionStatement()
+			f.options = append(f.options, opt)
+		case "message":
+			var msg *protoMessage
+			msg, err = p.parseMessage()
+			f.messages = append(f.messages, msg)
+		case "enum":
+			var enum *protoEnum
+			enum, err = p.parseEnum()
+			f.enums = append(f.enums, enum)
+		case "service":
+			var svc *protoService
+			svc, err = p.parseService()
+			f.services = append(f.services, svc)
+		case "extend":
+			var ext *protoExtend
+			ext, err = p.parseExtend()
+			f.extends = append(f.extends, ext)
+		default:
+			return p.unexpected("top-level declaration")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseSyntax() error {
+	kw := p.next()
+	if err := p.expectSymbol("="); err != nil {
+		return err
+	}
+	val, err := p.expectString("syntax version")
+	if err != nil {
+		return err
+	}
+	if kw.text == "syntax" && val.text != "proto2" && val.text != "proto3" {
+		return p.errorf(val.pos, "unrecognized syntax %q", val.text)
+	}
+	return p.expectSymbol(";")
+}
+
+func (p *parser) parsePackage(f *protoFile) error {
+	p.next()
+	name, err := p.expectName("package name")
+	if err != nil {
+		return err
+	}
+	if f.pkg != "" {
+		return p.errorf(name.pos, "multiple package definitions")
+	}
+	f.pkg = name.text
+	f.pkgPos = name.pos
+	return p.expectSymbol(";")
+}
+
+func (p *parser) parseImport(f *protoFile) error {
+	kw := p.next()
+	imp := protoImport{kind: ImportDefault, pos: kw.pos}
+	if p.isKeyword("public") {
+		p.next()
+		imp.kind = ImportPublic
+	} else if p.isKeyword("weak") {
+		p.next()
+		imp.kind = ImportWeak
+	}
+	path, err := p.expectString("import path")
+	if err != nil {
+		return err
+	}
+	imp.path = path.text
+	f.imports = append(f.imports, imp)
+	return p.expectSymbol(";")
+}
+
+// parseOptionStatement parses "option name = value;".
+func (p *parser) parseOptionStatement() (protoOption, error) {
+	p.next()
+	opt, err := p.parseOption()
+	if err != nil {
+		return opt, err
+	}
+	return opt, p.expectSymbol(";")
+}
+
+// parseOption parses "name = value" as used by option statements and
+// compact options.
+func (p *parser) parseOption() (protoOption, error) {
+	opt := protoOption{pos: p.cur().pos}
+	var name strings.Builder
+	for {
+		if p.isSymbol("(") {
+			p.next()
+			ident, err := p.expectName("option name")
+			if err != nil {
+				return opt, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return opt, err
+			}
+			name.WriteString("(" + ident.text + ")")
+		} else {
+			ident, err := p.expectIdent("option name")
+			if err != nil {
+				return opt, err
+			}
+			name.WriteString(ident.text)
+		}
+		if !p.isSymbol(".") {
+			break
+		}
+		p.next()
+		name.WriteString(".")
+	}
+	opt.name = name.String()
+	if err := p.expectSymbol("="); err != nil {
+		return opt, err
+	}
+	val, err := p.parseValue()
+	if err != nil {
+		return opt, err
+	}
+	opt.value = val
+	return opt, nil
+}
+
+// parseValue parses a constant: an identifier, a (possibly signed) number,
+// a string, or an aggregate in braces, which is returned as raw text.
+func (p *parser) parseValue() (string, error) {
+	tok := p.cur()
+	switch {
+	case tok.kind == tokString:
+		val, err := p.expectString("value")
+		return val.text, err
+	case tok.kind == tokIdent:
+		name, err := p.expectName("value")
+		return name.text, err
+	case tok.kind == tokNumber:
+		p.next()
+		return tok.text, nil
+	case tok.kind == tokSymbol && (tok.text == "-" || tok.text == "+"):
+		p.next()
+		num := p.cur()
+		if num.kind != tokNumber && !(num.kind == tokIdent && (num.text == "inf" || num.text == "nan")) {
+			return "", p.unexpected("number")
+		}
+		p.next()
+		if tok.text == "-" {
+			return "-" + num.text, nil
+		}
+		return num.text, nil
+	case tok.kind == tokSymbol && tok.text == "{":
+		return p.parseAggregate()
+	}
+	return "", p.unexpected("value")
+}
+
+// parseAggregate skips a balanced text-format aggregate value and returns
+// its tokens joined by spaces.
+func (p *parser) parseAggregate() (string, error) {
+	start := p.cur().pos
+	var parts []string
+	depth := 0
+	for {
+		tok := p.next()
+		switch {
+		case tok.kind == tokEOF:
+			return "", p.errorf(start, "unterminated aggregate value")
+		case tok.kind == tokString:
+			parts = append(parts, strconv.Quote(tok.text))
+		default:
+			parts = append(parts, tok.text)
+		}
+		if tok.kind == tokSymbol {
+			switch tok.text {
+			case "{", "[", "<":
+				depth++
+			case "}", "]", ">":
+				depth--
+			}
+		}
+		if depth == 0 {
+			return strings.Join(parts, " "), nil
+		}
+	}
+}
+
+// parseCompactOptions parses "[name = value, ...]" if present.
+func (p *parser) parseCompactOptions() ([]protoOption, error) {
+	if !p.isSymbol("[") {
+		return nil, nil
+	}
+	p.next()
+	var opts []protoOption
+	for {
+		opt, err := p.parseOption()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+		if p.isSymbol(",") {
+			p.next()
+			continue
+		}
+		if err := p.expectSymbol("]"); err != nil {
+			return nil, err
+		}
+		return opts, nil
+	}
+}
@@ -0,0 +1,452 @@
+package protoc
+
+import "strings"
+
+// The declarations of a .proto file: messages with their fields, enums,
+// services and extensions. The file-level statements are parsed in
+// parser.go. Only what Lint and the go_package checks look at is kept;
+// everything else is parsed for its syntax and dropped.
+
+type protoMessage struct {
+	name       string
+	fields     []*protoField
+	messages   []*protoMessage
+	enums      []*protoEnum
+	extends    []*protoExtend
+	isMapEntry bool
+}
+
+type protoField struct {
+	name  string
+	pos   position
+	group bool
+}
+
+type protoEnum struct {
+	values []*protoEnumValue
+}
+
+type protoEnumValue struct {
+	name   string
+	pos    position
+	number int
+}
+
+type protoService struct {
+	name    string
+	pos     position
+	methods []*protoMethod
+}
+
+type protoMethod struct {
+	name   string
+	pos    position
+	input  string
+	output string
+}
+
+type protoExtend struct {
+	fields   []*protoField
+	messages []*protoMessage
+}
+
+func (p *parser) parseMessage() (*protoMessage, error) {
+	p.next()
+	name, err := p.expectIdent("message name")
+	if err != nil {
+		return nil, err
+	}
+	msg := &protoMessage{name: name.text}
+	if err := p.expectSymbol("{"); err != nil {
+		return nil, err
+	}
+	if err := p.parseMessageBody(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (p *parser) parseMessageBody(msg *protoMessage) error {
+	for {
+		if p.skipEmpty() {
+			continue
+		}
+		if p.isSymbol("}") {
+			p.next()
+			return nil
+		}
+		tok := p.cur()
+		if !startsName(tok) {
+			return p.unexpected("message member")
+		}
+
+		var err error
+		switch tok.text {
+		case "option":
+			_, err = p.parseOptionStatement()
+		case "message":
+			var nested *protoMessage
+			nested, err = p.parseMessage()
+			msg.messages = append(msg.messages, nested)
+		case "enum":
+			var enum *protoEnum
+			enum, err = p.parseEnum()
+			msg.enums = append(msg.enums, enum)
+		case "extend":
+			var ext *protoExtend
+			ext, err = p.parseExtend()
+			msg.extends = append(msg.extends, ext)
+		case "extensions":
+			p.next()
+			err = p.parseRanges(false)
+			if err == nil {
+				_, err = p.parseCompactOptions()
+			}
+			if err == nil {
+				err = p.expectSymbol(";")
+			}
+		case "reserved":
+			err = p.parseReserved()
+		case "oneof":
+			err = p.parseOneof(msg)
+		default:
+			err = p.parseField(msg, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseField parses a normal, map or group field and adds it (and, for
+// groups and maps, the synthesized nested message) to msg.
+func (p *parser) parseField(msg *protoMessage, inOneof bool) error {
+	if !inOneof && (p.isKeyword("optional") || p.isKeyword("required") || p.isKeyword("repeated")) {
+		// "optional" etc. may also be used as a message type name; it is a
+		// label only if followed by another identifier.
+		if startsName(p.toks[p.pos+1]) {
+			p.next()
+		}
+	}
+
+	field := &protoField{}
+	isMap := false
+	if p.isKeyword("map") && p.toks[p.pos+1].kind == tokSymbol && p.toks[p.pos+1].text == "<" {
+		p.next()
+		p.next()
+		if _, err := p.expectIdent("map key type"); err != nil {
+			return err
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return err
+		}
+		if _, err := p.expectName("map value type"); err != nil {
+			return err
+		}
+		if err := p.expectSymbol(">"); err != nil {
+			return err
+		}
+		isMap = true
+	} else if p.isKeyword("group") && p.toks[p.pos+1].kind == tokIdent {
+		p.next()
+		field.group = true
+	} else if _, err := p.expectName("field type"); err != nil {
+		return err
+	}
+
+	name, err := p.expectIdent("field name")
+	if err != nil {
+		return err
+	}
+	field.name = name.text
+	field.pos = name.pos
+	if err := p.expectSymbol("="); err != nil {
+		return err
+	}
+	if _, _, err := p.expectInt("field number"); err != nil {
+		return err
+	}
+	if _, err := p.parseCompactOptions(); err != nil {
+		return err
+	}
+
+	switch {
+	case field.group:
+		group := &protoMessage{name: field.name}
+		field.name = strings.ToLower(field.name)
+		if err := p.expectSymbol("{"); err != nil {
+			return err
+		}
+		if err := p.parseMessageBody(group); err != nil {
+			return err
+		}
+		msg.messages = append(msg.messages, group)
+	case isMap:
+		msg.messages = append(msg.messages, &protoMessage{name: mapEntryName(field.name), isMapEntry: true})
+		if err := p.expectSymbol(";"); err != nil {
+			return err
+		}
+	default:
+		if err := p.expectSymbol(";"); err != nil {
+			return err
+		}
+	}
+
+	msg.fields = append(msg.fields, field)
+	return nil
+}
+
+// mapEntryName returns the name of the synthesized message for a map field,
+// following protoc: "foo_bar" becomes "FooBarEntry".
+func mapEntryName(field string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range field {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		sb.WriteRune(r)
+	}
+	return sb.String() + "Entry"
+}
+
+func (p *parser) parseOneof(msg *protoMessage) error {
+	p.next()
+	if _, err := p.expectIdent("oneof name"); err != nil {
+		return err
+	}
+	if err := p.expectSymbol("{"); err != nil {
+		return err
+	}
+	for {
+		if p.skipEmpty() {
+			continue
+		}
+		if p.isSymbol("}") {
+			p.next()
+			return nil
+		}
+		if p.isKeyword("option") {
+			if _, err := p.parseOptionStatement(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.parseField(msg, true); err != nil {
+			return err
+		}
+	}
+}
+
+// parseRanges parses a comma separated list of numbers and "a to b" ranges.
+// When names is true, reserved names are accepted too. The ranges are not
+// kept: Breaking reads them from the descriptors.
+func (p *parser) parseRanges(names bool) error {
+	for {
+		switch {
+		case names && p.cur().kind == tokString:
+			p.next()
+		case names && p.cur().kind == tokIdent && !p.isKeyword("max"):
+			// Editions allow reserved names as bare identifiers.
+			p.next()
+		default:
+			if _, _, err := p.expectInt("range start"); err != nil {
+				return err
+			}
+			if p.isKeyword("to") {
+				p.next()
+				if p.isKeyword("max") {
+					p.next()
+				} else if _, _, err := p.expectInt("range end"); err != nil {
+					return err
+				}
+			}
+		}
+		if !p.isSymbol(",") {
+			return nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseReserved() error {
+	p.next()
+	if err := p.parseRanges(true); err != nil {
+		return err
+	}
+	return p.expectSymbol(";")
+}
+
+func (p *parser) parseEnum() (*protoEnum, error) {
+	p.next()
+	if _, err := p.expectIdent("enum name"); err != nil {
+		return nil, err
+	}
+	enum := &protoEnum{}
+	if err := p.expectSymbol("{"); err != nil {
+		return nil, err
+	}
+	for {
+		if p.skipEmpty() {
+			continue
+		}
+		if p.isSymbol("}") {
+			p.next()
+			return enum, nil
+		}
+		switch {
+		case p.isKeyword("option"):
+			if _, err := p.parseOptionStatement(); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("reserved"):
+			if err := p.parseReserved(); err != nil {
+				return nil, err
+			}
+		default:
+			valName, err := p.expectIdent("enum value name")
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol("="); err != nil {
+				return nil, err
+			}
+			number, _, err := p.expectInt("enum value number")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.parseCompactOptions(); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(";"); err != nil {
+				return nil, err
+			}
+			enum.values = append(enum.values, &protoEnumValue{name: valName.text, pos: valName.pos, number: number})
+		}
+	}
+}
+
+func (p *parser) parseService() (*protoService, error) {
+	p.next()
+	name, err := p.expectIdent("service name")
+	if err != nil {
+		return nil, err
+	}
+	svc := &protoService{name: name.text, pos: name.pos}
+	if err := p.expectSymbol("{"); err != nil {
+		return nil, err
+	}
+	for {
+		if p.skipEmpty() {
+			continue
+		}
+		if p.isSymbol("}") {
+			p.next()
+			return svc, nil
+		}
+		switch {
+		case p.isKeyword("option"):
+			if _, err := p.parseOptionStatement(); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("rpc"):
+			method, err := p.parseMethod()
+			if err != nil {
+				return nil, err
+			}
+			svc.methods = append(svc.methods, method)
+		default:
+			return nil, p.unexpected("\"rpc\" or \"option\"")
+		}
+	}
+}
+
+func (p *parser) parseMethod() (*protoMethod, error) {
+	p.next()
+	name, err := p.expectIdent("rpc name")
+	if err != nil {
+		return nil, err
+	}
+	method := &protoMethod{name: name.text, pos: name.pos}
+
+	parseType := func() (string, error) {
+		if err := p.expectSymbol("("); err != nil {
+			return "", err
+		}
+		if p.isKeyword("stream") && startsName(p.toks[p.pos+1]) {
+			p.next()
+		}
+		typ, err := p.expectName("message type")
+		if err != nil {
+			return "", err
+		}
+		return typ.text, p.expectSymbol(")")
+	}
+
+	if method.input, err = parseType(); err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("returns") {
+		return nil, p.unexpected("\"returns\"")
+	}
+	p.next()
+	if method.output, err = parseType(); err != nil {
+		return nil, err
+	}
+
+	if p.skipEmpty() {
+		return method, nil
+	}
+	if err := p.expectSymbol("{"); err != nil {
+		return nil, err
+	}
+	for {
+		if p.skipEmpty() {
+			continue
+		}
+		if p.isSymbol("}") {
+			p.next()
+			return method, nil
+		}
+		if !p.isKeyword("option") {
+			return nil, p.unexpected("\"option\"")
+		}
+		if _, err := p.parseOptionStatement(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseExtend() (*protoExtend, error) {
+	p.next()
+	if _, err := p.expectName("extendee type"); err != nil {
+		return nil, err
+	}
+	ext := &protoExtend{}
+	if err := p.expectSymbol("{"); err != nil {
+		return nil, err
+	}
+	// Reuse message body parsing for the fields; groups inside extend
+	// blocks produce nested messages that belong to the enclosing scope.
+	holder := &protoMessage{}
+	for {
+		if p.skipEmpty() {
+			continue
+		}
+		if p.isSymbol("}") {
+			p.next()
+			break
+		}
+		if err := p.parseField(holder, false); err != nil {
+			return nil, err
+		}
+	}
+	ext.fields = holder.fields
+	ext.messages = holder.messages
+	return ext, nil
+}
@@ -0,0 +1,319 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Plan describes what Compile would do, without running protoc.
+type Plan struct {
+	// Files are the files to compile, relative to the workspace.
+	Files []string `json:"files"`
+	// Commands are the protoc invocations, in the order they run.
+	Commands []PlanCommand `json:"commands"`
+	// OutputDirs are the directories generated code is written to.
+	OutputDirs []string `json:"output_dirs"`
+	// Outputs are the generated files that can be predicted from the
+	// sources. Only the go and go-grpc plugins are covered.
+	Outputs []PlanOutput `json:"outputs,omitempty"`
+}
+
+// PlanCommand is a single protoc invocation of a Plan.
+type PlanCommand struct {
+	// Args is the protoc command line running the plugins protoc runs
+	// itself. It is empty for backends other than protoc, with which all
+	// plugins are run by the compiler.
+	Args    []string `json:"args"`
+	Plugins []string `json:"plugins"`
+	Files   []string `json:"files"`
+}
+
+// PlanOutput is a file a plugin is expected to generate.
+type PlanOutput struct {
+	Plugin string `json:"plugin"`
+	Source string `json:"source"` // Source file relative to the workspace
+	Path   string `json:"path"`   // Generated file
+}
+
+// Files returns the .proto files Compile would pass to protoc, relative to
+// the workspace directory. Neither protoc nor an output directory is
+// required.
+func (c *Compiler) Files(ctx context.Context) ([]string, error) {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if err := impl.validateSources(); err != nil {
+		return nil, err
+	}
+
+	files, err := impl.collectFiles()
+	if err != nil {
+		return nil, err
+	}
+	return impl.importNames(files)
+}
+
+// Plan returns the protoc invocations Compile would run and the files they
+// are expected to generate. protoc is not required.
+func (c *Compiler) Plan(ctx context.Context) (*Plan, error) {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if err := impl.validate(); err != nil {
+		return nil, err
+	}
+	return impl.plan()
+}
+
+// Clean removes the files Compile is expected to generate, as listed in
+// the Plan outputs, and any directories left empty below the output
+// directories. It returns the removed files.
+func (c *Compiler) Clean(ctx context.Context) ([]string, error) {
+	plan, err := c.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	dirs := make(map[string]bool)
+	for _, out := range plan.Outputs {
+		if err := os.Remove(out.Path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, fmt.Errorf("remove generated file: %w", err)
+		}
+		removed = append(removed, out.Path)
+		dirs[filepath.Dir(out.Path)] = true
+	}
+
+	// Remove emptied directories deepest first, stopping at the output
+	// directories themselves.
+	var emptied []string
+	for dir := range dirs {
+		emptied = append(emptied, dir)
+	}
+	sort.Slice(emptied, func(i, j int) bool { return len(emptied[i]) > len(emptied[j]) })
+	for _, dir := range emptied {
+		for belowAny(dir, plan.OutputDirs) {
+			if os.Remove(dir) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return removed, nil
+}
+
+// belowAny reports whether dir is strictly below one of the parents.
+func belowAny(dir string, parents []string) bool {
+	for _, out := range parents {
+		rel, err := filepath.Rel(out, dir)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check verifies that the configuration is valid, protoc is available in
+// the required version and the sources parse with all imports resolved and
+// no import cycles, without generating anything. All problems found are
+// returned together.
+func (c *Compiler) Check(ctx context.Context) error {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := impl.validate(); err != nil {
+		return err
+	}
+
+	var errs []error
+	if err := impl.checkProtocAvailable(); err != nil {
+		errs = append(errs, err)
+	} else if err := impl.checkProtocVersion(); err != nil {
+		errs = append(errs, err)
+	}
+
+	graph, err := impl.graph(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, imp := range graph.Unresolved {
+		errs = append(errs, fmt.Errorf("%s:%d: import %q not found", imp.File, imp.Line, imp.Import))
+	}
+	for _, cycle := range graph.Cycles {
+		errs = append(errs, fmt.Errorf("import cycle: %s", strings.Join(cyclePath(cycle, graph.Dependencies), " -> ")))
+	}
+	if len(graph.Unresolved) > 0 {
+		return errors.Join(errs...)
+	}
+
+	files, err := impl.collectFiles()
+	if err == nil {
+		err = impl.resolveGoPackages(files)
+	}
+	if err == nil {
+		err = impl.validateGoPackages(files)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// plan builds the Plan of a validated compiler.
+func (c *compilerImpl) plan() (*Plan, error) {
+	files, err := c.collectFiles()
+	if err != nil {
+		return nil, err
+	}
+	names, err := c.importNames(files)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.resolveGoPackages(files); err != nil {
+		return nil, err
+	}
+
+	p := &Plan{Files: names, OutputDirs: c.outputDirs()}
+	for _, inv := range c.invocations(files) {
+		invNames, err := c.importNames(inv.files)
+		if err != nil {
+			return nil, err
+		}
+		var args []string
+		if c.usesProtoc() {
+			external, _ := c.splitGenerators(inv.plugins)
+			args = c.buildCommand(external, inv.files).Args
+		}
+		p.Commands = append(p.Commands, PlanCommand{
+			Args:    args,
+			Plugins: inv.plugins,
+			Files:   invNames,
+		})
+	}
+
+	for i, file := range files {
+		outputs, err := c.expectedOutputs(names[i], file)
+		if err != nil {
+			return nil, err
+		}
+		p.Outputs = append(p.Outputs, outputs...)
+	}
+	return p, nil
+}
+
+// importNames converts file paths to workspace-relative names.
+func (c *compilerImpl) importNames(files []string) ([]string, error) {
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		name, err := c.importName(file)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// expectedOutputs returns the files the go and go-grpc plugins generate for
+// a source file. Files whose output location cannot be determined, such as
+// ones without a go_package in import mode, are left out.
+func (c *compilerImpl) expectedOutputs(name, file string) ([]PlanOutput, error) {
+	var outputs []PlanOutput
+	var parsed *protoFile
+	for _, plugin := range c.plugins {
+		var opts []string
+		var suffix string
+		switch plugin {
+		case "go":
+			opts, suffix = c.goPluginOpts(plugin), ".pb.go"
+		case "go-grpc":
+			opts, suffix = c.goPluginOpts(plugin), "_grpc.pb.go"
+		default:
+			continue
+		}
+
+		if parsed == nil {
+			var err error
+			if parsed, err = parseProtoFile(file); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", name, err)
+			}
+		}
+		// protoc-gen-go-grpc skips files without services
+		if plugin == "go-grpc" && len(parsed.services) == 0 {
+			continue
+		}
+
+		goPackage, _ := parsed.option("go_package")
+		rel, ok := goOutputPath(name, goPackage, opts, suffix)
+		if !ok {
+			continue
+		}
+		outputs = append(outputs, PlanOutput{
+			Plugin: plugin,
+			Source: name,
+			Path:   filepath.Join(c.pluginOutputDir(plugin), filepath.FromSlash(rel)),
+		})
+	}
+	return outputs, nil
+}
+
+// goOutputPath returns the path, relative to the output directory, that
+// protoc-gen-go style plugins write for a source file, following their
+// paths, module and M options. It reports false if the path cannot be
+// determined.
+func goOutputPath(name, goPackage string, opts []string, suffix string) (string, bool) {
+	mode := "import"
+	module := ""
+	for _, opt := range opts {
+		key, value, _ := strings.Cut(opt, "=")
+		switch {
+		case key == "paths":
+			mode = value
+		case key == "module":
+			module = value
+		case strings.HasPrefix(key, "M") && key[1:] == name:
+			goPackage = value
+		}
+	}
+
+	base := strings.TrimSuffix(name, path.Ext(name)) + suffix
+	if mode == "source_relative" {
+		return base, true
+	}
+
+	importPath, _, _ := strings.Cut(goPackage, ";")
+	if importPath == "" {
+		return "", false
+	}
+	out := path.Join(importPath, path.Base(base))
+	if module != "" {
+		if !strings.HasPrefix(out, module+"/") {
+			return "", false
+		}
+		out = strings.TrimPrefix(out, module+"/")
+	}
+	return out, true
+}
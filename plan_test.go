@@ -0,0 +1,186 @@
+package protoc_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestPlan(t *testing.T) {
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, proto, map[string]string{
+		"api/api.proto":    `syntax = "proto3"; package api; option go_package = "example.com/project/gen/api;apiv1"; service Api {}`,
+		"model/user.proto": `syntax = "proto3"; package model; option go_package = "example.com/project/gen/model";`,
+		"misc/misc.proto":  `syntax = "proto3"; package misc;`,
+	})
+
+	compiler := protoc.NewCompiler().
+		WithProtoDir(proto).
+		WithProtoWorkSpace(proto).
+		WithOutputDir(gen).
+		WithPlugins("go", "go-grpc", "validate").
+		WithGoOpts("module=example.com/project/gen").
+		WithGoGrpcOpts("module=example.com/project/gen").
+		WithPluginStrategy("validate", protoc.StrategyDirectory)
+
+	plan, err := compiler.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if want := []string{"api/api.proto", "misc/misc.proto", "model/user.proto"}; !reflect.DeepEqual(plan.Files, want) {
+		t.Errorf("Files = %v, want %v", plan.Files, want)
+	}
+	// One run for go and go-grpc, one per directory for validate
+	if len(plan.Commands) != 4 {
+		t.Fatalf("expected 4 commands, got %d: %+v", len(plan.Commands), plan.Commands)
+	}
+	if args := strings.Join(plan.Commands[0].Args, " "); !strings.Contains(args, "--go_out=module=example.com/project/gen:") {
+		t.Errorf("unexpected first command: %s", args)
+	}
+
+	var outputs []string
+	for _, out := range plan.Outputs {
+		rel, _ := filepath.Rel(gen, out.Path)
+		outputs = append(outputs, out.Plugin+":"+filepath.ToSlash(rel))
+	}
+	// misc.proto has no go_package, so its output cannot be predicted
+	want := []string{"go:api/api.pb.go", "go-grpc:api/api_grpc.pb.go", "go:model/user.pb.go"}
+	if !reflect.DeepEqual(outputs, want) {
+		t.Errorf("Outputs = %v, want %v", outputs, want)
+	}
+
+	files, err := compiler.Files(context.Background())
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if !reflect.DeepEqual(files, plan.Files) {
+		t.Errorf("Files() = %v, want %v", files, plan.Files)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, tmpDir, map[string]string{
+		"a.proto": `syntax = "proto3"; import "b.proto"; import "missing.proto";`,
+		"b.proto": `syntax = "proto3"; import "a.proto";`,
+	})
+
+	err := protoc.NewCompiler().
+		WithProtoDir(tmpDir).
+		WithProtoWorkSpace(tmpDir).
+		WithOutputDir(filepath.Join(tmpDir, "gen")).
+		Check(context.Background())
+	if err == nil {
+		t.Fatal("expected Check to fail")
+	}
+	for _, want := range []string{`a.proto:1: import "missing.proto" not found`, "import cycle: a.proto -> b.proto -> a.proto"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error, got: %v", want, err)
+		}
+	}
+}
+
+func TestGoPackageMapping(t *testing.T) {
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	vendor := filepath.Join(tmpDir, "vendor")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, proto, map[string]string{
+		"api/v1/api.proto": `syntax = "proto3"; package api.v1; import "thirdparty/money.proto"; import "model/user.proto"; service Api {}`,
+		"model/user.proto": `syntax = "proto3"; package model; option go_package = "example.com/project/gen/model";`,
+	})
+	writeProtos(t, vendor, map[string]string{
+		"thirdparty/money.proto": `syntax = "proto3"; package thirdparty;`,
+	})
+
+	plan, err := protoc.NewCompiler().
+		WithProtoDir(filepath.Join(proto, "api")).
+		WithProtoWorkSpace(proto).
+		WithImportPaths(vendor).
+		WithOutputDir(gen).
+		WithPlugins("go", "go-grpc").
+		WithGoOpts("module=example.com/project/gen").
+		WithGoGrpcOpts("module=example.com/project/gen").
+		WithGoPackagePrefix("example.com/project/gen/").
+		WithGoPackageMapping(map[string]string{"thirdparty/money.proto": "example.com/money;moneypb"}).
+		Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	mappings := "Mapi/v1/api.proto=example.com/project/gen/api/v1,Mthirdparty/money.proto=example.com/money;moneypb:"
+	args := strings.Join(plan.Commands[0].Args, " ")
+	if !strings.Contains(args, "--go_out=module=example.com/project/gen,"+mappings) ||
+		!strings.Contains(args, "--go-grpc_out=module=example.com/project/gen,"+mappings) {
+		t.Errorf("M options not passed to both plugins: %s", args)
+	}
+
+	var outputs []string
+	for _, out := range plan.Outputs {
+		rel, _ := filepath.Rel(gen, out.Path)
+		outputs = append(outputs, out.Plugin+":"+filepath.ToSlash(rel))
+	}
+	if want := []string{"go:api/v1/api.pb.go", "go-grpc:api/v1/api_grpc.pb.go"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("Outputs = %v, want %v", outputs, want)
+	}
+}
+
+func TestGoPackageValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	writeProtos(t, tmpDir, map[string]string{
+		"go.mod":                 "module example.com/project\n",
+		"proto/api/api.proto":    `syntax = "proto3"; package api; option go_package = "example.com/project/gen/api";`,
+		"proto/api/extra.proto":  `syntax = "proto3"; package api; option go_package = "example.com/project/gen/api;apiv2";`,
+		"proto/model/user.proto": `syntax = "proto3"; package model; option go_package = "example.com/project/model";`,
+		"proto/misc.proto":       `syntax = "proto3"; package misc;`,
+	})
+
+	compiler := protoc.NewCompiler().
+		WithProtoDir(proto).
+		WithProtoWorkSpace(proto).
+		WithOutputDir(filepath.Join(tmpDir, "gen")).
+		WithGoOpts("paths=source_relative")
+
+	// The check is opt-in
+	err := compiler.Check(context.Background())
+	if err != nil && strings.Contains(err.Error(), "GO_PACKAGE") {
+		t.Errorf("validation should be disabled by default, got: %v", err)
+	}
+
+	err = compiler.WithGoPackageValidation(true).Check(context.Background())
+	if err == nil {
+		t.Fatal("expected Check to fail")
+	}
+	for _, want := range []string{
+		`api/extra.proto:1:40: Go package apiv2 conflicts with package api of api/api.proto in ` + filepath.Join(tmpDir, "gen", "api") + ` (GO_PACKAGE_COLLISION)`,
+		`misc.proto:1:28: no go_package option or M mapping, the Go import path cannot be determined (GO_PACKAGE_MISSING)`,
+		`model/user.proto:1:42: generated into ` + filepath.Join(tmpDir, "gen", "model") + `, which is Go package "example.com/project/gen/model", but go_package is "example.com/project/model" (GO_PACKAGE_LOCATION)`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error containing %q, got:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "api/api.proto:") {
+		t.Errorf("api/api.proto should be valid, got:\n%v", err)
+	}
+
+	err = compiler.
+		WithGoOpts("module=example.com/project/gen").
+		WithExcludes("misc.proto", "api/extra.proto").
+		Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `model/user.proto:1:42: go_package "example.com/project/model" is outside the module prefix "example.com/project/gen" of the go plugin (GO_PACKAGE_MODULE)`) {
+		t.Errorf("expected module prefix error, got: %v", err)
+	}
+
+	err = compiler.WithGoPackageValidation(false).Check(context.Background())
+	if err != nil && strings.Contains(err.Error(), "GO_PACKAGE") {
+		t.Errorf("validation should be disabled, got: %v", err)
+	}
+}
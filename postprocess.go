@@ -0,0 +1,388 @@
+package protoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WithGoFormat formats the .go files generated by a successful protoc run
+// with go/format, for plugins that emit unformatted code. Files that
+// cannot be formatted, usually because they do not parse, are reported in
+// the error of Compile after all others have been processed.
+func (c *Compiler) WithGoFormat(enabled bool) *Compiler {
+	c.goFormat = enabled
+	return c
+}
+
+// WithFixImports additionally removes unused imports from the generated
+// .go files and sorts the remaining ones. Only imports that are certainly
+// unused are removed; missing imports are not added. It implies
+// WithGoFormat.
+func (c *Compiler) WithFixImports(enabled bool) *Compiler {
+	c.fixImports = enabled
+	return c
+}
+
+// PostProcessor rewrites generated files after a successful protoc run.
+// Process receives the path of a file relative to its output directory,
+// with forward slashes, and its content, and returns the new content; it
+// returns content unchanged to leave the file alone. Files are processed
+// concurrently, so Process must be safe for concurrent use.
+type PostProcessor interface {
+	Process(path string, content []byte) ([]byte, error)
+}
+
+// PostProcessorFunc adapts a function to the PostProcessor interface.
+type PostProcessorFunc func(path string, content []byte) ([]byte, error)
+
+// Process calls f(path, content).
+func (f PostProcessorFunc) Process(path string, content []byte) ([]byte, error) {
+	return f(path, content)
+}
+
+// WithPostProcessor adds a post-processor applied to every file the
+// plugins of a successful run generated. Other files in the output
+// directories, such as the output of other targets, are left alone.
+// Post-processors run in the order they were added, before the headers of
+// WithHeader and WithBuildTags are added and before WithGoFormat formats
+// the result. An error leaves the file as generated; the errors of all
+// files are reported together in the error of Compile.
+func (c *Compiler) WithPostProcessor(p PostProcessor) *Compiler {
+	c.postProcessors = append(c.postProcessors, p)
+	return c
+}
+
+// goFormatter is the post-processor of WithGoFormat and WithFixImports.
+type goFormatter struct {
+	fixImports bool
+}
+
+func (f goFormatter) Process(path string, content []byte) ([]byte, error) {
+	if !strings.HasSuffix(path, ".go") {
+		return content, nil
+	}
+	if f.fixImports {
+		return fixGoImports(content)
+	}
+	return format.Source(content)
+}
+
+// postProcessing reports whether generated files need to be processed
+// after protoc runs.
+func (c *compilerImpl) postProcessing() bool {
+	return len(c.postProcessors) > 0 || c.goFormat || c.fixImports ||
+		len(c.headers) > 0 || len(c.buildTags) > 0
+}
+
+// pipeline returns the post-processors of a run in the order they apply.
+func (c *compilerImpl) pipeline() ([]PostProcessor, error) {
+	pipeline := append([]PostProcessor(nil), c.postProcessors...)
+	headers, err := c.newHeaderer()
+	if err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		pipeline = append(pipeline, headers)
+	}
+	if c.goFormat || c.fixImports {
+		pipeline = append(pipeline, goFormatter{fixImports: c.fixImports})
+	}
+	return pipeline, nil
+}
+
+// outputStage holds the staging directories protoc writes into when the
+// generated files are post-processed, one per output directory. They are
+// created inside the output directories, so the files can be moved into
+// place.
+type outputStage struct {
+	c    *compilerImpl
+	dirs map[string]string // Output directory to staging directory
+}
+
+// newOutputStage creates the staging directories of plugins.
+func (c *compilerImpl) newOutputStage(plugins []string) (*outputStage, error) {
+	s := &outputStage{c: c, dirs: make(map[string]string)}
+	for _, plugin := range plugins {
+		out := c.pluginOutputDir(plugin)
+		if _, ok := s.dirs[out]; ok {
+			continue
+		}
+		dir, err := os.MkdirTemp(out, ".protoc-go-")
+		if err != nil {
+			s.cleanup()
+			return nil, fmt.Errorf("create output directory: %w", err)
+		}
+		s.dirs[out] = dir
+	}
+	return s, nil
+}
+
+// outputDir returns the staging directory of a plugin.
+func (s *outputStage) outputDir(plugin string) string {
+	return s.dirs[s.c.pluginOutputDir(plugin)]
+}
+
+// commit moves the files protoc generated into the output directories and
+// records them as generated.
+func (s *outputStage) commit() error {
+	for out, dir := range s.dirs {
+		err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(out, rel)
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(file, dest); err != nil {
+				return err
+			}
+			s.c.generated = append(s.c.generated, dest)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("move generated files: %w", err)
+		}
+	}
+	return nil
+}
+
+// cleanup removes the staging directories.
+func (s *outputStage) cleanup() {
+	for _, dir := range s.dirs {
+		os.RemoveAll(dir)
+	}
+}
+
+// generatedFiles returns the files the current run wrote: the files of
+// the plugin responses and the ones protoc generated.
+func (c *compilerImpl) generatedFiles() []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, file := range c.generated {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// postProcess runs the post-processors over the files generated by the
+// current run, in parallel.
+func (c *compilerImpl) postProcess() error {
+	if !c.postProcessing() {
+		return nil
+	}
+	pipeline, err := c.pipeline()
+	if err != nil {
+		return err
+	}
+
+	files := c.generatedFiles()
+	errs := make([]error, len(files))
+	changed := make([]bool, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU() && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				changed[i], errs[i] = c.processFile(files[i], pipeline)
+				if errs[i] != nil {
+					errs[i] = fmt.Errorf("%s: %w", files[i], errs[i])
+				}
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if c.verbose {
+		processed := 0
+		for _, ok := range changed {
+			if ok {
+				processed++
+			}
+		}
+		fmt.Printf("Post-processed %d generated files\n", processed)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("post-process generated files: %w", err)
+	}
+	return nil
+}
+
+// processFile rewrites a generated file in place and reports whether it
+// changed. A file is only written once every post-processor succeeded.
+func (c *compilerImpl) processFile(file string, pipeline []PostProcessor) (bool, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+
+	name := c.outputPath(file)
+	out := src
+	for _, p := range pipeline {
+		if out, err = p.Process(name, out); err != nil {
+			return false, err
+		}
+	}
+	if bytes.Equal(src, out) {
+		return false, nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(file, out, info.Mode().Perm())
+}
+
+// fixGoImports removes unused imports, sorts the imports and formats src.
+func fixGoImports(src []byte) ([]byte, error) {
+	fset := gotoken.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", src, goparser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	removeUnusedImports(file)
+	ast.SortImports(fset, file)
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	// Formatting the modified tree can leave blank lines behind; a second
+	// pass over the source normalizes them.
+	return format.Source(buf.Bytes())
+}
+
+// removeUnusedImports deletes the imports whose package is not referenced.
+// The name of an unnamed import is guessed from its path, so such an
+// import is only removed when every package referenced by the file is
+// accounted for by another import.
+func removeUnusedImports(file *ast.File) {
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	names := make(map[*ast.ImportSpec]string)
+	known := make(map[string]bool)
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := guessPackageName(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		names[spec] = name
+		known[name] = true
+	}
+	unexplained := false
+	for name := range used {
+		if !known[name] {
+			unexplained = true
+		}
+	}
+
+	unused := make(map[*ast.ImportSpec]bool)
+	for spec, name := range names {
+		switch {
+		case name == "_" || name == "." || spec.Path.Value == `"C"`:
+		case used[name]:
+		case spec.Name == nil && unexplained:
+		default:
+			unused[spec] = true
+		}
+	}
+	if len(unused) == 0 {
+		return
+	}
+
+	var imports []*ast.ImportSpec
+	for _, spec := range file.Imports {
+		if !unused[spec] {
+			imports = append(imports, spec)
+		}
+	}
+	file.Imports = imports
+
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != gotoken.IMPORT {
+			decls = append(decls, decl)
+			continue
+		}
+		var specs []ast.Spec
+		for _, spec := range gen.Specs {
+			if !unused[spec.(*ast.ImportSpec)] {
+				specs = append(specs, spec)
+			}
+		}
+		if len(specs) == 0 {
+			continue
+		}
+		gen.Specs = specs
+		decls = append(decls, gen)
+	}
+	file.Decls = decls
+}
+
+var versionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// guessPackageName returns the usual package name of an import path: its
+// last element, skipping major version elements and dropping ".vN"
+// suffixes and "go-" prefixes.
+func guessPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if versionSuffix.MatchString(base) && path.Dir(importPath) != "." {
+		base = path.Base(path.Dir(importPath))
+	}
+	if i := strings.Index(base, ".v"); i > 0 {
+		base = base[:i]
+	}
+	base = strings.TrimPrefix(base, "go-")
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, base)
+}
@@ -0,0 +1,297 @@
+package protoc_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// writeStubPlugin writes a plugin that ignores its request and generates
+// files with fixed content.
+func writeStubPlugin(t *testing.T, dir, name string, files map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin stubs are shell scripts")
+	}
+	resp := &pluginpb.CodeGeneratorResponse{}
+	for fileName, content := range files {
+		resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(fileName),
+			Content: proto.String(content),
+		})
+	}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respPath := filepath.Join(dir, name+".binpb")
+	if err := os.WriteFile(respPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "protoc-gen-"+name)
+	script := "#!/bin/sh\ncat >/dev/null\ncat '" + respPath + "'\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFixImports(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping post-processing test")
+	}
+
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, proto, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+	plugin := writeStubPlugin(t, tmpDir, "stub", map[string]string{
+		"x/x.go":    "package x\nimport (\n\"strings\"\n\"os\"\n\"fmt\"\n)\nfunc F()  {  fmt.Println(os.Args) }\n",
+		"bad.go":    "package bad\nfunc {\n",
+		"notes.txt": "not  go",
+	})
+
+	_, err := protoc.NewCompiler().
+		WithProtoDir(proto).
+		WithProtoWorkSpace(proto).
+		WithOutputDir(gen).
+		WithPlugins("stub").
+		WithPluginPath("stub", plugin).
+		WithFixImports(true).
+		Compile()
+	if err == nil || !strings.Contains(err.Error(), "post-process generated files") || !strings.Contains(err.Error(), filepath.Join(gen, "bad.go")) {
+		t.Fatalf("expected bad.go to be reported, got: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(gen, "x", "x.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "package x\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc F() { fmt.Println(os.Args) }\n"
+	if string(got) != want {
+		t.Errorf("x.go = %q, want %q", got, want)
+	}
+	if notes, _ := os.ReadFile(filepath.Join(gen, "notes.txt")); string(notes) != "not  go" {
+		t.Errorf("notes.txt should be left alone, got %q", notes)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping post-processing test")
+	}
+
+	year := strconv.Itoa(time.Now().Year())
+	header := "// Copyright " + year + " Example Inc.\n//\n// SPDX-License-Identifier: MIT\n\n"
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, proto, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+	plugin := writeStubPlugin(t, tmpDir, "stub", map[string]string{
+		"a/a.go":    "// Code generated by stub. DO NOT EDIT.\n\npackage a\n",
+		"a/b.go":    header + "//go:build old\n\n// Code generated by stub. DO NOT EDIT.\n\npackage a\n",
+		"a/a.py":    "x = 1\n",
+		"a/a.json":  "{}\n",
+		"other.go":  "package other\n",
+		"other.txt": "text\n",
+	})
+
+	_, err := protoc.NewCompiler().
+		WithProtoDir(proto).
+		WithProtoWorkSpace(proto).
+		WithOutputDir(gen).
+		WithPlugins("stub").
+		WithPluginPath("stub", plugin).
+		WithHeader("other.*", "Not {{.Path}}").
+		WithHeader("a/*", "Copyright {{.Year}} Example Inc.\n\nSPDX-License-Identifier: MIT\n").
+		WithBuildTags("a/*.go", "linux||darwin").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	want := map[string]string{
+		"a/a.go":    header + "//go:build linux || darwin\n\n// Code generated by stub. DO NOT EDIT.\n\npackage a\n",
+		"a/b.go":    header + "//go:build linux || darwin\n\n// Code generated by stub. DO NOT EDIT.\n\npackage a\n",
+		"a/a.py":    "# Copyright " + year + " Example Inc.\n#\n# SPDX-License-Identifier: MIT\n\nx = 1\n",
+		"a/a.json":  "{}\n",
+		"other.go":  "// Not other.go\n\npackage other\n",
+		"other.txt": "text\n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(gen, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestHeadersInvalid(t *testing.T) {
+	tmpDir := t.TempDir()
+	tests := []struct {
+		name     string
+		compiler *protoc.Compiler
+		want     string
+	}{
+		{"template", protoc.NewCompiler().WithHeader("*.go", "{{.Path"), "parse header template"},
+		{"pattern", protoc.NewCompiler().WithHeader("[", "x"), `invalid header pattern "["`},
+		{"constraint", protoc.NewCompiler().WithBuildTags("*.go", "linux &&"), `invalid build constraint "linux &&"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.compiler.
+				WithProtoDir(tmpDir).
+				WithProtoWorkSpace(tmpDir).
+				WithOutputDir(tmpDir).
+				Compile()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestPostProcessor(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping post-processing test")
+	}
+
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, proto, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+	files := map[string]string{
+		"bad1.go": "package a\n",
+		"bad2.go": "package a\n",
+	}
+	for i := 0; i < 20; i++ {
+		files["a/f"+strconv.Itoa(i)+".go"] = "package a\n\nimport _ \"example.com/old/dep\"\n"
+	}
+	plugin := writeStubPlugin(t, tmpDir, "stub", files)
+
+	var mu sync.Mutex
+	var seen []string
+	rewrite := protoc.PostProcessorFunc(func(path string, content []byte) ([]byte, error) {
+		mu.Lock()
+		seen = append(seen, path)
+		mu.Unlock()
+		if strings.HasPrefix(path, "bad") {
+			return nil, errors.New("rejected")
+		}
+		return bytes.ReplaceAll(content, []byte("example.com/old/"), []byte("example.com/new/")), nil
+	})
+
+	_, err := protoc.NewCompiler().
+		WithProtoDir(proto).
+		WithProtoWorkSpace(proto).
+		WithOutputDir(gen).
+		WithPlugins("stub").
+		WithPluginPath("stub", plugin).
+		WithPostProcessor(rewrite).
+		WithGoFormat(true).
+		Compile()
+	if err == nil {
+		t.Fatal("expected the rejected files to be reported")
+	}
+	for _, name := range []string{"bad1.go", "bad2.go"} {
+		if !strings.Contains(err.Error(), filepath.Join(gen, name)+": rejected") {
+			t.Errorf("error does not report %s: %v", name, err)
+		}
+	}
+
+	sort.Strings(seen)
+	if len(seen) != len(files) || seen[0] != "a/f0.go" || seen[len(seen)-1] != "bad2.go" {
+		t.Errorf("unexpected processed paths: %v", seen)
+	}
+	got, err := os.ReadFile(filepath.Join(gen, "a", "f7.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package a\n\nimport _ \"example.com/new/dep\"\n"; string(got) != want {
+		t.Errorf("f7.go = %q, want %q", got, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(gen, "bad1.go")); string(got) != "package a\n" {
+		t.Errorf("bad1.go should be left as generated, got %q", got)
+	}
+}
+
+func TestPostProcessorGeneratedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, workspace, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+	generated := map[string]string{"a.go": "package a\n", "sub/b.go": "package b\n"}
+
+	run := func(t *testing.T, backend protoc.Backend, setup func(*protoc.Compiler) *protoc.Compiler) {
+		os.RemoveAll(gen)
+		// A file of another target sharing the output directory, and a
+		// previous output the run rewrites with the same size
+		writeProtos(t, gen, map[string]string{"other.go": "package other\n", "a.go": "package x\n"})
+
+		var mu sync.Mutex
+		var seen []string
+		record := protoc.PostProcessorFunc(func(path string, content []byte) ([]byte, error) {
+			mu.Lock()
+			seen = append(seen, path)
+			mu.Unlock()
+			return content, nil
+		})
+		c := protoc.NewCompiler().
+			WithProtoDir(workspace).
+			WithProtoWorkSpace(workspace).
+			WithOutputDir(gen).
+			WithBackend(backend).
+			WithPostProcessor(record)
+		if _, err := setup(c).Compile(); err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		sort.Strings(seen)
+		if got := strings.Join(seen, " "); got != "a.go sub/b.go" {
+			t.Errorf("processed %s, want a.go sub/b.go", got)
+		}
+		entries, _ := os.ReadDir(gen)
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				t.Errorf("staging directory %s left behind", entry.Name())
+			}
+		}
+	}
+
+	t.Run("in-process", func(t *testing.T) {
+		run(t, protoc.GoBackend{}, func(c *protoc.Compiler) *protoc.Compiler {
+			return c.WithPlugins("gen").WithGenerator("gen", func(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+				resp := &pluginpb.CodeGeneratorResponse{}
+				for name, content := range generated {
+					resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{Name: proto.String(name), Content: proto.String(content)})
+				}
+				return resp, nil
+			})
+		})
+	})
+	t.Run("protoc", func(t *testing.T) {
+		if _, err := exec.LookPath("protoc"); err != nil {
+			t.Skip("protoc not available")
+		}
+		plugin := writeStubPlugin(t, tmpDir, "stub", generated)
+		run(t, nil, func(c *protoc.Compiler) *protoc.Compiler {
+			return c.WithPlugins("stub").WithPluginPath("stub", plugin)
+		})
+	})
+}
@@ -0,0 +1,354 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Project is a set of named compile targets with dependencies between
+// them, for repositories where targets must be built in a certain order.
+//
+// Build compiles the targets in dependency order, running independent
+// targets in parallel. Dependencies only order the builds: a target does
+// not see the outputs of its dependencies unless its compiler is set up
+// to, for example with WithImportPaths.
+type Project struct {
+	targets     []*projectTarget
+	parallelism int
+}
+
+// projectTarget is a target added to a Project.
+type projectTarget struct {
+	name      string
+	compiler  *Compiler
+	dependsOn []string
+}
+
+// TargetStatus is the outcome of building a target.
+type TargetStatus int
+
+const (
+	// TargetSucceeded means the target compiled without errors.
+	TargetSucceeded TargetStatus = iota
+	// TargetFailed means compiling the target returned an error.
+	TargetFailed
+	// TargetSkipped means the target was not compiled because a
+	// dependency failed or the build was cancelled.
+	TargetSkipped
+)
+
+// String returns "succeeded", "failed" or "skipped".
+func (s TargetStatus) String() string {
+	switch s {
+	case TargetFailed:
+		return "failed"
+	case TargetSkipped:
+		return "skipped"
+	default:
+		return "succeeded"
+	}
+}
+
+// TargetResult is the outcome of building a single target.
+type TargetResult struct {
+	Name     string
+	Status   TargetStatus
+	Output   string         // Combined protoc output
+	Err      error          // Compile error, or why the target was skipped
+	Duration time.Duration  // Time spent compiling; zero if skipped
+	Result   *CompileResult // Details for build reports; nil if skipped
+}
+
+// ProjectResult summarizes a Project build.
+type ProjectResult struct {
+	Targets []*TargetResult // In dependency order
+}
+
+// Target returns the result of the named target.
+func (r *ProjectResult) Target(name string) (*TargetResult, bool) {
+	for _, t := range r.Targets {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Failed returns the results of targets that failed or were skipped.
+func (r *ProjectResult) Failed() []*TargetResult {
+	var failed []*TargetResult
+	for _, t := range r.Targets {
+		if t.Status != TargetSucceeded {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// String returns a one line per target summary of the build.
+func (r *ProjectResult) String() string {
+	width := 0
+	for _, t := range r.Targets {
+		if len(t.Name) > width {
+			width = len(t.Name)
+		}
+	}
+
+	var sb strings.Builder
+	for _, t := range r.Targets {
+		fmt.Fprintf(&sb, "%-*s  %-9s", width, t.Name, t.Status)
+		switch t.Status {
+		case TargetSucceeded:
+			fmt.Fprintf(&sb, "  %s", t.Duration.Round(time.Millisecond))
+		default:
+			fmt.Fprintf(&sb, "  %v", t.Err)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// NewProject creates an empty Project.
+func NewProject() *Project {
+	return &Project{}
+}
+
+// AddTarget adds a named target that is built after the targets it
+// depends on, and skipped if one of them fails. Names and dependencies
+// are checked when the project is built.
+func (p *Project) AddTarget(name string, compiler *Compiler, dependsOn ...string) *Project {
+	p.targets = append(p.targets, &projectTarget{name: name, compiler: compiler, dependsOn: dependsOn})
+	return p
+}
+
+// WithParallelism limits how many targets are compiled at the same time.
+// The default is the number of CPUs.
+func (p *Project) WithParallelism(n int) *Project {
+	p.parallelism = n
+	return p
+}
+
+// Targets returns the target names in the order they were added.
+func (p *Project) Targets() []string {
+	names := make([]string, 0, len(p.targets))
+	for _, t := range p.targets {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// Compiler returns the compiler of the named target.
+func (p *Project) Compiler(name string) (*Compiler, bool) {
+	for _, t := range p.targets {
+		if t.name == name {
+			return t.compiler, true
+		}
+	}
+	return nil, false
+}
+
+// Order returns the named targets and everything they depend on in the
+// order they are built. Without names, all targets are included.
+// Independent targets keep the order they were added in.
+func (p *Project) Order(names ...string) ([]string, error) {
+	targets, err := p.index()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, dep := range targets[name].dependsOn {
+			visit(dep)
+		}
+	}
+	if len(names) == 0 {
+		names = p.Targets()
+	}
+	for _, name := range names {
+		if _, ok := targets[name]; !ok {
+			return nil, fmt.Errorf("unknown target %q", name)
+		}
+		visit(name)
+	}
+
+	pending := make(map[string]int, len(selected))
+	for name := range selected {
+		pending[name] = len(uniqueStrings(targets[name].dependsOn))
+	}
+
+	var order []string
+	for len(pending) > 0 {
+		var ready []string
+		for _, t := range p.targets {
+			if n, ok := pending[t.name]; ok && n == 0 {
+				ready = append(ready, t.name)
+			}
+		}
+		for _, name := range ready {
+			delete(pending, name)
+			order = append(order, name)
+			for _, t := range p.targets {
+				if _, ok := pending[t.name]; ok && containsString(uniqueStrings(t.dependsOn), name) {
+					pending[t.name]--
+				}
+			}
+		}
+	}
+	return order, nil
+}
+
+// index validates the targets and returns them by name.
+func (p *Project) index() (map[string]*projectTarget, error) {
+	targets := make(map[string]*projectTarget, len(p.targets))
+	for _, t := range p.targets {
+		if t.name == "" {
+			return nil, fmt.Errorf("target name must not be empty")
+		}
+		if t.compiler == nil {
+			return nil, fmt.Errorf("target %q has no compiler", t.name)
+		}
+		if _, ok := targets[t.name]; ok {
+			return nil, fmt.Errorf("duplicate target %q", t.name)
+		}
+		targets[t.name] = t
+	}
+	for _, t := range p.targets {
+		for _, dep := range t.dependsOn {
+			if _, ok := targets[dep]; !ok {
+				return nil, fmt.Errorf("target %q depends on unknown target %q", t.name, dep)
+			}
+		}
+	}
+
+	cycles := findCycles(p.Targets(), func(name string) []string { return targets[name].dependsOn })
+	if len(cycles) > 0 {
+		path := cyclePath(cycles[0], func(name string) []string { return targets[name].dependsOn })
+		return nil, fmt.Errorf("target dependency cycle: %s", strings.Join(path, " -> "))
+	}
+	return targets, nil
+}
+
+// Build compiles the named targets and their dependencies, or all targets
+// if no names are given. Each target starts once all of its dependencies
+// have succeeded; targets whose dependencies failed are skipped.
+//
+// The result lists every selected target. The error is non-nil if the
+// project is invalid or any target failed or was skipped.
+func (p *Project) Build(ctx context.Context, names ...string) (*ProjectResult, error) {
+	order, err := p.Order(names...)
+	if err != nil {
+		return nil, err
+	}
+	targets, _ := p.index()
+
+	parallelism := p.parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	results := make(map[string]*TargetResult, len(order))
+	pending := make(map[string]int, len(order))
+	dependents := make(map[string][]string)
+	for _, name := range order {
+		deps := uniqueStrings(targets[name].dependsOn)
+		pending[name] = len(deps)
+		for _, dep := range deps {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	var ready []string
+	for _, name := range order {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	done := make(chan *TargetResult)
+	running := 0
+	finish := func(result *TargetResult) {
+		results[result.Name] = result
+		for _, dependent := range dependents[result.Name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	for len(results) < len(order) {
+		for len(ready) > 0 && running < parallelism {
+			name := ready[0]
+			ready = ready[1:]
+
+			if reason := p.skipReason(ctx, targets[name], results); reason != nil {
+				finish(&TargetResult{Name: name, Status: TargetSkipped, Err: reason})
+				continue
+			}
+
+			running++
+			go func(t *projectTarget) {
+				details := &CompileResult{Target: t.name}
+				start := time.Now()
+				output, err := t.compiler.compile(ctx, details)
+				details.finish(output, err, time.Since(start))
+				result := &TargetResult{Name: t.name, Output: output, Duration: details.Duration, Result: details}
+				if err != nil {
+					result.Status = TargetFailed
+					result.Err = err
+				}
+				done <- result
+			}(targets[name])
+		}
+		if running == 0 {
+			continue
+		}
+		finish(<-done)
+		running--
+	}
+
+	result := &ProjectResult{}
+	for _, name := range order {
+		result.Targets = append(result.Targets, results[name])
+	}
+	if failed := result.Failed(); len(failed) > 0 {
+		var names []string
+		for _, r := range failed {
+			names = append(names, r.Name)
+		}
+		return result, fmt.Errorf("%d of %d targets did not build: %s", len(failed), len(order), strings.Join(names, ", "))
+	}
+	return result, nil
+}
+
+// skipReason returns why a ready target must not be compiled, or nil.
+func (p *Project) skipReason(ctx context.Context, t *projectTarget, results map[string]*TargetResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, dep := range t.dependsOn {
+		if results[dep].Status != TargetSucceeded {
+			return fmt.Errorf("dependency %q %s", dep, results[dep].Status)
+		}
+	}
+	return nil
+}
+
+// containsString reports whether values contains s.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package protoc_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestProjectOrder(t *testing.T) {
+	c := protoc.NewCompiler()
+	project := protoc.NewProject().
+		AddTarget("app", c, "api", "model").
+		AddTarget("api", c, "model").
+		AddTarget("model", c).
+		AddTarget("docs", c)
+
+	order, err := project.Order()
+	if err != nil {
+		t.Fatalf("Order failed: %v", err)
+	}
+	if want := []string{"model", "docs", "api", "app"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Order() = %v, want %v", order, want)
+	}
+
+	order, err = project.Order("api")
+	if err != nil {
+		t.Fatalf("Order failed: %v", err)
+	}
+	if want := []string{"model", "api"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Order(api) = %v, want %v", order, want)
+	}
+}
+
+func TestProjectErrors(t *testing.T) {
+	c := protoc.NewCompiler()
+	tests := []struct {
+		name    string
+		project *protoc.Project
+		targets []string
+		want    string
+	}{
+		{
+			name:    "unknown dependency",
+			project: protoc.NewProject().AddTarget("a", c, "b"),
+			want:    `target "a" depends on unknown target "b"`,
+		},
+		{
+			name:    "cycle",
+			project: protoc.NewProject().AddTarget("a", c, "c").AddTarget("b", c, "a").AddTarget("c", c, "b"),
+			want:    "target dependency cycle: a -> c -> b -> a",
+		},
+		{
+			name:    "duplicate",
+			project: protoc.NewProject().AddTarget("a", c).AddTarget("a", c),
+			want:    `duplicate target "a"`,
+		},
+		{
+			name:    "unknown selection",
+			project: protoc.NewProject().AddTarget("a", c),
+			targets: []string{"b"},
+			want:    `unknown target "b"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.project.Build(context.Background(), tt.targets...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got: %v", tt.want, err)
+			}
+			if result != nil {
+				t.Errorf("invalid project should not return a result")
+			}
+		})
+	}
+}
+
+func TestProjectBuildFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	broken := protoc.NewCompiler().WithProtoDir(tmpDir).WithProtoWorkSpace(tmpDir)
+	project := protoc.NewProject().
+		AddTarget("model", broken).
+		AddTarget("api", broken, "model").
+		AddTarget("app", broken, "api").
+		WithParallelism(2)
+
+	result, err := project.Build(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "3 of 3 targets did not build") {
+		t.Fatalf("expected build error, got: %v", err)
+	}
+	if len(result.Targets) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(result.Targets))
+	}
+
+	model, _ := result.Target("model")
+	if model.Status != protoc.TargetFailed || !strings.Contains(model.Err.Error(), "output directory not specified") {
+		t.Errorf("model: got %s %v", model.Status, model.Err)
+	}
+	api, _ := result.Target("api")
+	if api.Status != protoc.TargetSkipped || !strings.Contains(api.Err.Error(), `dependency "model" failed`) {
+		t.Errorf("api: got %s %v", api.Status, api.Err)
+	}
+	app, _ := result.Target("app")
+	if app.Status != protoc.TargetSkipped || !strings.Contains(app.Err.Error(), `dependency "api" skipped`) {
+		t.Errorf("app: got %s %v", app.Status, app.Err)
+	}
+	if len(result.Failed()) != 3 {
+		t.Errorf("Failed() = %d results, want 3", len(result.Failed()))
+	}
+	if summary := result.String(); !strings.Contains(summary, "model  failed") {
+		t.Errorf("unexpected summary:\n%s", summary)
+	}
+}
+
+func TestProjectBuildCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := protoc.NewProject().AddTarget("a", protoc.NewCompiler()).Build(ctx)
+	if err == nil {
+		t.Fatal("expected error for cancelled build")
+	}
+	if a, _ := result.Target("a"); a.Status != protoc.TargetSkipped || !errors.Is(a.Err, context.Canceled) {
+		t.Errorf("a: got %s %v", a.Status, a.Err)
+	}
+}
+
+func TestConfigProject(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeProtos(t, filepath.Join(tmpDir, "proto"), map[string]string{
+		"model/user.proto": `syntax = "proto3"; package model; option go_package = "example.com/gen/model";`,
+		"api/api.proto":    `syntax = "proto3"; package api; option go_package = "example.com/gen/api"; import "model/user.proto";`,
+	})
+	path := writeConfig(t, tmpDir, protoc.DefaultConfigFile, `workspace: proto
+output: gen
+targets:
+  - name: api
+    proto_dir: proto/api
+    depends_on: [model]
+  - name: model
+    proto_dir: proto/model
+`)
+
+	cfg, err := protoc.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	api, _ := cfg.Target("api")
+	if want := []string{"model"}; !reflect.DeepEqual(api.DependsOn, want) {
+		t.Errorf("DependsOn = %v, want %v", api.DependsOn, want)
+	}
+	project := cfg.Project()
+	order, err := project.Order()
+	if err != nil {
+		t.Fatalf("Order failed: %v", err)
+	}
+	if want := []string{"model", "api"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Order() = %v, want %v", order, want)
+	}
+
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping compilation")
+	}
+	if _, err := exec.LookPath("protoc-gen-go"); err != nil {
+		t.Skip("protoc-gen-go not available, skipping compilation")
+	}
+	result, err := project.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build failed: %v\n%s", err, result)
+	}
+	for _, name := range []string{"model/user.pb.go", "api/api.pb.go"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, "gen", filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected %s to be generated: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package protoc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WithProtoFS reads the .proto files from a file system instead of the
+// directories set with WithProtoWorkSpace and WithProtoDir, such as an
+// embed.FS or a testing/fstest.MapFS. The root of fsys is the workspace:
+// files import each other by their path in fsys. root is the directory
+// whose files are compiled, "." for all of them; with WithRoots it may be
+// empty. A DirFS, such as one from Dir, is used in place. Others are
+// copied to a temporary workspace for protoc and the plugins, which is
+// removed when the operation returns, so paths in its results, such as
+// Graph nodes, no longer exist afterwards.
+func (c *Compiler) WithProtoFS(fsys fs.FS, root string) *Compiler {
+	c.protoFS = fsys
+	c.protoFSRoot = root
+	return c
+}
+
+// DirFS is a file system reading from a directory of the operating
+// system. WithProtoFS uses it in place instead of copying it.
+type DirFS interface {
+	fs.FS
+	// Dir returns the directory the files are read from.
+	Dir() string
+}
+
+// Dir returns a DirFS for the files below dir, reading them as os.DirFS
+// does.
+func Dir(dir string) DirFS {
+	return dirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func (d dirFS) Dir() string { return d.dir }
+
+// fsDir returns the directory of a DirFS.
+func fsDir(fsys fs.FS) (string, bool) {
+	d, ok := fsys.(DirFS)
+	if !ok || d.Dir() == "" {
+		return "", false
+	}
+	return d.Dir(), true
+}
+
+// openProtoFS makes the file system set with WithProtoFS the workspace and
+// proto directory, copying it to a temporary directory unless it is a
+// directory already. The returned function removes the copy.
+func (c *compilerImpl) openProtoFS() (func(), error) {
+	if c.protoFS == nil {
+		return func() {}, nil
+	}
+	root := path.Clean(c.protoFSRoot)
+	if c.protoFSRoot != "" && !fs.ValidPath(root) {
+		return nil, fmt.Errorf("invalid proto file system root %q", c.protoFSRoot)
+	}
+
+	cleanup := func() {}
+	dir, ok := fsDir(c.protoFS)
+	if !ok {
+		tmp, err := os.MkdirTemp("", "protoc-go-workspace-")
+		if err != nil {
+			return nil, fmt.Errorf("create temporary workspace: %w", err)
+		}
+		if err := copyProtoFS(c.protoFS, tmp); err != nil {
+			os.RemoveAll(tmp)
+			return nil, fmt.Errorf("copy proto files: %w", err)
+		}
+		dir = tmp
+		cleanup = func() { os.RemoveAll(tmp) }
+	}
+
+	c.workspaceDir = dir
+	c.protoDir = ""
+	if c.protoFSRoot != "" || len(c.roots) == 0 {
+		c.protoDir = filepath.Join(dir, filepath.FromSlash(root))
+	}
+	return cleanup, nil
+}
+
+// copyProtoFS copies the .proto files of fsys to dir.
+func copyProtoFS(fsys fs.FS, dir string) error {
+	return fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if entry.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if !strings.HasSuffix(strings.ToLower(name), ".proto") {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0644)
+	})
+}
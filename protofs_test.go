@@ -0,0 +1,109 @@
+package protoc_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestProtoFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/a.proto":  {Data: []byte(`syntax = "proto3"; package a; import "b/b.proto"; message A { b.B b = 1; }`)},
+		"b/b.proto":  {Data: []byte(`syntax = "proto3"; package b; message B {}`)},
+		"README.md":  {Data: []byte("not copied")},
+		"a/skip.txt": {Data: []byte("not a proto file")},
+	}
+	gen := filepath.Join(t.TempDir(), "gen")
+
+	var req *pluginpb.CodeGeneratorRequest
+	record := func(r *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		req = r
+		return &pluginpb.CodeGeneratorResponse{}, nil
+	}
+	_, err := protoc.NewCompiler().
+		WithProtoFS(fsys, "a").
+		WithOutputDir(gen).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins("record").
+		WithGenerator("record", record).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if got := strings.Join(req.GetFileToGenerate(), " "); got != "a/a.proto" {
+		t.Errorf("FileToGenerate = %s, want a/a.proto", got)
+	}
+	if n := len(req.GetProtoFile()); n != 2 {
+		t.Errorf("expected a.proto and its import, got %d files", n)
+	}
+
+	// The temporary workspace is gone after Graph returns
+	graph, err := protoc.NewCompiler().WithProtoFS(fsys, ".").Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if got := strings.Join(graph.Files, " "); got != "a/a.proto b/b.proto" {
+		t.Errorf("Files = %s, want a/a.proto b/b.proto", got)
+	}
+	node := graph.Nodes["a/a.proto"]
+	if node == nil {
+		t.Fatal("expected a/a.proto in the graph")
+	}
+	if _, err := os.Stat(filepath.Dir(filepath.Dir(node.Path))); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary workspace to be removed, got %v", err)
+	}
+}
+
+func TestProtoFSDir(t *testing.T) {
+	workspace := t.TempDir()
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto":        `syntax = "proto3"; package a;`,
+		"a/internal.proto": `syntax = "proto3"; package a;`,
+		"b/b.proto":        `syntax = "proto3"; package b;`,
+	})
+
+	c := protoc.NewCompiler().WithProtoFS(protoc.Dir(workspace), "a").WithExcludes("a/internal.proto")
+	files, err := c.Files(context.Background())
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if got := strings.Join(files, " "); got != "a/a.proto" {
+		t.Errorf("Files = %s, want a/a.proto", got)
+	}
+
+	// A directory is used in place
+	graph, err := c.Graph(context.Background())
+	if err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if got, want := graph.Nodes["a/a.proto"].Path, filepath.Join(workspace, "a", "a.proto"); got != want {
+		t.Errorf("Path = %s, want %s", got, want)
+	}
+}
+
+func TestProtoFSInvalidRoot(t *testing.T) {
+	_, err := protoc.NewCompiler().
+		WithProtoFS(fstest.MapFS{}, "../proto").
+		WithOutputDir(t.TempDir()).
+		Compile()
+	if err == nil || !strings.Contains(err.Error(), `invalid proto file system root "../proto"`) {
+		t.Errorf("error = %v, want invalid root", err)
+	}
+}
+
+func TestProtoFSWatchNeedsDir(t *testing.T) {
+	err := protoc.NewCompiler().
+		WithProtoFS(fstest.MapFS{"a.proto": {Data: []byte(`syntax = "proto3";`)}}, ".").
+		WithOutputDir(t.TempDir()).
+		Watch(context.Background(), func(protoc.Result) {})
+	if err == nil || !strings.Contains(err.Error(), "watch needs a proto file system implementing DirFS") {
+		t.Errorf("error = %v, want a DirFS to be required", err)
+	}
+}
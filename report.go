@@ -0,0 +1,486 @@
+package protoc
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompileResult is the outcome of a compilation in the detail build
+// reports need. Write it with WriteReport.
+type CompileResult struct {
+	Target      string           // Target name; empty outside a Project
+	Workspace   string           // Absolute workspace directory; empty for a copied WithProtoFS
+	Status      TargetStatus     // TargetSucceeded or TargetFailed, or TargetSkipped in a Project
+	Duration    time.Duration    // Time spent compiling
+	Commands    []*CommandResult // Commands run, in order
+	Files       []*FileResult    // Files compiled, in order
+	Diagnostics []Diagnostic     // Problems reported by protoc and the go_package checks
+	Output      string           // Combined protoc output
+	Err         error
+}
+
+// CommandResult is a protoc run, or a run of plugins without protoc.
+type CommandResult struct {
+	Args     []string      // protoc command line; empty when the plugins ran without protoc
+	Plugins  []string      // Plugins run
+	Files    []string      // Files compiled, relative to the workspace
+	Duration time.Duration // Time the command took
+	Err      error
+}
+
+// FileResult is the outcome of compiling a single file. Its status is
+// TargetFailed if protoc reported an error in the file, TargetSkipped if
+// a command compiling it failed because of another file or never ran,
+// and TargetSucceeded otherwise.
+type FileResult struct {
+	Name        string        // Path relative to the workspace
+	Status      TargetStatus  // Outcome, see above
+	Duration    time.Duration // Time of the commands compiling the file
+	Diagnostics []Diagnostic  // Problems in the file
+}
+
+// CompileWithResult compiles like Compile and returns a detailed result,
+// which is also returned if compilation fails. The error is the result's
+// Err.
+func (c *Compiler) CompileWithResult(ctx context.Context) (*CompileResult, error) {
+	result := &CompileResult{}
+	start := time.Now()
+	output, err := c.compile(ctx, result)
+	result.finish(output, err, time.Since(start))
+	return result, err
+}
+
+// recordCommand adds a command to the result, if one is collected.
+func (c *compilerImpl) recordCommand(args, plugins, files []string, start time.Time, err error) {
+	if c.result == nil {
+		return
+	}
+	names, _ := c.importNames(files)
+	c.result.Commands = append(c.result.Commands, &CommandResult{
+		Args:     args,
+		Plugins:  plugins,
+		Files:    names,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+}
+
+// recordFiles sets the files of the result, if one is collected.
+func (c *compilerImpl) recordFiles(files []string) {
+	if c.result == nil {
+		return
+	}
+	names, _ := c.importNames(files)
+	for _, name := range names {
+		c.result.Files = append(c.result.Files, &FileResult{Name: name, Status: TargetSkipped})
+	}
+}
+
+// recordParseError adds a syntax error found before protoc ran to the
+// result, if one is collected.
+func (c *compilerImpl) recordParseError(err error) {
+	var perr *parseError
+	if c.result == nil || !errors.As(err, &perr) {
+		return
+	}
+	name, nerr := c.importName(perr.file)
+	if nerr != nil {
+		name = filepath.ToSlash(perr.file)
+	}
+	c.result.Diagnostics = append(c.result.Diagnostics, Diagnostic{
+		File:     name,
+		Line:     perr.pos.line,
+		Column:   perr.pos.col,
+		Message:  perr.msg,
+		Severity: SeverityError,
+	})
+}
+
+// finish completes a result from the outcome of the compilation.
+func (r *CompileResult) finish(output string, err error, d time.Duration) {
+	r.Output, r.Err, r.Duration = output, err, d
+	r.Status = TargetSucceeded
+	if err != nil {
+		r.Status = TargetFailed
+	}
+	// The pure-Go backend reports the errors in err rather than the output
+	found := protocDiagnostics(output)
+	if err != nil {
+		found = append(found, protocDiagnostics(err.Error())...)
+	}
+	for _, d := range found {
+		if !containsDiagnostic(r.Diagnostics, d) {
+			r.Diagnostics = append(r.Diagnostics, d)
+		}
+	}
+	sortDiagnostics(r.Diagnostics)
+
+	for _, f := range r.Files {
+		f.Diagnostics, f.Duration = nil, 0
+		ran, failed := false, false
+		for _, cmd := range r.Commands {
+			if containsString(cmd.Files, f.Name) {
+				ran = true
+				failed = failed || cmd.Err != nil
+				f.Duration += cmd.Duration
+			}
+		}
+		for _, d := range r.Diagnostics {
+			if d.File == f.Name {
+				f.Diagnostics = append(f.Diagnostics, d)
+			}
+		}
+		switch {
+		case hasErrors(f.Diagnostics):
+			f.Status = TargetFailed
+		case !ran || failed:
+			f.Status = TargetSkipped
+		default:
+			f.Status = TargetSucceeded
+		}
+	}
+}
+
+func containsDiagnostic(diags []Diagnostic, d Diagnostic) bool {
+	for _, other := range diags {
+		if other == d {
+			return true
+		}
+	}
+	return false
+}
+
+// hasErrors reports whether any of the diagnostics is an error.
+func hasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity != SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+// protocMessage matches a line of protoc output about a file:
+// "file.proto:line:column: message" or "file.proto: message".
+var protocMessage = regexp.MustCompile(`^(\S+?\.proto)(?::(\d+):(\d+))?: (.*)$`)
+
+// protocDiagnostics parses the errors and warnings protoc reports about
+// files. Other output, such as plugin failures, is left out.
+func protocDiagnostics(output string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		m := protocMessage.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: filepath.ToSlash(m[1]), Message: m[4], Severity: SeverityError}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if msg, ok := cutPrefixFold(d.Message, "warning: "); ok {
+			d.Message, d.Severity = msg, SeverityWarning
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// Results returns the result of every target, in dependency order, with
+// skipped targets as results with status TargetSkipped.
+func (r *ProjectResult) Results() []*CompileResult {
+	results := make([]*CompileResult, 0, len(r.Targets))
+	for _, t := range r.Targets {
+		result := t.Result
+		if result == nil {
+			result = &CompileResult{Status: t.Status, Err: t.Err}
+		}
+		result.Target = t.Name
+		results = append(results, result)
+	}
+	return results
+}
+
+// ReportFormat is a build report format for WriteReport.
+type ReportFormat string
+
+const (
+	// ReportJSON is a JSON document with the details of every result.
+	ReportJSON ReportFormat = "json"
+	// ReportJUnit is JUnit XML with a test suite per target and a test
+	// case per file, as read by CI systems.
+	ReportJUnit ReportFormat = "junit"
+	// ReportSARIF is a SARIF 2.1.0 log of the diagnostics, with a run per
+	// target, for code scanning tools. See WriteSARIF. Only the Target,
+	// Workspace and Diagnostics of the results are used, so it can also
+	// report the findings of Lint and Breaking.
+	ReportSARIF ReportFormat = "sarif"
+)
+
+// ParseReportFormat returns the report format with the given name, "json",
+// "junit" or "sarif".
+func ParseReportFormat(name string) (ReportFormat, error) {
+	switch format := ReportFormat(strings.ToLower(name)); format {
+	case ReportJSON, ReportJUnit, ReportSARIF:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown report format %q, expected json, junit or sarif", name)
+}
+
+// WriteReport writes a build report of the results in the given format.
+func WriteReport(w io.Writer, format ReportFormat, results ...*CompileResult) error {
+	switch format {
+	case ReportJSON:
+		return WriteJSONReport(w, results...)
+	case ReportJUnit:
+		return WriteJUnitReport(w, results...)
+	case ReportSARIF:
+		runs := make([]*sarifRun, len(results))
+		for i, r := range results {
+			runs[i] = newSARIFRun(r.Target, r.Workspace, r.Diagnostics)
+		}
+		return writeSARIF(w, runs...)
+	}
+	return fmt.Errorf("unknown report format %q, expected json, junit or sarif", format)
+}
+
+// jsonReport is the document written by WriteJSONReport.
+type jsonReport struct {
+	OK      bool          `json:"ok"`
+	Targets []*jsonTarget `json:"targets"`
+}
+
+type jsonTarget struct {
+	Name        string         `json:"name,omitempty"`
+	Workspace   string         `json:"workspace,omitempty"`
+	Status      string         `json:"status"`
+	DurationMS  int64          `json:"duration_ms"`
+	Error       string         `json:"error,omitempty"`
+	Commands    []*jsonCommand `json:"commands"`
+	Files       []*jsonFile    `json:"files"`
+	Diagnostics []Diagnostic   `json:"diagnostics"`
+	Output      string         `json:"output,omitempty"`
+}
+
+type jsonCommand struct {
+	Args       []string `json:"args"`
+	Plugins    []string `json:"plugins"`
+	Files      []string `json:"files"`
+	DurationMS int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type jsonFile struct {
+	Name        string       `json:"name"`
+	Status      string       `json:"status"`
+	DurationMS  int64        `json:"duration_ms"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// WriteJSONReport writes the results as a JSON document with an "ok" field
+// and one entry per target listing its commands, files and diagnostics.
+// Durations are in milliseconds.
+func WriteJSONReport(w io.Writer, results ...*CompileResult) error {
+	rep := &jsonReport{OK: true, Targets: []*jsonTarget{}}
+	for _, r := range results {
+		t := &jsonTarget{
+			Name:        r.Target,
+			Workspace:   r.Workspace,
+			Status:      r.Status.String(),
+			DurationMS:  r.Duration.Milliseconds(),
+			Error:       errorString(r.Err),
+			Commands:    []*jsonCommand{},
+			Files:       []*jsonFile{},
+			Diagnostics: append([]Diagnostic{}, r.Diagnostics...),
+			Output:      r.Output,
+		}
+		rep.OK = rep.OK && r.Status == TargetSucceeded
+		for _, cmd := range r.Commands {
+			t.Commands = append(t.Commands, &jsonCommand{
+				Args:       append([]string{}, cmd.Args...),
+				Plugins:    cmd.Plugins,
+				Files:      cmd.Files,
+				DurationMS: cmd.Duration.Milliseconds(),
+				Error:      errorString(cmd.Err),
+			})
+		}
+		for _, f := range r.Files {
+			t.Files = append(t.Files, &jsonFile{
+				Name:        f.Name,
+				Status:      f.Status.String(),
+				DurationMS:  f.Duration.Milliseconds(),
+				Diagnostics: f.Diagnostics,
+			})
+		}
+		rep.Targets = append(rep.Targets, t)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// JUnit XML elements, in the dialect understood by common CI systems.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties"`
+	Cases      []junitCase      `xml:"testcase"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+}
+
+type junitProperties struct {
+	Items []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnitReport writes the results as JUnit XML: a test suite per
+// target with a test case per file, failed when protoc reported errors in
+// the file and skipped when it was not compiled. A target that failed for
+// another reason, or was skipped, gets a test case named after the target.
+// Warnings go to the system-out of the test case, and the protoc commands
+// to the properties of the suite.
+func WriteJUnitReport(w io.Writer, results ...*CompileResult) error {
+	doc := junitSuites{}
+	var total time.Duration
+	for _, r := range results {
+		name := r.Target
+		if name == "" {
+			name = "default"
+		}
+		suite := junitSuite{Name: name, Time: junitTime(r.Duration), SystemOut: r.Output}
+		for i, cmd := range r.Commands {
+			value := strings.Join(cmd.Args, " ")
+			if len(cmd.Args) == 0 {
+				value = strings.Join(cmd.Plugins, ",") + " without protoc"
+			}
+			if suite.Properties == nil {
+				suite.Properties = &junitProperties{}
+			}
+			suite.Properties.Items = append(suite.Properties.Items, junitProperty{Name: "command." + strconv.Itoa(i+1), Value: value})
+		}
+
+		fileFailed := false
+		for _, f := range r.Files {
+			tc := junitCase{Name: f.Name, ClassName: name, Time: junitTime(f.Duration)}
+			var errs, warnings []string
+			for _, d := range f.Diagnostics {
+				if d.Severity == SeverityWarning {
+					warnings = append(warnings, d.String())
+				} else {
+					errs = append(errs, d.String())
+				}
+			}
+			switch f.Status {
+			case TargetFailed:
+				fileFailed = true
+				tc.Failure = &junitMessage{Message: firstLine(errs), Text: strings.Join(errs, "\n")}
+			case TargetSkipped:
+				tc.Skipped = &junitMessage{Message: "not compiled"}
+			}
+			tc.SystemOut = strings.Join(warnings, "\n")
+			suite.Cases = append(suite.Cases, tc)
+		}
+		if r.Status == TargetSkipped || r.Status == TargetFailed && !fileFailed {
+			tc := junitCase{Name: name, ClassName: name, Time: junitTime(r.Duration)}
+			msg := &junitMessage{Message: firstLine([]string{errorString(r.Err)}), Text: errorString(r.Err)}
+			if r.Status == TargetSkipped {
+				tc.Skipped = msg
+			} else {
+				tc.Failure = msg
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		for _, tc := range suite.Cases {
+			suite.Tests++
+			if tc.Failure != nil {
+				suite.Failures++
+			}
+			if tc.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Skipped += suite.Skipped
+		total += r.Duration
+		doc.Suites = append(doc.Suites, suite)
+	}
+	doc.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// junitTime formats a duration in seconds, as JUnit expects.
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func firstLine(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	first, _, _ := strings.Cut(lines[0], "\n")
+	return first
+}
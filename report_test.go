@@ -0,0 +1,157 @@
+package protoc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func noOutput(*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	return &pluginpb.CodeGeneratorResponse{}, nil
+}
+
+func TestCompileWithResult(t *testing.T) {
+	workspace := t.TempDir()
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto": `syntax = "proto3"; package a; import "b/b.proto"; message A { b.B b = 1; }`,
+		"b/b.proto": `syntax = "proto3"; package b; message B {}`,
+	})
+
+	result, err := protoc.NewCompiler().
+		WithProtoDir(workspace).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(filepath.Join(t.TempDir(), "gen")).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins("none").
+		WithGenerator("none", noOutput).
+		CompileWithResult(context.Background())
+	if err != nil {
+		t.Fatalf("CompileWithResult failed: %v", err)
+	}
+	if result.Status != protoc.TargetSucceeded || result.Duration <= 0 {
+		t.Errorf("Status = %v, Duration = %v", result.Status, result.Duration)
+	}
+	if len(result.Commands) != 1 || len(result.Commands[0].Args) != 0 || strings.Join(result.Commands[0].Plugins, ",") != "none" {
+		t.Fatalf("unexpected commands: %+v", result.Commands)
+	}
+	var names []string
+	for _, f := range result.Files {
+		names = append(names, f.Name)
+		if f.Status != protoc.TargetSucceeded {
+			t.Errorf("%s: Status = %v, want succeeded", f.Name, f.Status)
+		}
+	}
+	if got := strings.Join(names, " "); got != "a/a.proto b/b.proto" {
+		t.Errorf("Files = %s, want a/a.proto b/b.proto", got)
+	}
+
+	var buf bytes.Buffer
+	if err := protoc.WriteJSONReport(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	var rep struct {
+		OK      bool `json:"ok"`
+		Targets []struct {
+			Status string `json:"status"`
+			Files  []struct {
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			} `json:"files"`
+		} `json:"targets"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if !rep.OK || len(rep.Targets) != 1 || rep.Targets[0].Status != "succeeded" || len(rep.Targets[0].Files) != 2 {
+		t.Errorf("unexpected report: %s", buf.String())
+	}
+}
+
+func TestCompileWithResultFailure(t *testing.T) {
+	workspace := t.TempDir()
+	writeProtos(t, workspace, map[string]string{
+		"a.proto": `syntax = "proto3"; message A {}`,
+		"b.proto": `syntax = "proto3"; message B { int32 }`,
+	})
+
+	c := protoc.NewCompiler().
+		WithProtoDir(workspace).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(t.TempDir()).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins("none").
+		WithGenerator("none", noOutput)
+	project := protoc.NewProject().
+		AddTarget("api", c).
+		AddTarget("client", protoc.NewCompiler().WithProtoDir(workspace).WithProtoWorkSpace(workspace).WithOutputDir(t.TempDir()), "api")
+	built, err := project.Build(context.Background())
+	if err == nil {
+		t.Fatal("expected the build to fail")
+	}
+	results := built.Results()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	api, client := results[0], results[1]
+	if api.Target != "api" || api.Status != protoc.TargetFailed || client.Status != protoc.TargetSkipped {
+		t.Fatalf("unexpected results: %+v, %+v", api, client)
+	}
+	status := map[string]protoc.TargetStatus{}
+	for _, f := range api.Files {
+		status[f.Name] = f.Status
+	}
+	if status["a.proto"] != protoc.TargetSkipped || status["b.proto"] != protoc.TargetFailed {
+		t.Errorf("file status = %v, want a.proto skipped and b.proto failed", status)
+	}
+	if len(api.Diagnostics) == 0 || api.Diagnostics[0].File != "b.proto" || api.Diagnostics[0].Line != 1 || api.Diagnostics[0].Severity != protoc.SeverityError {
+		t.Errorf("unexpected diagnostics: %v", api.Diagnostics)
+	}
+
+	var buf bytes.Buffer
+	if err := protoc.WriteReport(&buf, protoc.ReportJUnit, results...); err != nil {
+		t.Fatal(err)
+	}
+	var suites struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Suites   []struct {
+			Name  string `xml:"name,attr"`
+			Cases []struct {
+				Name    string `xml:"name,attr"`
+				Failure *struct {
+					Text string `xml:",chardata"`
+				} `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, buf.String())
+	}
+	// a.proto and the client target are skipped, b.proto failed
+	if suites.Tests != 3 || suites.Failures != 1 || suites.Skipped != 2 || len(suites.Suites) != 2 {
+		t.Fatalf("unexpected report:\n%s", buf.String())
+	}
+	for _, tc := range suites.Suites[0].Cases {
+		if tc.Name == "b.proto" && (tc.Failure == nil || !strings.Contains(tc.Failure.Text, "b.proto:1:")) {
+			t.Errorf("expected the syntax error in the failure of b.proto:\n%s", buf.String())
+		}
+	}
+}
+
+func TestParseReportFormat(t *testing.T) {
+	if f, err := protoc.ParseReportFormat("JUnit"); err != nil || f != protoc.ReportJUnit {
+		t.Errorf("ParseReportFormat(JUnit) = %v, %v", f, err)
+	}
+	if _, err := protoc.ParseReportFormat("html"); err == nil || !strings.Contains(err.Error(), `unknown report format "html"`) {
+		t.Errorf("error = %v, want unknown format", err)
+	}
+}
@@ -0,0 +1,254 @@
+package protoc
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sarifCompileRule is the SARIF rule of diagnostics without a rule, the
+// errors and warnings of protoc and the .proto parser.
+const sarifCompileRule = "COMPILE"
+
+// sarifWorkspace is the base URI of SARIF locations, the workspace
+// directory the files of diagnostics are relative to.
+const sarifWorkspace = "WORKSPACE"
+
+// sarifRuleInfo describes a rule in the tool metadata of a SARIF log.
+type sarifRuleInfo struct {
+	description string
+	level       string // Default level: error, warning or note
+}
+
+// sarifRules describes the rules of every diagnostic the package reports.
+var sarifRules = map[string]sarifRuleInfo{
+	sarifCompileRule: {"The file does not compile.", "error"},
+
+	goPackageMissing:   {"Files compiled by a Go plugin need a go_package option or a mapping.", "error"},
+	goPackageModule:    {"The go_package import path must be inside the module= prefix of the Go plugin.", "error"},
+	goPackageLocation:  {"Generated Go code must land in the directory of its package.", "error"},
+	goPackageCollision: {"Files must not generate conflicting Go packages or the same file.", "error"},
+
+	LintPackageDefined:           {"Every file declares a package.", "warning"},
+	LintPackageDirectoryMatch:    {"The package matches the file's directory relative to the workspace.", "warning"},
+	LintGoPackageDefined:         {"Every file sets the go_package option.", "warning"},
+	LintGoPackageConsistent:      {"Files of the same package share the same go_package import path.", "warning"},
+	LintEnumZeroValueSuffix:      {"The zero value of an enum ends in _UNSPECIFIED.", "warning"},
+	LintFieldLowerSnakeCase:      {"Field names are lower_snake_case.", "warning"},
+	LintServicePascalCase:        {"Service names are PascalCase.", "warning"},
+	LintServiceSuffix:            {"Service names end in Service.", "warning"},
+	LintRPCPascalCase:            {"RPC names are PascalCase.", "warning"},
+	LintRPCRequestResponseUnique: {"Every message is the request or response of at most one RPC.", "warning"},
+
+	BreakingFileNoDelete:         {"Files are not deleted.", "error"},
+	BreakingMessageNoDelete:      {"Messages are not deleted.", "error"},
+	BreakingEnumNoDelete:         {"Enums are not deleted.", "error"},
+	BreakingFieldSameName:        {"Fields keep their name.", "error"},
+	BreakingFieldSameJSONName:    {"Fields keep their JSON name.", "error"},
+	BreakingEnumValueSameName:    {"Enum values keep their name.", "error"},
+	BreakingReservedNameNoDelete: {"Reserved names are not removed.", "error"},
+	BreakingFileSamePackage:      {"Files keep their package.", "error"},
+	BreakingFieldNoDelete:        {"Fields are not deleted.", "error"},
+	BreakingFieldSameNumber:      {"Fields keep their number.", "error"},
+	BreakingFieldSameType:        {"Fields keep their type.", "error"},
+	BreakingFieldSameCardinality: {"Fields keep their cardinality.", "error"},
+	BreakingEnumValueNoDelete:    {"Enum values are not deleted.", "error"},
+	BreakingReservedNoDelete:     {"Reserved numbers and ranges are not removed.", "error"},
+	BreakingServiceNoDelete:      {"Services are not deleted.", "error"},
+	BreakingRPCNoDelete:          {"RPCs are not deleted.", "error"},
+	BreakingRPCSameRequestType:   {"RPCs keep their request type.", "error"},
+	BreakingRPCSameResponseType:  {"RPCs keep their response type.", "error"},
+	BreakingRPCSameStreaming:     {"RPCs keep their streaming mode.", "error"},
+}
+
+// SARIF 2.1.0 objects, limited to the properties written here.
+type sarifLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                    `json:"tool"`
+	AutomationDetails  *sarifAutomation             `json:"automationDetails,omitempty"`
+	OriginalURIBaseIDs map[string]sarifArtifactPath `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string        `json:"id"`
+	ShortDescription     *sarifMessage `json:"shortDescription,omitempty"`
+	DefaultConfiguration *sarifConfig  `json:"defaultConfiguration,omitempty"`
+}
+
+type sarifConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifAutomation struct {
+	ID string `json:"id"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactPath `json:"artifactLocation"`
+	Region           *sarifRegion      `json:"region,omitempty"`
+}
+
+type sarifArtifactPath struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// WriteSARIF writes diagnostics, such as the findings of Lint or Breaking,
+// as a SARIF 2.1.0 log for code scanning tools. Locations are relative to
+// the WORKSPACE base URI, which is defined as workspace, the directory the
+// files of the diagnostics are relative to, unless it is empty. Each rule
+// reported is described in the tool metadata. Diagnostics without a rule,
+// the errors protoc reports, use the rule COMPILE.
+//
+// A diagnostic with a Severity is reported at that level. Otherwise lint
+// findings are warnings and everything else is an error.
+func WriteSARIF(w io.Writer, workspace string, diags []Diagnostic) error {
+	return writeSARIF(w, newSARIFRun("", workspace, diags))
+}
+
+// writeSARIF writes a SARIF log of the runs.
+func writeSARIF(w io.Writer, runs ...*sarifRun) error {
+	doc := &sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    runs,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// newSARIFRun converts diagnostics into a SARIF run. A non-empty id
+// distinguishes the runs of several targets.
+func newSARIFRun(id, workspace string, diags []Diagnostic) *sarifRun {
+	run := &sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "protoc-go",
+			InformationURI: "https://github.com/dongrv/protoc-go",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	if id != "" {
+		run.AutomationDetails = &sarifAutomation{ID: id + "/"}
+	}
+	if workspace != "" {
+		if uri, ok := fileURI(workspace); ok {
+			run.OriginalURIBaseIDs = map[string]sarifArtifactPath{sarifWorkspace: {URI: uri}}
+		}
+	}
+
+	var ids []string
+	for _, d := range diags {
+		if id := sarifRuleID(d); !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		rule := sarifRule{ID: id}
+		if info, ok := sarifRules[id]; ok {
+			rule.ShortDescription = &sarifMessage{Text: info.description}
+			rule.DefaultConfiguration = &sarifConfig{Level: info.level}
+		}
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+	}
+
+	for _, d := range diags {
+		id := sarifRuleID(d)
+		loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifactPath{
+			URI:       (&url.URL{Path: filepath.ToSlash(d.File)}).String(),
+			URIBaseID: sarifWorkspace,
+		}}
+		if d.Line > 0 {
+			loc.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    id,
+			RuleIndex: sort.SearchStrings(ids, id),
+			Level:     sarifLevel(d),
+			Message:   sarifMessage{Text: d.Message},
+			Locations: []sarifLocation{{PhysicalLocation: loc}},
+		})
+	}
+	return run
+}
+
+// sarifRuleID returns the SARIF rule of a diagnostic.
+func sarifRuleID(d Diagnostic) string {
+	if d.Rule == "" {
+		return sarifCompileRule
+	}
+	return d.Rule
+}
+
+// sarifLevel maps the severity of a diagnostic to a SARIF level.
+func sarifLevel(d Diagnostic) string {
+	switch d.Severity {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	if isLintRule(d.Rule) {
+		return "warning"
+	}
+	return "error"
+}
+
+// fileURI returns the file URI of a directory, with the trailing slash
+// SARIF base URIs need.
+func fileURI(dir string) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // Windows drive letter
+	}
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String(), true
+}
@@ -0,0 +1,159 @@
+package protoc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// sarifLog is the part of a SARIF log the tests check.
+type sarifLog struct {
+	Version string `json:"version"`
+	Runs    []struct {
+		Tool struct {
+			Driver struct {
+				Name  string `json:"name"`
+				Rules []struct {
+					ID                   string `json:"id"`
+					DefaultConfiguration *struct {
+						Level string `json:"level"`
+					} `json:"defaultConfiguration"`
+				} `json:"rules"`
+			} `json:"driver"`
+		} `json:"tool"`
+		AutomationDetails *struct {
+			ID string `json:"id"`
+		} `json:"automationDetails"`
+		OriginalURIBaseIDs map[string]struct {
+			URI string `json:"uri"`
+		} `json:"originalUriBaseIds"`
+		Results []struct {
+			RuleID    string `json:"ruleId"`
+			RuleIndex int    `json:"ruleIndex"`
+			Level     string `json:"level"`
+			Message   struct {
+				Text string `json:"text"`
+			} `json:"message"`
+			Locations []struct {
+				PhysicalLocation struct {
+					ArtifactLocation struct {
+						URI       string `json:"uri"`
+						URIBaseID string `json:"uriBaseId"`
+					} `json:"artifactLocation"`
+					Region *struct {
+						StartLine   int `json:"startLine"`
+						StartColumn int `json:"startColumn"`
+					} `json:"region"`
+				} `json:"physicalLocation"`
+			} `json:"locations"`
+		} `json:"results"`
+	} `json:"runs"`
+}
+
+func decodeSARIF(t *testing.T, data []byte) *sarifLog {
+	t.Helper()
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("invalid SARIF: %v\n%s", err, data)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) == 0 || log.Runs[0].Tool.Driver.Name != "protoc-go" {
+		t.Fatalf("unexpected SARIF log:\n%s", data)
+	}
+	return &log
+}
+
+func TestWriteSARIF(t *testing.T) {
+	workspace := t.TempDir()
+	diags := []protoc.Diagnostic{
+		{File: "api/api.proto", Line: 3, Column: 9, Rule: protoc.LintServiceSuffix, Message: `service name "Api" should end in "Service"`},
+		{File: "api/api.proto", Line: 1, Column: 1, Message: "Import b.proto is unused.", Severity: protoc.SeverityWarning},
+		{File: "old file.proto", Rule: "FILE_NO_DELETE", Message: "file was deleted"},
+	}
+
+	var buf bytes.Buffer
+	if err := protoc.WriteSARIF(&buf, workspace, diags); err != nil {
+		t.Fatal(err)
+	}
+	run := decodeSARIF(t, buf.Bytes()).Runs[0]
+
+	var rules []string
+	for _, r := range run.Tool.Driver.Rules {
+		rules = append(rules, r.ID)
+		if r.DefaultConfiguration == nil {
+			t.Errorf("rule %s has no metadata", r.ID)
+		}
+	}
+	if got := strings.Join(rules, " "); got != "COMPILE FILE_NO_DELETE SERVICE_SUFFIX" {
+		t.Errorf("rules = %s", got)
+	}
+	base := run.OriginalURIBaseIDs["WORKSPACE"].URI
+	if !strings.HasPrefix(base, "file://") || !strings.HasSuffix(base, filepath.ToSlash(workspace)+"/") {
+		t.Errorf("WORKSPACE = %q, want the file URI of %s", base, workspace)
+	}
+
+	if len(run.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(run.Results))
+	}
+	want := []struct {
+		rule, level, uri string
+		line             int
+	}{
+		{"SERVICE_SUFFIX", "warning", "api/api.proto", 3},
+		{"COMPILE", "warning", "api/api.proto", 1},
+		{"FILE_NO_DELETE", "error", "old%20file.proto", 0},
+	}
+	for i, w := range want {
+		r := run.Results[i]
+		loc := r.Locations[0].PhysicalLocation
+		if r.RuleID != w.rule || r.Level != w.level || loc.ArtifactLocation.URI != w.uri || loc.ArtifactLocation.URIBaseID != "WORKSPACE" {
+			t.Errorf("result %d = %s %s %s, want %s %s %s", i, r.RuleID, r.Level, loc.ArtifactLocation.URI, w.rule, w.level, w.uri)
+		}
+		if rules[r.RuleIndex] != r.RuleID {
+			t.Errorf("result %d: ruleIndex %d is not %s", i, r.RuleIndex, r.RuleID)
+		}
+		if (loc.Region == nil) != (w.line == 0) || loc.Region != nil && loc.Region.StartLine != w.line {
+			t.Errorf("result %d: unexpected region %+v", i, loc.Region)
+		}
+	}
+}
+
+func TestSARIFReport(t *testing.T) {
+	workspace := t.TempDir()
+	writeProtos(t, workspace, map[string]string{
+		"a.proto": `syntax = "proto3"; message A { int32 }`,
+	})
+
+	result, err := protoc.NewCompiler().
+		WithProtoDir(workspace).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(t.TempDir()).
+		WithBackend(protoc.GoBackend{}).
+		WithPlugins("none").
+		WithGenerator("none", noOutput).
+		CompileWithResult(context.Background())
+	if err == nil {
+		t.Fatal("expected the compilation to fail")
+	}
+	result.Target = "api"
+
+	var buf bytes.Buffer
+	if err := protoc.WriteReport(&buf, protoc.ReportSARIF, result); err != nil {
+		t.Fatal(err)
+	}
+	run := decodeSARIF(t, buf.Bytes()).Runs[0]
+	if run.AutomationDetails == nil || run.AutomationDetails.ID != "api/" {
+		t.Errorf("expected the target in the automation details:\n%s", buf.String())
+	}
+	if len(run.Results) != 1 {
+		t.Fatalf("expected the syntax error:\n%s", buf.String())
+	}
+	r := run.Results[0]
+	if r.RuleID != "COMPILE" || r.Level != "error" || r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "a.proto" {
+		t.Errorf("unexpected result:\n%s", buf.String())
+	}
+}
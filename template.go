@@ -0,0 +1,623 @@
+package protoc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// TemplateData is the data the templates of TemplateGenerator are executed
+// with.
+type TemplateData struct {
+	// File is the file being generated, for templates rendered once per
+	// file. It is nil for templates rendered once per run.
+	File   *TemplateFile
+	Files  []*TemplateFile   // All files to generate
+	Params map[string]string // Plugin options; options without "=" map to ""
+}
+
+// TemplateFile describes a .proto file for templates.
+type TemplateFile struct {
+	Name          string // Name relative to the include path, e.g. "a/b.proto"
+	Package       string
+	Syntax        string // "proto2", "proto3" or "editions"
+	Imports       []string
+	GoImportPath  string // From the go_package option, if any
+	GoPackageName string
+	Comments      string // Comments of the package statement
+	Messages      []*TemplateMessage
+	Enums         []*TemplateEnum
+	Services      []*TemplateService
+	Options       *descriptorpb.FileOptions
+	Descriptor    *descriptorpb.FileDescriptorProto
+}
+
+// TemplateMessage describes a message for templates. Map entry messages are
+// not listed; their fields describe them instead.
+type TemplateMessage struct {
+	Name       string
+	FullName   string // Including the package, e.g. "a.Outer.Inner"
+	GoName     string // Name of the Go type protoc-gen-go generates
+	Comments   string
+	Fields     []*TemplateField
+	Messages   []*TemplateMessage // Nested messages
+	Enums      []*TemplateEnum    // Nested enums
+	Options    *descriptorpb.MessageOptions
+	Descriptor *descriptorpb.DescriptorProto
+}
+
+// TemplateField describes a message field for templates.
+type TemplateField struct {
+	Name     string
+	JSONName string
+	GoName   string
+	Number   int32
+	// Type is the scalar type, e.g. "string", or the full name of the
+	// message or enum type.
+	Type     string
+	Kind     string // "scalar", "message" or "enum"
+	GoType   string // Go type of the field in protoc-gen-go code, e.g. "[]*b.B"
+	Repeated bool
+	Optional bool           // Whether the field has explicit presence
+	Map      bool           // Whether the field is a map, described by MapKey and MapValue
+	MapKey   *TemplateField // Key of a map field
+	MapValue *TemplateField // Value of a map field
+	Comments string
+	Options  *descriptorpb.FieldOptions
+
+	Descriptor *descriptorpb.FieldDescriptorProto
+}
+
+// TemplateEnum describes an enum for templates.
+type TemplateEnum struct {
+	Name       string
+	FullName   string
+	GoName     string
+	Comments   string
+	Values     []*TemplateEnumValue
+	Options    *descriptorpb.EnumOptions
+	Descriptor *descriptorpb.EnumDescriptorProto
+}
+
+// TemplateEnumValue describes an enum value for templates.
+type TemplateEnumValue struct {
+	Name     string
+	Number   int32
+	Comments string
+	Options  *descriptorpb.EnumValueOptions
+}
+
+// TemplateService describes a service for templates.
+type TemplateService struct {
+	Name       string
+	FullName   string
+	Comments   string
+	Methods    []*TemplateMethod
+	Options    *descriptorpb.ServiceOptions
+	Descriptor *descriptorpb.ServiceDescriptorProto
+}
+
+// TemplateMethod describes an RPC for templates.
+type TemplateMethod struct {
+	Name            string
+	Input           string // Full name of the request message
+	Output          string // Full name of the response message
+	InputGoType     string // Go type of the request message, e.g. "*a.Request"
+	OutputGoType    string
+	ClientStreaming bool
+	ServerStreaming bool
+	Comments        string
+	Options         *descriptorpb.MethodOptions
+}
+
+// TemplateGenerator returns a generator, for WithGenerator, that renders the
+// text/template files below dir over the files to generate. Every file
+// ending in .tmpl is a template; its path relative to dir without the .tmpl
+// suffix is the output path. Files whose name starts with "_" are not
+// rendered but define templates the others can use.
+//
+// The output path is a template too. A template whose path contains an
+// action, such as "{{.File.Package}}/repository.go.tmpl", is rendered once
+// per file with TemplateData.File set; any other is rendered once per run.
+// Outputs consisting only of white space are not written, so a template can
+// skip files, e.g. ones without services.
+//
+// Besides the text/template builtins, templates can use goName (the Go
+// identifier protoc-gen-go derives from a proto name), lowerCamel,
+// snakeCase, upper, lower, trimPrefix, trimSuffix, replace, split, join,
+// hasPrefix, hasSuffix, contains, base, dir and ext. The functions taking
+// several arguments take the string they operate on last, for pipelines:
+// {{.Name | trimSuffix "Request"}}.
+//
+// Without a generator registered with WithGenerator, the plugin named
+// "template" is this generator, taking the directory from its dir option,
+// e.g. WithPluginOpts("template", "dir=templates").
+func TemplateGenerator(dir string) GeneratorFunc {
+	return func(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		return renderTemplates(dir, req)
+	}
+}
+
+// templatePlugin is the plugin name of the built-in template generator.
+const templatePlugin = "template"
+
+// templatePluginGenerator is the built-in template plugin, which takes the
+// template directory from its options.
+func templatePluginGenerator(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	dir := templateParams(req.GetParameter())["dir"]
+	if dir == "" {
+		return nil, fmt.Errorf("the template plugin needs a dir option with the template directory")
+	}
+	return renderTemplates(dir, req)
+}
+
+// templateParams parses a plugin parameter of comma-separated options.
+func templateParams(parameter string) map[string]string {
+	params := make(map[string]string)
+	for _, opt := range strings.Split(parameter, ",") {
+		if opt == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(opt, "=")
+		params[key] = value
+	}
+	return params
+}
+
+// renderTemplates renders the templates in dir for a request.
+func renderTemplates(dir string, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var templates, partials []string
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(file, ".tmpl") {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), "_") {
+			partials = append(partials, file)
+		} else {
+			templates = append(templates, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read templates: %w", err)
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("no templates found in %s", dir)
+	}
+
+	base := template.New("").Funcs(templateFuncs)
+	for _, file := range partials {
+		name, content, err := readTemplate(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := base.New(name).Parse(content); err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+	}
+
+	files := templateFiles(req)
+	params := templateParams(req.GetParameter())
+	resp := &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)),
+	}
+	for _, file := range templates {
+		name, content, err := readTemplate(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		set, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := set.New(name).Parse(content)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+		outPath, err := template.New(name).Funcs(templateFuncs).Parse(strings.TrimSuffix(name, ".tmpl"))
+		if err != nil {
+			return nil, fmt.Errorf("parse output path of template %s: %w", name, err)
+		}
+
+		data := []TemplateData{{Files: files, Params: params}}
+		if strings.Contains(name, "{{") {
+			data = data[:0]
+			for _, f := range files {
+				data = append(data, TemplateData{File: f, Files: files, Params: params})
+			}
+		}
+		for _, d := range data {
+			var out, buf bytes.Buffer
+			if err := outPath.Execute(&out, d); err != nil {
+				return nil, fmt.Errorf("output path of template %s: %w", name, err)
+			}
+			if err := tmpl.Execute(&buf, d); err != nil {
+				return nil, err
+			}
+			if strings.TrimSpace(buf.String()) == "" {
+				continue
+			}
+			if out.Len() == 0 {
+				return nil, fmt.Errorf("template %s has an empty output path", name)
+			}
+			resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+				Name:    proto.String(out.String()),
+				Content: proto.String(buf.String()),
+			})
+		}
+	}
+	return resp, nil
+}
+
+// readTemplate reads a template file and returns its name, the path
+// relative to the template directory with forward slashes.
+func readTemplate(dir, file string) (string, string, error) {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return "", "", err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", fmt.Errorf("read template: %w", err)
+	}
+	return filepath.ToSlash(rel), string(content), nil
+}
+
+// templateFuncs are the functions available to templates, besides the
+// text/template builtins.
+var templateFuncs = template.FuncMap{
+	"goName":     goCamelCase,
+	"lowerCamel": lowerCamelCase,
+	"snakeCase":  snakeCase,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"base":       path.Base,
+	"dir":        path.Dir,
+	"ext":        path.Ext,
+}
+
+// goCamelCase returns the Go identifier protoc-gen-go derives from a proto
+// name: "foo_bar.baz" becomes "FooBarBaz" and "Outer.Inner" "Outer_Inner".
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip the dot in ".{{lowercase}}"
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// A leading underscore would make the name unexported
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip the underscore in "_{{lowercase}}"
+		case c >= '0' && c <= '9':
+			b = append(b, c)
+		default:
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+func isASCIILower(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+// lowerCamelCase is goCamelCase with a lower case first letter.
+func lowerCamelCase(s string) string {
+	s = goCamelCase(s)
+	for i, r := range s {
+		return string(unicode.ToLower(r)) + s[i+1:]
+	}
+	return s
+}
+
+// snakeCase converts a CamelCase name to snake_case, keeping acronyms
+// together: "HTTPServer" becomes "http_server".
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// templateType is a message or enum type referenced by fields and methods.
+type templateType struct {
+	goName       string
+	goImportPath string
+	goPackage    string
+	kind         string
+	mapEntry     *descriptorpb.DescriptorProto
+}
+
+// templateConverter turns descriptors into template data.
+type templateConverter struct {
+	types    map[string]templateType
+	file     *descriptorpb.FileDescriptorProto
+	comments map[string]string
+}
+
+// templateFiles converts the files to generate of a request.
+func templateFiles(req *pluginpb.CodeGeneratorRequest) []*TemplateFile {
+	conv := &templateConverter{types: make(map[string]templateType)}
+	byName := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, file := range req.GetProtoFile() {
+		byName[file.GetName()] = file
+		importPath, pkgName := splitGoPackage(file.GetOptions().GetGoPackage())
+		if importPath == "" {
+			pkgName = ""
+		}
+		prefix := file.GetPackage()
+		if prefix != "" {
+			prefix += "."
+		}
+		var index func(scope string, msgs []*descriptorpb.DescriptorProto, enums []*descriptorpb.EnumDescriptorProto)
+		index = func(scope string, msgs []*descriptorpb.DescriptorProto, enums []*descriptorpb.EnumDescriptorProto) {
+			for _, msg := range msgs {
+				name := scope + msg.GetName()
+				t := templateType{goName: goCamelCase(name), goImportPath: importPath, goPackage: pkgName, kind: "message"}
+				if msg.GetOptions().GetMapEntry() {
+					t.mapEntry = msg
+				}
+				conv.types[prefix+name] = t
+				index(name+".", msg.GetNestedType(), msg.GetEnumType())
+			}
+			for _, enum := range enums {
+				name := scope + enum.GetName()
+				conv.types[prefix+name] = templateType{goName: goCamelCase(name), goImportPath: importPath, goPackage: pkgName, kind: "enum"}
+			}
+		}
+		index("", file.GetMessageType(), file.GetEnumType())
+	}
+
+	var files []*TemplateFile
+	for _, name := range req.GetFileToGenerate() {
+		if file, ok := byName[name]; ok {
+			files = append(files, conv.convertFile(file))
+		}
+	}
+	return files
+}
+
+func (conv *templateConverter) convertFile(file *descriptorpb.FileDescriptorProto) *TemplateFile {
+	conv.file = file
+	conv.comments = sourceComments(file)
+	importPath, pkgName := splitGoPackage(file.GetOptions().GetGoPackage())
+	if importPath == "" {
+		pkgName = ""
+	}
+	syntax := file.GetSyntax()
+	if syntax == "" {
+		syntax = "proto2"
+	}
+
+	f := &TemplateFile{
+		Name:          file.GetName(),
+		Package:       file.GetPackage(),
+		Syntax:        syntax,
+		Imports:       file.GetDependency(),
+		GoImportPath:  importPath,
+		GoPackageName: pkgName,
+		Comments:      conv.comments[pathKey([]int32{fileDescriptorPackage})],
+		Options:       file.GetOptions(),
+		Descriptor:    file,
+	}
+	prefix := file.GetPackage()
+	if prefix != "" {
+		prefix += "."
+	}
+	for i, msg := range file.GetMessageType() {
+		if m := conv.message(prefix, msg, []int32{fileDescriptorMessageType, int32(i)}); m != nil {
+			f.Messages = append(f.Messages, m)
+		}
+	}
+	for i, enum := range file.GetEnumType() {
+		f.Enums = append(f.Enums, conv.enum(prefix, enum, []int32{fileDescriptorEnumType, int32(i)}))
+	}
+	for i, svc := range file.GetService() {
+		f.Services = append(f.Services, conv.service(prefix, svc, []int32{fileDescriptorService, int32(i)}))
+	}
+	return f
+}
+
+// message converts a message, or returns nil for map entries.
+func (conv *templateConverter) message(scope string, msg *descriptorpb.DescriptorProto, p []int32) *TemplateMessage {
+	if msg.GetOptions().GetMapEntry() {
+		return nil
+	}
+	fullName := scope + msg.GetName()
+	m := &TemplateMessage{
+		Name:       msg.GetName(),
+		FullName:   fullName,
+		GoName:     conv.types[fullName].goName,
+		Comments:   conv.comments[pathKey(p)],
+		Options:    msg.GetOptions(),
+		Descriptor: msg,
+	}
+	for i, field := range msg.GetField() {
+		m.Fields = append(m.Fields, conv.field(field, appendPath(p, messageDescriptorField, int32(i))))
+	}
+	for i, nested := range msg.GetNestedType() {
+		if n := conv.message(fullName+".", nested, appendPath(p, messageDescriptorNested, int32(i))); n != nil {
+			m.Messages = append(m.Messages, n)
+		}
+	}
+	for i, enum := range msg.GetEnumType() {
+		m.Enums = append(m.Enums, conv.enum(fullName+".", enum, appendPath(p, messageDescriptorEnumType, int32(i))))
+	}
+	return m
+}
+
+func (conv *templateConverter) field(field *descriptorpb.FieldDescriptorProto, p []int32) *TemplateField {
+	f := &TemplateField{
+		Name:       field.GetName(),
+		JSONName:   field.GetJsonName(),
+		GoName:     goCamelCase(field.GetName()),
+		Number:     field.GetNumber(),
+		Kind:       "scalar",
+		Repeated:   field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED,
+		Optional:   field.GetProto3Optional() || field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL && conv.file.GetSyntax() != "proto3" && field.OneofIndex == nil,
+		Comments:   conv.comments[pathKey(p)],
+		Options:    field.GetOptions(),
+		Descriptor: field,
+	}
+
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_GROUP,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		f.Type = strings.TrimPrefix(field.GetTypeName(), ".")
+		t := conv.types[f.Type]
+		f.Kind = t.kind
+		if t.mapEntry != nil && f.Repeated {
+			f.Map = true
+			for _, entryField := range t.mapEntry.GetField() {
+				switch entryField.GetNumber() {
+				case 1:
+					f.MapKey = conv.field(entryField, nil)
+				case 2:
+					f.MapValue = conv.field(entryField, nil)
+				}
+			}
+		}
+	default:
+		f.Type = strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+	}
+	f.GoType = conv.goType(f)
+	return f
+}
+
+// goScalarTypes are the Go types of scalar fields.
+var goScalarTypes = map[string]string{
+	"double": "float64", "float": "float32",
+	"int64": "int64", "sint64": "int64", "sfixed64": "int64",
+	"uint64": "uint64", "fixed64": "uint64",
+	"int32": "int32", "sint32": "int32", "sfixed32": "int32",
+	"uint32": "uint32", "fixed32": "uint32",
+	"bool": "bool", "string": "string", "bytes": "[]byte",
+}
+
+// goType returns the Go type protoc-gen-go uses for a field.
+func (conv *templateConverter) goType(f *TemplateField) string {
+	if f.Map && f.MapKey != nil && f.MapValue != nil {
+		return "map[" + f.MapKey.GoType + "]" + f.MapValue.GoType
+	}
+	var goType string
+	switch f.Kind {
+	case "scalar":
+		goType = goScalarTypes[f.Type]
+		if f.Optional && !f.Repeated && f.Type != "bytes" {
+			goType = "*" + goType
+		}
+	case "message":
+		goType = "*" + conv.goTypeName(f.Type)
+	case "enum":
+		goType = conv.goTypeName(f.Type)
+		if f.Optional && !f.Repeated {
+			goType = "*" + goType
+		}
+	}
+	if f.Repeated {
+		goType = "[]" + goType
+	}
+	return goType
+}
+
+// goTypeName returns the Go name of a message or enum type, qualified with
+// its package name when it is generated into another Go package than the
+// current file.
+func (conv *templateConverter) goTypeName(fullName string) string {
+	t, ok := conv.types[fullName]
+	if !ok {
+		return goCamelCase(fullName)
+	}
+	importPath, _ := splitGoPackage(conv.file.GetOptions().GetGoPackage())
+	if t.goImportPath != "" && t.goImportPath != importPath {
+		return t.goPackage + "." + t.goName
+	}
+	return t.goName
+}
+
+func (conv *templateConverter) enum(scope string, enum *descriptorpb.EnumDescriptorProto, p []int32) *TemplateEnum {
+	fullName := scope + enum.GetName()
+	e := &TemplateEnum{
+		Name:       enum.GetName(),
+		FullName:   fullName,
+		GoName:     conv.types[fullName].goName,
+		Comments:   conv.comments[pathKey(p)],
+		Options:    enum.GetOptions(),
+		Descriptor: enum,
+	}
+	for i, value := range enum.GetValue() {
+		e.Values = append(e.Values, &TemplateEnumValue{
+			Name:     value.GetName(),
+			Number:   value.GetNumber(),
+			Comments: conv.comments[pathKey(appendPath(p, enumDescriptorValue, int32(i)))],
+			Options:  value.GetOptions(),
+		})
+	}
+	return e
+}
+
+func (conv *templateConverter) service(scope string, svc *descriptorpb.ServiceDescriptorProto, p []int32) *TemplateService {
+	s := &TemplateService{
+		Name:       svc.GetName(),
+		FullName:   scope + svc.GetName(),
+		Comments:   conv.comments[pathKey(p)],
+		Options:    svc.GetOptions(),
+		Descriptor: svc,
+	}
+	for i, method := range svc.GetMethod() {
+		input := strings.TrimPrefix(method.GetInputType(), ".")
+		output := strings.TrimPrefix(method.GetOutputType(), ".")
+		s.Methods = append(s.Methods, &TemplateMethod{
+			Name:            method.GetName(),
+			Input:           input,
+			Output:          output,
+			InputGoType:     "*" + conv.goTypeName(input),
+			OutputGoType:    "*" + conv.goTypeName(output),
+			ClientStreaming: method.GetClientStreaming(),
+			ServerStreaming: method.GetServerStreaming(),
+			Comments:        conv.comments[pathKey(appendPath(p, serviceDescriptorMethod, int32(i)))],
+			Options:         method.GetOptions(),
+		})
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package protoc_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestTemplateGenerator(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping template test")
+	}
+
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	templates := filepath.Join(tmpDir, "templates")
+	gen := filepath.Join(tmpDir, "gen")
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto": `syntax = "proto3";
+package a;
+option go_package = "example.com/gen/a";
+import "b/b.proto";
+
+// User is a user.
+message User {
+  string user_id = 1; // Identifier
+  optional int32 age = 2;
+  map<string, b.Tag> tags = 3;
+  repeated Address addresses = 4;
+  message Address { string city = 1; }
+}
+
+// Users stores users.
+service UserStore {
+  // Get returns a user.
+  rpc Get(b.Key) returns (User);
+  rpc Watch(b.Key) returns (stream User);
+}
+`,
+		"b/b.proto": `syntax = "proto3";
+package b;
+option go_package = "example.com/gen/b;bpb";
+message Key { string id = 1; }
+message Tag {}
+`,
+	})
+	writeProtos(t, templates, map[string]string{
+		"_helpers.tmpl": `{{define "comment"}}{{if .}}// {{.}}
+{{end}}{{end}}`,
+		"{{.File.Package}}/repository.go.tmpl": `{{range .File.Services}}{{template "comment" .Comments}}type {{.Name | trimSuffix "Store"}}Repository interface {
+{{range .Methods}}{{template "comment" .Comments}}	{{.Name}}(ctx context.Context, in {{.InputGoType}}) ({{.OutputGoType}}, error){{if .ServerStreaming}} // streaming{{end}}
+{{end}}}
+{{end}}`,
+		"models.txt.tmpl": `{{.Params.prefix}}{{range .Files}}{{range .Messages}}{{template "comment" .Comments}}{{.GoName}}:
+{{range .Fields}}  {{.GoName}} {{.GoType}} {{.Name | snakeCase}} {{.JSONName | lowerCamel}}{{if .Comments}} ({{.Comments}}){{end}}
+{{end}}{{range .Messages}}{{.GoName}}
+{{end}}{{end}}{{end}}`,
+	})
+
+	_, err := protoc.NewCompiler().
+		WithProtoDir(workspace).
+		WithProtoWorkSpace(workspace).
+		WithOutputDir(gen).
+		WithPlugins("template").
+		WithPluginOpts("template", "dir="+templates, "prefix=models").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	want := map[string]string{
+		"a/repository.go": `// Users stores users.
+type UserRepository interface {
+// Get returns a user.
+	Get(ctx context.Context, in *bpb.Key) (*User, error)
+	Watch(ctx context.Context, in *bpb.Key) (*User, error) // streaming
+}
+`,
+		"models.txt": `models// User is a user.
+User:
+  UserId string user_id userId (Identifier)
+  Age *int32 age age
+  Tags map[string]*bpb.Tag tags tags
+  Addresses []*User_Address addresses addresses
+User_Address
+Key:
+  Id string id id
+Tag:
+`,
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(gen, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(gen, "b", "repository.go")); !os.IsNotExist(err) {
+		t.Errorf("expected no repository for b without services, got: %v", err)
+	}
+}
+
+func TestTemplateGeneratorErrors(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping template test")
+	}
+
+	tmpDir := t.TempDir()
+	workspace := filepath.Join(tmpDir, "proto")
+	writeProtos(t, workspace, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+	writeProtos(t, filepath.Join(tmpDir, "bad"), map[string]string{"x.tmpl": `{{.Nope}}`})
+
+	tests := []struct {
+		name     string
+		compiler *protoc.Compiler
+		want     string
+	}{
+		{"no dir", protoc.NewCompiler().WithPlugins("template"), "needs a dir option"},
+		{"no templates", protoc.NewCompiler().WithPlugins("tmpl").
+			WithGenerator("tmpl", protoc.TemplateGenerator(workspace)), "no templates found"},
+		{"bad field", protoc.NewCompiler().WithPlugins("template").
+			WithPluginOpts("template", "dir="+filepath.Join(tmpDir, "bad")), "can't evaluate field Nope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.compiler.
+				WithProtoDir(workspace).
+				WithProtoWorkSpace(workspace).
+				WithOutputDir(filepath.Join(tmpDir, "gen")).
+				Compile()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
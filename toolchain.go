@@ -0,0 +1,478 @@
+package protoc
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// WithProtocSource sets where protoc is installed from when it is not in
+// PATH, or when WithProtocVersion is set and the protoc in PATH is another
+// version: a local directory, or a file:// URL of one, mirroring the
+// release archives of protoc, e.g. protoc-28.3-linux-x86_64.zip. The
+// newest archive for the current platform matching the version set with
+// WithProtocVersion is used. Its SHA-256 checksum must be listed in a
+// SHA256SUMS file in the directory or in a <archive>.sha256 file next to
+// it. The archive is extracted, with the include directory of the
+// well-known types, into a cache directory once per version, and the
+// installation is checked against the recorded checksums on each use.
+// Only compiling installs protoc; Graph, Lint, Files and Plan do not.
+func (c *Compiler) WithProtocSource(source string) *Compiler {
+	c.protocSource = source
+	return c
+}
+
+// WithProtocCacheDir sets the directory protoc is installed into from the
+// protoc source. The default is protoc-go/protoc in the user cache
+// directory.
+func (c *Compiler) WithProtocCacheDir(dir string) *Compiler {
+	c.protocCache = dir
+	return c
+}
+
+// protocExecutable returns the protoc binary to run without installing
+// anything: the one chosen by findProtoc, an installation of the pinned
+// version from the protoc source, protoc in PATH, or an earlier
+// installation from the source, in that order. It falls back to "protoc",
+// so operations that do not run protoc, such as Graph and Plan, never
+// install it as a side effect.
+func (c *compilerImpl) protocExecutable() string {
+	if c.protocBin != "" {
+		return c.protocBin
+	}
+	name, explicit := c.backendProtoc()
+	if explicit {
+		return name
+	}
+	pinned := c.protocVer != "" && c.protocSource != ""
+	if pinned {
+		if bin, ok := c.installedProtoc(); ok {
+			return bin
+		}
+	}
+	if _, err := exec.LookPath(name); err == nil {
+		return name
+	}
+	if !pinned {
+		if bin, ok := c.installedProtoc(); ok {
+			return bin
+		}
+	}
+	return name
+}
+
+// backendProtoc returns the protoc binary set on the backend, or "protoc"
+// and false if none is set.
+func (c *compilerImpl) backendProtoc() (string, bool) {
+	switch b := c.backend.(type) {
+	case ProtocBackend:
+		if b.Path != "" {
+			return b.Path, true
+		}
+	case *ProtocBackend:
+		if b != nil && b.Path != "" {
+			return b.Path, true
+		}
+	}
+	return "protoc", false
+}
+
+// findProtoc returns the protoc binary set on the backend, or "protoc" if
+// it is in PATH or, failing that, installs it from the protoc source. A
+// protoc in PATH not matching the version set with WithProtocVersion is
+// passed over for the pinned version from the source. The result is
+// remembered for later calls.
+func (c *compilerImpl) findProtoc() (string, error) {
+	if c.protocBin != "" {
+		return c.protocBin, nil
+	}
+	name, explicit := c.backendProtoc()
+	_, err := exec.LookPath(name)
+	if err == nil && !explicit && c.protocSource != "" && c.protocVer != "" {
+		if version, verr := protocVersion(c.ctx, name); verr != nil || !versionMatches(version, c.protocVer) {
+			err = fmt.Errorf("protoc in PATH is not version %s", c.protocVer)
+		}
+	}
+	if err != nil {
+		if explicit || c.protocSource == "" {
+			return "", err
+		}
+		if name, err = c.installProtoc(); err != nil {
+			return "", fmt.Errorf("install protoc from %s: %w", c.protocSource, err)
+		}
+	}
+	c.protocBin = name
+	return name, nil
+}
+
+// protocArchive matches the names of protoc release archives.
+var protocArchive = regexp.MustCompile(`^protoc-(\d+(?:\.\d+)*(?:-rc-?\d+)?)-(.+)\.zip$`)
+
+// protocPlatforms returns the platform names of protoc release archives
+// that run on this system, preferred first.
+func protocPlatforms() []string {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64":
+		return []string{"linux-x86_64"}
+	case "linux/arm64":
+		return []string{"linux-aarch_64"}
+	case "linux/386":
+		return []string{"linux-x86_32"}
+	case "linux/ppc64le":
+		return []string{"linux-ppcle_64"}
+	case "linux/s390x":
+		return []string{"linux-s390_64"}
+	case "darwin/amd64":
+		return []string{"osx-x86_64", "osx-universal_binary"}
+	case "darwin/arm64":
+		return []string{"osx-aarch_64", "osx-universal_binary"}
+	case "windows/amd64":
+		return []string{"win64", "win32"}
+	case "windows/386":
+		return []string{"win32"}
+	}
+	return nil
+}
+
+// protocInstall is a release archive of the protoc source and the cache
+// directory it is installed into.
+type protocInstall struct {
+	source   string // Local directory of the protoc source
+	archive  string // File name of the archive
+	version  string
+	platform string
+	dir      string // Installation directory
+	bin      string // protoc binary in dir
+}
+
+// protocInstallStamp is the file in an installation directory recording
+// the SHA-256 checksums of the archive and of the installed binary, in
+// SHA256SUMS format.
+const protocInstallStamp = "protoc-go.sha256"
+
+// protocInstall finds the archive to install from the protoc source and
+// where it goes, without writing anything.
+func (c *compilerImpl) protocInstall() (*protocInstall, error) {
+	dir, err := sourceDir(c.protocSource)
+	if err != nil {
+		return nil, err
+	}
+	archive, version, platform, err := findProtocArchive(dir, c.protocVer)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := c.protocCache
+	if cache == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("find cache directory: %w", err)
+		}
+		cache = filepath.Join(userCache, "protoc-go", "protoc")
+	}
+	inst := &protocInstall{source: dir, archive: archive, version: version, platform: platform}
+	inst.dir = filepath.Join(cache, version+"-"+platform)
+	inst.bin = filepath.Join(inst.dir, "bin", "protoc")
+	if runtime.GOOS == "windows" {
+		inst.bin += ".exe"
+	}
+	return inst, nil
+}
+
+// installedProtoc returns the binary of an intact installation from the
+// protoc source, without installing it.
+func (c *compilerImpl) installedProtoc() (string, bool) {
+	if c.protocSource == "" {
+		return "", false
+	}
+	inst, err := c.protocInstall()
+	if err != nil || inst.verify() != nil {
+		return "", false
+	}
+	return inst.bin, true
+}
+
+// verify checks the installation against its stamp: the archive must
+// still have the checksum the source lists, and the binary the checksum
+// it had when it was installed.
+func (inst *protocInstall) verify() error {
+	stamp, err := os.ReadFile(filepath.Join(inst.dir, protocInstallStamp))
+	if err != nil {
+		return err
+	}
+	want, err := expectedChecksum(inst.source, inst.archive)
+	if err != nil {
+		return err
+	}
+	bin, err := fileChecksum(inst.bin)
+	if err != nil {
+		return err
+	}
+	if string(stamp) != installStamp(want, inst.archive, bin) {
+		return fmt.Errorf("installation in %s does not match its checksums", inst.dir)
+	}
+	return nil
+}
+
+func installStamp(archiveSum, archive, binSum string) string {
+	return fmt.Sprintf("%s  %s\n%s  bin/protoc\n", archiveSum, archive, binSum)
+}
+
+// installProtoc installs protoc from the protoc source into the cache
+// directory, unless an intact installation is already there, and returns
+// the binary.
+func (c *compilerImpl) installProtoc() (string, error) {
+	inst, err := c.protocInstall()
+	if err != nil {
+		return "", err
+	}
+	if inst.verify() == nil {
+		return inst.bin, nil
+	}
+
+	archiveSum, err := verifyChecksum(inst.source, inst.archive)
+	if err != nil {
+		return "", err
+	}
+	cache := filepath.Dir(inst.dir)
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return "", fmt.Errorf("create cache directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(cache, ".install-")
+	if err != nil {
+		return "", fmt.Errorf("create cache directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractProtoc(filepath.Join(inst.source, inst.archive), tmp); err != nil {
+		return "", fmt.Errorf("extract %s: %w", inst.archive, err)
+	}
+	binSum, err := fileChecksum(filepath.Join(tmp, filepath.Base(filepath.Dir(inst.bin)), filepath.Base(inst.bin)))
+	if err != nil {
+		return "", fmt.Errorf("install protoc: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, protocInstallStamp), []byte(installStamp(archiveSum, inst.archive, binSum)), 0644); err != nil {
+		return "", fmt.Errorf("install protoc: %w", err)
+	}
+
+	// Replace a damaged or outdated installation
+	if err := os.RemoveAll(inst.dir); err != nil {
+		return "", fmt.Errorf("install protoc: %w", err)
+	}
+	if err := os.Rename(tmp, inst.dir); err != nil {
+		// Another process may have installed the same version meanwhile
+		if inst.verify() != nil {
+			return "", fmt.Errorf("install protoc: %w", err)
+		}
+	}
+	if c.verbose {
+		fmt.Printf("Installed protoc %s from %s into %s\n", inst.version, inst.archive, inst.dir)
+	}
+	return inst.bin, nil
+}
+
+// sourceDir returns the local directory of a protoc source.
+func sourceDir(source string) (string, error) {
+	if !strings.Contains(source, "://") {
+		return source, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported protoc source %q: use a local directory or a file:// URL", source)
+	}
+	dir := filepath.FromSlash(u.Path)
+	if runtime.GOOS == "windows" {
+		// file:///C:/mirror has the path /C:/mirror
+		dir = strings.TrimPrefix(dir, `\`)
+	}
+	return dir, nil
+}
+
+// findProtocArchive returns the newest archive in dir for this platform
+// whose version matches want, with its version and platform.
+func findProtocArchive(dir, want string) (string, string, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", "", fmt.Errorf("read protoc source: %w", err)
+	}
+	platforms := protocPlatforms()
+
+	var archive, version, platform string
+	rank := len(platforms)
+	for _, entry := range entries {
+		m := protocArchive.FindStringSubmatch(entry.Name())
+		if m == nil || want != "" && !versionMatches(m[1], want) {
+			continue
+		}
+		for i, p := range platforms {
+			if m[2] != p {
+				continue
+			}
+			cmp := compareVersions(m[1], version)
+			if archive == "" || cmp > 0 || cmp == 0 && i < rank {
+				archive, version, platform, rank = entry.Name(), m[1], p, i
+			}
+		}
+	}
+	if archive == "" {
+		what := "protoc"
+		if want != "" {
+			what += " " + want
+		}
+		return "", "", "", fmt.Errorf("no %s archive for %s/%s in %s", what, runtime.GOOS, runtime.GOARCH, dir)
+	}
+	return archive, version, platform, nil
+}
+
+// compareVersions compares dotted version numbers numerically. Release
+// candidates sort before the release.
+func compareVersions(a, b string) int {
+	a, aRC, _ := strings.Cut(a, "-")
+	b, bRC, _ := strings.Cut(b, "-")
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aRC == bRC:
+		return 0
+	case aRC == "":
+		return 1
+	case bRC == "":
+		return -1
+	}
+	return strings.Compare(aRC, bRC)
+}
+
+// verifyChecksum checks the SHA-256 checksum of an archive against the
+// SHA256SUMS file or the <archive>.sha256 file of the source and returns
+// it.
+func verifyChecksum(dir, archive string) (string, error) {
+	want, err := expectedChecksum(dir, archive)
+	if err != nil {
+		return "", err
+	}
+	got, err := fileChecksum(filepath.Join(dir, archive))
+	if err != nil {
+		return "", fmt.Errorf("read protoc archive: %w", err)
+	}
+	if got != want {
+		return "", fmt.Errorf("checksum mismatch for %s: got %s, want %s", archive, got, want)
+	}
+	return got, nil
+}
+
+// fileChecksum returns the SHA-256 checksum of a file in lower case hex.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// expectedChecksum returns the checksum of an archive listed by the source,
+// in lower case hex.
+func expectedChecksum(dir, archive string) (string, error) {
+	if data, err := os.ReadFile(filepath.Join(dir, archive+".sha256")); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "SHA256SUMS"))
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// sha256sum output: "<hash>  <name>", or "<hash> *<name>"
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == archive {
+				return strings.ToLower(fields[0]), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s: add it to SHA256SUMS or %s.sha256 in the protoc source", archive, archive)
+}
+
+// extractProtoc extracts the protoc binary and the include directory of an
+// archive into dir.
+func extractProtoc(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	found := false
+	for _, f := range r.File {
+		name := path.Clean(f.Name)
+		isBin := name == "bin/protoc" || name == "bin/protoc.exe"
+		if f.FileInfo().IsDir() || !isBin && !strings.HasPrefix(name, "include/") {
+			continue
+		}
+		if strings.Contains(name, "..") {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+		mode := os.FileMode(0644)
+		if isBin {
+			mode, found = 0755, true
+		}
+		if err := extractFile(f, filepath.Join(dir, filepath.FromSlash(name)), mode); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New("archive contains no bin/protoc")
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, dest string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,275 @@
+package protoc_test
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// releasePlatform returns the platform name of protoc release archives for
+// this system, skipping the test where the stub protoc cannot run.
+func releasePlatform(t *testing.T) string {
+	t.Helper()
+	platforms := map[string]string{
+		"linux/amd64":  "linux-x86_64",
+		"linux/arm64":  "linux-aarch_64",
+		"darwin/amd64": "osx-x86_64",
+		"darwin/arm64": "osx-aarch_64",
+	}
+	platform, ok := platforms[runtime.GOOS+"/"+runtime.GOARCH]
+	if !ok {
+		t.Skip("no stub protoc release for " + runtime.GOOS + "/" + runtime.GOARCH)
+	}
+	return platform
+}
+
+// writeRelease writes a protoc release archive to dir whose protoc prints
+// the given version and echoes its arguments, and returns its checksum.
+func writeRelease(t *testing.T, dir, name, version string) string {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	w := zip.NewWriter(io.MultiWriter(f, h))
+	files := map[string]string{
+		"bin/protoc": "#!/bin/sh\nif [ \"$1\" = --version ]; then echo 'libprotoc " + version + "'; exit 0; fi\necho \"ran $*\"\n",
+		"include/google/protobuf/descriptor.proto": `syntax = "proto2"; package google.protobuf;`,
+		"readme.txt": "not extracted",
+	}
+	for name, content := range files {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(0644)
+		if name == "bin/protoc" {
+			header.SetMode(0755)
+		}
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestProtocSource(t *testing.T) {
+	platform := releasePlatform(t)
+	tmpDir := t.TempDir()
+	mirror := filepath.Join(tmpDir, "mirror")
+	cache := filepath.Join(tmpDir, "cache")
+	workspace := filepath.Join(tmpDir, "proto")
+	os.MkdirAll(mirror, 0755)
+	writeProtos(t, workspace, map[string]string{
+		"a/a.proto": `syntax = "proto3"; package a; option go_package = "example.com/a";`,
+	})
+
+	var sums strings.Builder
+	for _, version := range []string{"27.0", "28.1", "28.3", "29.0"} {
+		name := "protoc-" + version + "-" + platform + ".zip"
+		sums.WriteString(writeRelease(t, mirror, name, version) + "  " + name + "\n")
+	}
+	writeRelease(t, mirror, "protoc-28.9-win64.zip", "28.9")
+	if err := os.WriteFile(filepath.Join(mirror, "SHA256SUMS"), []byte(sums.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without protoc in PATH
+	t.Setenv("PATH", filepath.Join(tmpDir, "empty"))
+	compile := func() (string, error) {
+		return protoc.NewCompiler().
+			WithProtoDir(filepath.Join(workspace, "a")).
+			WithProtoWorkSpace(workspace).
+			WithOutputDir(filepath.Join(tmpDir, "gen")).
+			WithProtocVersion("28").
+			WithProtocSource("file://" + filepath.ToSlash(mirror)).
+			WithProtocCacheDir(cache).
+			Compile()
+	}
+	output, err := compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !strings.HasPrefix(output, "ran ") || !strings.Contains(output, "a/a.proto") {
+		t.Errorf("expected the installed protoc to run, got %q", output)
+	}
+
+	installDir := filepath.Join(cache, "28.3-"+platform)
+	if _, err := os.Stat(filepath.Join(installDir, "include", "google", "protobuf", "descriptor.proto")); err != nil {
+		t.Errorf("expected the well-known types to be installed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(installDir, "readme.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected only bin and include to be extracted, got %v", err)
+	}
+
+	// The cached installation is used without verifying the archive again
+	if err := os.WriteFile(filepath.Join(mirror, "protoc-28.3-"+platform+".zip"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile(); err != nil {
+		t.Errorf("Compile with cached protoc failed: %v", err)
+	}
+}
+
+func TestProtocSourceCache(t *testing.T) {
+	platform := releasePlatform(t)
+	tmpDir := t.TempDir()
+	mirror := filepath.Join(tmpDir, "mirror")
+	cache := filepath.Join(tmpDir, "cache")
+	workspace := filepath.Join(tmpDir, "proto")
+	bin := filepath.Join(tmpDir, "bin")
+	os.MkdirAll(mirror, 0755)
+	os.MkdirAll(bin, 0755)
+	writeProtos(t, workspace, map[string]string{
+		"a.proto": `syntax = "proto3"; package a; option go_package = "example.com/a";`,
+	})
+	name := "protoc-28.3-" + platform + ".zip"
+	sum := writeRelease(t, mirror, name, "28.3")
+	if err := os.WriteFile(filepath.Join(mirror, "SHA256SUMS"), []byte(sum+"  "+name+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A protoc in PATH at another version than the pinned one
+	stub := "#!/bin/sh\nif [ \"$1\" = --version ]; then echo 'libprotoc 27.0'; exit 0; fi\necho \"path $*\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "protoc"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	compiler := func() *protoc.Compiler {
+		return protoc.NewCompiler().
+			WithProtoDir(workspace).
+			WithProtoWorkSpace(workspace).
+			WithOutputDir(filepath.Join(tmpDir, "gen")).
+			WithProtocVersion("28.3").
+			WithProtocSource("file://" + filepath.ToSlash(mirror)).
+			WithProtocCacheDir(cache)
+	}
+
+	// Graph, Files and Doctor need no protoc and install nothing
+	if _, err := compiler().Graph(context.Background()); err != nil {
+		t.Fatalf("Graph failed: %v", err)
+	}
+	if _, err := compiler().Files(context.Background()); err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	check, _ := findCheck(compiler().Doctor(context.Background()), "protoc")
+	if check.Status != protoc.DoctorWarning || !strings.HasPrefix(check.Message, "28.3 is not installed") {
+		t.Errorf("unexpected protoc check: %+v", check)
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("expected no installation before compiling, got %v", err)
+	}
+
+	output, err := compiler().Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !strings.HasPrefix(output, "ran ") {
+		t.Errorf("expected the pinned protoc to run instead of the one in PATH, got %q", output)
+	}
+
+	// A damaged installation is replaced
+	installed := filepath.Join(cache, "28.3-"+platform, "bin", "protoc")
+	if err := os.WriteFile(installed, []byte("#!/bin/sh\necho tampered\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	output, err = compiler().Compile()
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !strings.HasPrefix(output, "ran ") {
+		t.Errorf("expected the damaged protoc to be reinstalled, got %q", output)
+	}
+
+	// Descriptors come from the installed protoc whichever form the
+	// backend is given in. The stub runs but writes no descriptor set.
+	t.Setenv("PATH", filepath.Join(tmpDir, "empty"))
+	for _, backend := range []protoc.Backend{nil, protoc.ProtocBackend{}, &protoc.ProtocBackend{}} {
+		_, err := compiler().WithBackend(backend).Breaking(context.Background(), workspace)
+		if err == nil || !strings.Contains(err.Error(), "read descriptor set") {
+			t.Errorf("backend %#v: expected the installed protoc to run, got %v", backend, err)
+		}
+	}
+}
+
+func TestProtocSourceErrors(t *testing.T) {
+	platform := releasePlatform(t)
+	name := "protoc-28.3-" + platform + ".zip"
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, mirror string) string
+		version string
+		want    string
+	}{
+		{
+			name: "checksum mismatch",
+			setup: func(t *testing.T, mirror string) string {
+				writeRelease(t, mirror, name, "28.3")
+				os.WriteFile(filepath.Join(mirror, name+".sha256"), []byte(strings.Repeat("0", 64)+"\n"), 0644)
+				return mirror
+			},
+			want: "checksum mismatch for " + name,
+		},
+		{
+			name: "missing checksum",
+			setup: func(t *testing.T, mirror string) string {
+				writeRelease(t, mirror, name, "28.3")
+				return mirror
+			},
+			want: "no checksum for " + name,
+		},
+		{
+			name: "no matching version",
+			setup: func(t *testing.T, mirror string) string {
+				writeRelease(t, mirror, name, "28.3")
+				return mirror
+			},
+			version: "27",
+			want:    "no protoc 27 archive for " + runtime.GOOS + "/" + runtime.GOARCH,
+		},
+		{
+			name: "unsupported scheme",
+			setup: func(t *testing.T, mirror string) string {
+				return "https://example.com/protoc"
+			},
+			want: `unsupported protoc source "https://example.com/protoc"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			mirror := filepath.Join(tmpDir, "mirror")
+			workspace := filepath.Join(tmpDir, "proto")
+			os.MkdirAll(mirror, 0755)
+			writeProtos(t, workspace, map[string]string{"a.proto": `syntax = "proto3"; package a;`})
+			t.Setenv("PATH", filepath.Join(tmpDir, "empty"))
+
+			_, err := protoc.NewCompiler().
+				WithProtoDir(workspace).
+				WithProtoWorkSpace(workspace).
+				WithOutputDir(filepath.Join(tmpDir, "gen")).
+				WithProtocVersion(tt.version).
+				WithProtocSource(tt.setup(t, mirror)).
+				WithProtocCacheDir(filepath.Join(tmpDir, "cache")).
+				Compile()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default Watch timings.
+const (
+	defaultPollInterval = 500 * time.Millisecond
+	defaultDebounce     = 200 * time.Millisecond
+)
+
+// Result is the outcome of a compilation run by Watch.
+type Result struct {
+	// Changed are the paths of the .proto files that were added, modified
+	// or removed since the previous compilation. It is empty for the
+	// initial one.
+	Changed []string
+	// Compiled are the files passed to protoc, relative to the workspace:
+	// the changed files and the files importing them.
+	Compiled []string
+	Output   string // Combined protoc output
+	Err      error
+	Duration time.Duration
+}
+
+// Watch compiles all files and then keeps monitoring the proto directory
+// (or the workspace, when roots are set) and the import paths for changes
+// to .proto files. Changes are detected by polling, so no platform-specific
+// file notification is needed. Once the sources have been stable for the
+// debounce period, only the changed files and the files that import them,
+// directly or transitively, are recompiled.
+//
+// Every compilation, including the initial one, is reported to onResult.
+// Watch blocks until ctx is cancelled and then returns ctx.Err(); it
+// returns earlier only if the configuration is invalid or protoc is not
+// available.
+func (c *Compiler) Watch(ctx context.Context, onResult func(Result)) error {
+	impl := c.newImpl()
+	impl.ctx = ctx
+	if c.protoFS != nil {
+		if _, ok := fsDir(c.protoFS); !ok {
+			return fmt.Errorf("watch needs a proto file system implementing DirFS")
+		}
+	}
+	cleanup, err := impl.openProtoFS()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := impl.validate(); err != nil {
+		return err
+	}
+	if err := impl.checkProtocAvailable(); err != nil {
+		return err
+	}
+	if err := impl.checkProtocVersion(); err != nil {
+		return err
+	}
+
+	poll := c.pollInterval
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	debounce := c.debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+
+	snapshot := impl.snapshot()
+	onResult(impl.recompile(nil))
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	var pending map[string]bool // changed paths not yet compiled
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			current := impl.snapshot()
+			if changed := snapshot.diff(current); len(changed) > 0 {
+				if pending == nil {
+					pending = make(map[string]bool)
+				}
+				for _, path := range changed {
+					pending[path] = true
+				}
+				snapshot = current
+				lastChange = now
+				continue
+			}
+			if len(pending) == 0 || now.Sub(lastChange) < debounce {
+				continue
+			}
+
+			changed := make([]string, 0, len(pending))
+			for path := range pending {
+				changed = append(changed, path)
+			}
+			sort.Strings(changed)
+			pending = nil
+			onResult(impl.recompile(changed))
+		}
+	}
+}
+
+// fileState is what Watch compares to detect a modified file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchSnapshot maps files to their state.
+type watchSnapshot map[string]fileState
+
+// snapshot records the state of every monitored .proto file.
+func (c *compilerImpl) snapshot() watchSnapshot {
+	dirs := append([]string{c.protoDir}, c.importPaths...)
+	if c.protoDir == "" {
+		dirs[0] = c.workspaceDir
+	}
+	return snapshotFiles(dirs, func(path string) bool {
+		return strings.HasSuffix(strings.ToLower(path), ".proto")
+	})
+}
+
+// snapshotFiles records the state of the files below dirs accepted by
+// match, by absolute path.
+func snapshotFiles(dirs []string, match func(path string) bool) watchSnapshot {
+	snapshot := make(watchSnapshot)
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		// Unreadable entries are skipped; for Watch they show up as
+		// removed and are reported by the next compilation.
+		filepath.Walk(absDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !match(path) {
+				return nil
+			}
+			snapshot[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+// diff returns the paths added, modified or removed in next.
+func (s watchSnapshot) diff(next watchSnapshot) []string {
+	var changed []string
+	for path, state := range next {
+		if old, ok := s[path]; !ok || old != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range s {
+		if _, ok := next[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// recompile compiles the files affected by the changed paths, or all files
+// if changed is nil.
+func (c *compilerImpl) recompile(changed []string) Result {
+	start := time.Now()
+	result := Result{Changed: changed}
+
+	all, err := c.collectFiles()
+	files := all
+	if err == nil && changed != nil {
+		files, err = c.affectedFiles(all, changed)
+	}
+	if err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	if len(files) == 0 {
+		// Only files outside the compiled set changed, e.g. an unused
+		// file in an import path.
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	if result.Compiled, err = c.importNames(files); err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	result.Output, result.Err = c.compileFiles(files, all)
+	result.Duration = time.Since(start)
+	return result
+}
+
+// affectedFiles returns the files among files that changed or import a
+// changed file, directly or transitively. Files with imports that no longer
+// resolve are included, so that removing a file reports its importers.
+func (c *compilerImpl) affectedFiles(files, changed []string) ([]string, error) {
+	graph, err := c.buildGraph(c.ctx, files)
+	if err != nil {
+		return nil, fmt.Errorf("resolve imports: %w", err)
+	}
+
+	changedPaths := make(map[string]bool, len(changed))
+	for _, path := range changed {
+		changedPaths[path] = true
+	}
+
+	affected := make(map[string]bool)
+	var queue []string
+	mark := func(name string) {
+		if !affected[name] {
+			affected[name] = true
+			queue = append(queue, name)
+		}
+	}
+	for name, node := range graph.Nodes {
+		if changedPaths[node.Path] {
+			mark(name)
+		}
+	}
+	for _, imp := range graph.Unresolved {
+		mark(imp.File)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dependent := range graph.Dependents(name) {
+			mark(dependent)
+		}
+	}
+
+	var result []string
+	for _, file := range files {
+		name, err := c.importName(file)
+		if err != nil {
+			return nil, err
+		}
+		if affected[name] {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package protoc_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dongrv/protoc-go"
+)
+
+func TestWatch(t *testing.T) {
+	if _, err := exec.LookPath("protoc"); err != nil {
+		t.Skip("protoc not available, skipping watch test")
+	}
+	if _, err := exec.LookPath("protoc-gen-go"); err != nil {
+		t.Skip("protoc-gen-go not available, skipping watch test")
+	}
+
+	tmpDir := t.TempDir()
+	proto := filepath.Join(tmpDir, "proto")
+	writeProtos(t, proto, map[string]string{
+		"a.proto": `syntax = "proto3"; package a; option go_package = "example.com/gen/a";`,
+		"b.proto": `syntax = "proto3"; package b; option go_package = "example.com/gen/b"; import "a.proto";`,
+		"c.proto": `syntax = "proto3"; package c; option go_package = "example.com/gen/c";`,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan protoc.Result, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- protoc.NewCompiler().
+			WithProtoDir(proto).
+			WithProtoWorkSpace(proto).
+			WithOutputDir(filepath.Join(tmpDir, "gen")).
+			WithDescriptorPackage(filepath.Join(tmpDir, "schema"), "schema").
+			WithPollInterval(10*time.Millisecond).
+			WithDebounce(30*time.Millisecond).
+			Watch(ctx, func(r protoc.Result) { results <- r })
+	}()
+
+	next := func() protoc.Result {
+		t.Helper()
+		select {
+		case r := <-results:
+			if r.Err != nil {
+				t.Fatalf("compilation failed: %v\n%s", r.Err, r.Output)
+			}
+			return r
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a compilation")
+		}
+		return protoc.Result{}
+	}
+
+	initial := next()
+	if want := []string{"a.proto", "b.proto", "c.proto"}; !reflect.DeepEqual(initial.Compiled, want) {
+		t.Errorf("initial compilation = %v, want %v", initial.Compiled, want)
+	}
+
+	// A burst of edits to a.proto recompiles it and its importer once.
+	path := filepath.Join(proto, "a.proto")
+	for i := 0; i < 3; i++ {
+		content := `syntax = "proto3"; package a; option go_package = "example.com/gen/a"; message M` + string(rune('0'+i)) + ` {}`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r := next()
+	if want := []string{"a.proto", "b.proto"}; !reflect.DeepEqual(r.Compiled, want) {
+		t.Errorf("recompiled %v, want %v", r.Compiled, want)
+	}
+	if len(r.Changed) != 1 || r.Changed[0] != path {
+		t.Errorf("Changed = %v, want [%s]", r.Changed, path)
+	}
+	// The descriptor package still holds the files not recompiled
+	for _, name := range []string{"a.proto", "b.proto", "c.proto"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, "schema", "proto", name)); err != nil {
+			t.Errorf("expected %s in the descriptor package: %v", name, err)
+		}
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Watch returned %v, want context.Canceled", err)
+	}
+	select {
+	case extra := <-results:
+		t.Errorf("unexpected extra compilation: %+v", extra)
+	default:
+	}
+}
+
+func TestWatchInvalidConfig(t *testing.T) {
+	err := protoc.NewCompiler().
+		WithProtoWorkSpace(t.TempDir()).
+		WithOutputDir(t.TempDir()).
+		Watch(context.Background(), func(protoc.Result) {})
+	if err == nil || err.Error() != "proto directory not specified" {
+		t.Errorf("expected configuration error, got: %v", err)
+	}
+}
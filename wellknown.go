@@ -0,0 +1,154 @@
+package protoc
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// wellKnownFS holds the .proto files of the well-known types, as shipped in
+// the include directory of protoc releases.
+//
+//go:embed include
+var wellKnownFS embed.FS
+
+// WithWellKnownTypes supplies the .proto files of the well-known types,
+// such as google/protobuf/timestamp.proto, from a copy bundled with this
+// package when protoc is installed without its include directory, as some
+// distribution packages are. The files are written once to a cache
+// directory, which is passed to protoc as the last -I. If protoc, the
+// workspace or an import path already provides any of them, the bundled
+// copy is not used, so the installed files never conflict with it.
+func (c *Compiler) WithWellKnownTypes(enabled bool) *Compiler {
+	c.wellKnownTypes = enabled
+	return c
+}
+
+// setupWellKnownTypes provides the bundled well-known types, if enabled and
+// missing. Everything resolving imports calls it first: compiling, the
+// import graph and the descriptor sets of Lint and Breaking.
+func (c *compilerImpl) setupWellKnownTypes() error {
+	if !c.wellKnownTypes || !c.usesProtoc() || c.wellKnownDir != "" {
+		return nil
+	}
+
+	includes := append(c.includeDirs(), protocIncludeDirs(c.protocExecutable())...)
+	if dir, name := findWellKnownType(includes); dir != "" {
+		if c.verbose {
+			fmt.Printf("Using the well-known types in %s, which has %s\n", dir, name)
+		}
+		return nil
+	}
+
+	dir, err := writeWellKnownTypes()
+	if err != nil {
+		return fmt.Errorf("provide the well-known types: %w", err)
+	}
+	if c.verbose {
+		fmt.Printf("Using the bundled well-known types in %s\n", dir)
+	}
+	c.wellKnownDir = dir
+	return nil
+}
+
+// wellKnownIncludes returns the include directory of the bundled
+// well-known types, if they are used.
+func (c *compilerImpl) wellKnownIncludes() []string {
+	if c.wellKnownDir == "" {
+		return nil
+	}
+	return []string{c.wellKnownDir}
+}
+
+// isWellKnownType reports whether name is one of the bundled files.
+func isWellKnownType(name string) bool {
+	_, err := fs.Stat(wellKnownFS, path.Join("include", name))
+	return err == nil
+}
+
+// findWellKnownType returns the first include directory containing one of
+// the bundled files, and the file.
+func findWellKnownType(includes []string) (string, string) {
+	var dir, found string
+	fs.WalkDir(wellKnownFS, "include", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel("include", name)
+		for _, include := range includes {
+			if _, err := os.Stat(filepath.Join(include, rel)); err == nil {
+				dir, found = include, filepath.ToSlash(rel)
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	return dir, found
+}
+
+// writeWellKnownTypes writes the bundled files to a cache directory named
+// after their checksum, unless they are already there, and returns it.
+func writeWellKnownTypes() (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(wellKnownFS, "include", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := wellKnownFS.ReadFile(name)
+		fmt.Fprintf(h, "%s %d\n", name, len(data))
+		h.Write(data)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	cache := filepath.Join(base, "protoc-go", "include")
+	dir := filepath.Join(cache, hex.EncodeToString(h.Sum(nil))[:16])
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(cache, ".install-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	err = fs.WalkDir(wellKnownFS, "include", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel("include", name)
+		dest := filepath.Join(tmp, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		data, err := wellKnownFS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0644)
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another process may have written the same files meanwhile
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
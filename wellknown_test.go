@@ -0,0 +1,120 @@
+package protoc_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dongrv/protoc-go"
+)
+
+// writeStubProtoc writes a protoc to dir/bin that echoes its arguments.
+func writeStubProtoc(t *testing.T, dir string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the protoc stub is a shell script")
+	}
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"ran $*\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "protoc"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWellKnownTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string // extra files, relative to the temporary directory
+		bundled bool
+	}{
+		{name: "protoc without include", bundled: true},
+		{
+			name:  "protoc with include",
+			files: map[string]string{"protoc/include/google/protobuf/descriptor.proto": `syntax = "proto2";`},
+		},
+		{
+			name:  "import path with well-known types",
+			files: map[string]string{"third_party/google/protobuf/any.proto": `syntax = "proto3";`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			writeStubProtoc(t, filepath.Join(tmpDir, "protoc"))
+			writeProtos(t, tmpDir, tt.files)
+			workspace := filepath.Join(tmpDir, "proto")
+			writeProtos(t, workspace, map[string]string{"a.proto": `syntax = "proto3"; package a; option go_package = "example.com/a";`})
+			t.Setenv("PATH", filepath.Join(tmpDir, "protoc", "bin"))
+			t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+			t.Setenv("HOME", tmpDir)
+
+			output, err := protoc.NewCompiler().
+				WithProtoDir(workspace).
+				WithProtoWorkSpace(workspace).
+				WithImportPaths(filepath.Join(tmpDir, "third_party")).
+				WithOutputDir(filepath.Join(tmpDir, "gen")).
+				WithWellKnownTypes(true).
+				Compile()
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			// The bundled directory comes after the import paths
+			m := regexp.MustCompile(`third_party -I (\S+) `).FindStringSubmatch(output)
+			if !tt.bundled {
+				if m != nil {
+					t.Errorf("expected no bundled include directory, got %q", output)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatalf("expected a bundled include directory, got %q", output)
+			}
+			data, err := os.ReadFile(filepath.Join(filepath.FromSlash(m[1]), "google", "protobuf", "timestamp.proto"))
+			if err != nil || !strings.Contains(string(data), "message Timestamp") {
+				t.Errorf("expected timestamp.proto in %s: %v", m[1], err)
+			}
+		})
+	}
+}
+
+func TestWellKnownTypesGraph(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeStubProtoc(t, filepath.Join(tmpDir, "protoc"))
+	workspace := filepath.Join(tmpDir, "proto")
+	writeProtos(t, workspace, map[string]string{
+		"a.proto": `syntax = "proto3"; package a; import "google/protobuf/timestamp.proto"; message A { google.protobuf.Timestamp t = 1; }`,
+	})
+	t.Setenv("PATH", filepath.Join(tmpDir, "protoc", "bin"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+	t.Setenv("HOME", tmpDir)
+
+	for _, enabled := range []bool{false, true} {
+		graph, err := protoc.NewCompiler().
+			WithProtoDir(workspace).
+			WithProtoWorkSpace(workspace).
+			WithWellKnownTypes(enabled).
+			Graph(context.Background())
+		if err != nil {
+			t.Fatalf("Graph failed: %v", err)
+		}
+		node := graph.Nodes["google/protobuf/timestamp.proto"]
+		if !enabled {
+			if len(graph.Unresolved) != 1 || node != nil {
+				t.Errorf("expected timestamp.proto to be unresolved without the bundled types, got %+v", graph.Unresolved)
+			}
+			continue
+		}
+		if len(graph.Unresolved) != 0 || node == nil || !node.External {
+			t.Errorf("expected timestamp.proto to resolve to the bundled types, got %+v, %+v", graph.Unresolved, node)
+		}
+	}
+}